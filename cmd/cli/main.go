@@ -3,47 +3,153 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/joho/godotenv"
 	"github.com/takako/openai-go-demo/graph"
+	"github.com/takako/openai-go-demo/internal/config"
+	"github.com/takako/openai-go-demo/internal/telemetry"
 )
 
 func main() {
+	definitionFile := flag.String("graph", "", "path to a YAML/JSON graph definition (overrides graph.definition_file)")
+	checkpointStore := flag.String("checkpoint-store", "", "checkpoint store for resuming runs: none, memory, file or sqlite (overrides graph.checkpoint.store)")
+	checkpointPath := flag.String("checkpoint-path", "", "checkpoint directory (file) or database file (sqlite)")
+	review := flag.Bool("review", false, "pause to review search queries and approve the report before it is written")
+	traceDir := flag.String("trace-dir", "", "record every run as a trace file in this directory (overrides graph.trace_dir)")
+	export := flag.String("export", "", "print the graph topology as mermaid, dot or json, then exit")
+	maxTokens := flag.Int("max-tokens", 0, "token budget of a run (overrides graph.budget.max_tokens)")
+	maxCost := flag.Float64("max-cost", 0, "cost budget of a run in USD (overrides graph.budget.max_cost)")
+	replay := flag.String("replay", "", "re-run a recorded trace file with its recorded LLM and search responses, then exit")
+	fakeLLM := flag.Bool("fake-llm", false, "answer with a scripted fake LLM and simulated search, without API keys or network")
+	flag.Parse()
+
 	// Load environment variables
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found")
+	envErr := godotenv.Load()
+
+	// Load configuration (config file, environment and defaults)
+	var loadOpts []config.LoadOption
+	if *replay != "" {
+		loadOpts = append(loadOpts, config.ForReplay())
+	}
+	if *fakeLLM {
+		loadOpts = append(loadOpts, config.WithLLMProvider(graph.ProviderFake))
+	}
+	cfg, err := config.Load(loadOpts...)
+	if err != nil {
+		fatal("Failed to load configuration", err)
 	}
 
-	// Get API keys
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		log.Fatal("OPENAI_API_KEY environment variable is required")
+	// Log through slog as configured by logging.level and logging.format
+	logger, err := cfg.Logging.NewLogger(os.Stderr)
+	if err != nil {
+		fatal("Failed to set up logging", err)
+	}
+	slog.SetDefault(logger)
+	if envErr != nil {
+		slog.Info("No .env file found")
 	}
 	
-	serpAPIKey := os.Getenv("SERPAPI_KEY")
-	if serpAPIKey == "" {
-		log.Println("⚠️  SERPAPI_KEY not found - will use simulated search")
+	if *fakeLLM {
+		cfg.SerpAPI.APIKey = ""
+	}
+	model, _ := cfg.Provider().Model(cfg.LLMModel()) // validated by config.Load
+	slog.Info("🤖 Using LLM", "provider", cfg.LLM.Provider, "model", model)
+
+	if cfg.IsSerpAPIEnabled() {
+		slog.Info("✅ SerpAPI configured - real web search enabled")
 	} else {
-		log.Println("✅ SerpAPI configured - real web search enabled")
+		slog.Warn("⚠️  SERPAPI_KEY not found - will use simulated search")
+	}
+
+	// Command line flags take precedence over the configuration
+	if *definitionFile != "" {
+		cfg.Graph.DefinitionFile = *definitionFile
+		slog.Info("📐 Using graph definition", "path", *definitionFile)
+	}
+	if *checkpointStore != "" {
+		cfg.Graph.Checkpoint.Store = *checkpointStore
+	}
+	if *checkpointPath != "" {
+		cfg.Graph.Checkpoint.Path = *checkpointPath
+	}
+	if *traceDir != "" {
+		cfg.Graph.TraceDir = *traceDir
+	}
+	if *maxTokens > 0 {
+		cfg.Graph.Budget.MaxTokens = *maxTokens
+	}
+	if *maxCost > 0 {
+		cfg.Graph.Budget.MaxCost = *maxCost
+	}
+
+	// Limits, graph definition and checkpoint store come from the configuration
+	engineOpts, err := cfg.EngineOptions()
+	if err != nil {
+		fatal("Failed to configure engine", err)
+	}
+	resumable := cfg.Graph.Checkpoint.Store != "none" && cfg.Graph.Checkpoint.Store != ""
+
+	// Pause research runs for human review
+	if *review {
+		engineOpts = append(engineOpts,
+			graph.WithInterruptAfter("generate_search_queries"),
+			graph.WithInterruptBefore("synthesize_and_report"),
+		)
 	}
 
 	// Create graph engine
-	engine, err := graph.NewEngine(apiKey, serpAPIKey)
+	engine, err := graph.NewEngine(cfg.OpenAI.APIKey, cfg.SerpAPI.APIKey, engineOpts...)
 	if err != nil {
-		log.Fatalf("Failed to create engine: %v", err)
+		fatal("Failed to create engine", err)
 	}
 
 	// Create context
 	ctx := context.Background()
 
+	// Export spans of runs to an OpenTelemetry collector when enabled
+	shutdownTelemetry, err := telemetry.Setup(ctx, cfg.Telemetry)
+	if err != nil {
+		fatal("Failed to set up telemetry", err)
+	}
+	defer shutdownTelemetry(context.Background())
+
+	// Print the topology of the configured graph
+	if *export != "" {
+		if err := exportGraph(engine, *export); err != nil {
+			fatal("Failed to export graph", err)
+		}
+		return
+	}
+
+	// Replay a recorded run offline instead of starting the interactive mode
+	if *replay != "" {
+		trace, err := graph.LoadTrace(*replay)
+		if err != nil {
+			fatal("Failed to load trace", err)
+		}
+		result, err := engine.Replay(ctx, trace)
+		if err != nil {
+			displayFailure(result, err, false)
+			os.Exit(1)
+		}
+		displayResult(result)
+		return
+	}
+
 	fmt.Println("🤖 LangChainGo Research Assistant")
 	fmt.Println("================================")
 	fmt.Println("I can help you research topics, answer questions, or just chat!")
-	fmt.Println("Commands: 'exit' to quit, 'stream' to toggle streaming mode, Ctrl+C to force stop")
+	fmt.Println("Commands: 'exit' to quit, 'stream' to toggle streaming mode, 'resume <run-id>' to continue a failed, cancelled or paused run, 'history <run-id>' to list its steps, 'fork <run-id> <step>' to rerun it from a step with edits, Ctrl+C to cancel a running request")
 	fmt.Println()
 
 	// Interactive mode
@@ -71,66 +177,379 @@ func main() {
 			continue
 		}
 
+		// Continue a checkpointed run instead of starting a new one
+		resumeID := ""
+		if strings.HasPrefix(input, "resume ") {
+			resumeID = strings.TrimSpace(strings.TrimPrefix(input, "resume "))
+		}
+
+		// Show the steps of a past run, or start a new run from one of them
+		if strings.HasPrefix(input, "history ") {
+			showHistory(ctx, engine, strings.TrimSpace(strings.TrimPrefix(input, "history ")))
+			continue
+		}
+		var fork *forkPoint
+		var resumeOpts []graph.ResumeOption
+		if strings.HasPrefix(input, "fork ") {
+			point, state, ok := prepareFork(ctx, scanner, engine, strings.TrimPrefix(input, "fork "))
+			if !ok {
+				continue
+			}
+			fork = point
+			resumeOpts = []graph.ResumeOption{graph.WithEditedState(state)}
+		}
+
 		// Execute the graph
 		fmt.Println("\n🔄 Processing your request...")
-		
-		if streamingMode {
-			// Execute with streaming updates
-			updates := make(chan graph.GraphUpdate, 10)
-			
-			// Start a goroutine to handle updates
-			go func() {
-				var currentNodeOutput strings.Builder
-				var currentNode string
-				
-				for update := range updates {
-					switch update.Type {
-					case "node_start":
-						fmt.Printf("📍 %s: Starting...\n", update.Node)
-						currentNode = update.Node
-						currentNodeOutput.Reset()
-					case "streaming_chunk":
-						// Real-time streaming output
-						if update.Node == currentNode {
-							fmt.Print(update.Chunk)
-							currentNodeOutput.WriteString(update.Chunk)
-						}
-					case "node_complete":
-						fmt.Printf("\n✅ %s: Completed\n", update.Node)
-					case "error":
-						fmt.Printf("❌ Error in %s: %v\n", update.Node, update.Error)
-					}
+
+		for {
+			// Ctrl+C cancels the running request instead of quitting
+			runCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+			result, err := execute(runCtx, engine, input, resumeID, fork, resumeOpts, streamingMode)
+			stop()
+
+			// Interrupted runs continue once the user has reviewed them
+			var interrupted *graph.InterruptedError
+			if errors.As(err, &interrupted) {
+				state, ok := reviewInterrupt(scanner, interrupted)
+				if !ok {
+					fmt.Printf("⏸️  Run paused - type 'resume %s' to continue without changes\n", interrupted.RunID)
+					break
 				}
-			}()
-			
-			result, err := engine.StreamExecute(ctx, input, updates)
-			if err != nil {
-				fmt.Printf("\n❌ Execution failed: %v\n", err)
-			} else {
-				displayResult(result)
+				resumeID, fork = interrupted.RunID, nil
+				resumeOpts = []graph.ResumeOption{graph.WithEditedState(state)}
+				continue
 			}
-		} else {
-			// Execute without streaming
-			result, err := engine.Execute(ctx, input)
+
 			if err != nil {
-				fmt.Printf("\n❌ Execution failed: %v\n", err)
+				displayFailure(result, err, resumable)
 			} else {
 				displayResult(result)
 			}
+			break
 		}
 		
 		fmt.Println()
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Fatalf("Error reading input: %v", err)
+		fatal("Error reading input", err)
+	}
+}
+
+// exportGraph prints the engine's topology in the given format
+func exportGraph(engine *graph.Engine, format string) error {
+	switch format {
+	case "mermaid":
+		fmt.Print(engine.Mermaid())
+	case "dot":
+		fmt.Print(engine.DOT())
+	case "json":
+		data, err := json.MarshalIndent(engine.Topology(), "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	default:
+		return fmt.Errorf("unknown format %q, want mermaid, dot or json", format)
+	}
+	return nil
+}
+
+// forkPoint is the step of a past run a new run starts from
+type forkPoint struct {
+	runID string
+	step  int
+}
+
+// execute starts a new run for input, forks a past run when fork is set, or continues resumeID when set
+func execute(ctx context.Context, engine *graph.Engine, input, resumeID string, fork *forkPoint, opts []graph.ResumeOption, streamingMode bool) (*graph.ExecutionResult, error) {
+	if !streamingMode {
+		// Execute without streaming
+		if fork != nil {
+			return engine.Fork(ctx, fork.runID, fork.step, opts...)
+		}
+		if resumeID != "" {
+			return engine.Resume(ctx, resumeID, opts...)
+		}
+		return engine.Execute(ctx, input)
+	}
+
+	// Execute with streaming updates
+	updates := make(chan graph.GraphUpdate, 10)
+	done := make(chan struct{})
+	
+	// Start a goroutine to handle updates
+	go func() {
+		defer close(done)
+		var currentNodeOutput strings.Builder
+		var currentNode string
+		
+		for update := range updates {
+			switch update.Type {
+			case "node_start":
+				fmt.Printf("📍 %s: Starting...\n", update.Node)
+				currentNode = update.Node
+				currentNodeOutput.Reset()
+			case "streaming_chunk":
+				// Real-time streaming output
+				if update.Node == currentNode {
+					fmt.Print(update.Chunk)
+					currentNodeOutput.WriteString(update.Chunk)
+				}
+			case "node_complete":
+				fmt.Printf("\n✅ %s: Completed\n", update.Node)
+			case "error":
+				fmt.Printf("❌ Error in %s: %v\n", update.Node, update.Error)
+			case "cancelled":
+				fmt.Printf("\n⏹️  Cancelled during %s\n", update.Node)
+			case "retry":
+				fmt.Printf("\n🔁 %s: retrying (attempt %d) after: %v\n", update.Node, update.Attempt, update.Error)
+			}
+		}
+	}()
+
+	var result *graph.ExecutionResult
+	var err error
+	if fork != nil {
+		result, err = engine.StreamFork(ctx, fork.runID, fork.step, updates, opts...)
+	} else if resumeID != "" {
+		result, err = engine.StreamResume(ctx, resumeID, updates, opts...)
+	} else {
+		result, err = engine.StreamExecute(ctx, input, updates)
+	}
+	<-done
+	return result, err
+}
+
+// reviewInterrupt lets the user edit the pending state of an interrupted run:
+// the search queries after they are generated, and the outline before the report
+// is written. It returns false when the user wants to stop here.
+func reviewInterrupt(scanner *bufio.Scanner, interrupted *graph.InterruptedError) (*graph.AppState, bool) {
+	state := interrupted.State
+	fmt.Printf("\n⏸️  Paused %s %s (run %s)\n", interrupted.Interrupt.When, interrupted.Interrupt.Node, interrupted.RunID)
+
+	switch {
+	case interrupted.Interrupt.Node == "generate_search_queries":
+		// Edit the search queries before any search fires
+		queries, ok := editList(scanner, "🔍 Search queries", "query", state.GetSearchQueries())
+		state.SearchQueries = queries
+		return state, ok
+	case len(state.GetOutline()) > 0:
+		// Edit the outline the report is written along
+		fmt.Printf("📋 Topic: %s\n", state.Topic)
+		fmt.Printf("📚 Sources: %d\n", len(state.GetRawContents()))
+		outline, ok := editList(scanner, "📝 Report outline", "heading", state.GetOutline())
+		state.Outline = outline
+		return state, ok
+	}
+
+	fmt.Printf("📋 Topic: %s\n", state.Topic)
+	fmt.Printf("📚 Sources: %d\n", len(state.GetRawContents()))
+	fmt.Print("Continue? [Y/n] ")
+	if !scanner.Scan() {
+		return nil, false
+	}
+	answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+	return state, answer == "" || answer == "y" || answer == "yes"
+}
+
+// editList lets the user add, delete and replace the items of a list.
+// It returns false when the user wants to stop here.
+func editList(scanner *bufio.Scanner, title, noun string, items []string) ([]string, bool) {
+	for {
+		fmt.Printf("\n%s:\n", title)
+		for i, item := range items {
+			fmt.Printf("   %d. %s\n", i+1, item)
+		}
+		fmt.Printf("Enter 'ok' to continue, '+<%s>' to add, '-<n>' to delete, '<n>: <%s>' to replace, 'abort' to stop\n", noun, noun)
+		fmt.Print("review> ")
+		if !scanner.Scan() {
+			return nil, false
+		}
+
+		input := strings.TrimSpace(scanner.Text())
+		switch {
+		case input == "" || input == "ok":
+			return items, true
+		case input == "abort":
+			return nil, false
+		case strings.HasPrefix(input, "+"):
+			if item := strings.TrimSpace(input[1:]); item != "" {
+				items = append(items, item)
+			}
+		case strings.HasPrefix(input, "-"):
+			if i, ok := queryIndex(input[1:], len(items)); ok {
+				items = append(items[:i], items[i+1:]...)
+			} else {
+				fmt.Printf("⚠️  No such %s\n", noun)
+			}
+		case strings.Contains(input, ":"):
+			parts := strings.SplitN(input, ":", 2)
+			if i, ok := queryIndex(parts[0], len(items)); ok && strings.TrimSpace(parts[1]) != "" {
+				items[i] = strings.TrimSpace(parts[1])
+			} else {
+				fmt.Printf("⚠️  No such %s\n", noun)
+			}
+		default:
+			fmt.Println("⚠️  Unknown command")
+		}
+	}
+}
+
+// showHistory lists the steps a run can be forked from
+func showHistory(ctx context.Context, engine *graph.Engine, runID string) {
+	history, err := engine.History(ctx, runID)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+
+	fmt.Printf("\n🕰️  Steps of run %s:\n", runID)
+	for _, checkpoint := range history {
+		after := checkpoint.Node
+		if after == "" {
+			after = "(start)"
+		}
+		next := checkpoint.NextNode
+		if next == "" {
+			next = "(end)"
+		}
+		fmt.Printf("   %d. after %s → %s [%s] topic: %q, sources: %d\n",
+			checkpoint.Step, after, next, checkpoint.Status, checkpoint.State.Topic, len(checkpoint.State.GetRawContents()))
+	}
+	if len(history) > 0 && history[0].ParentRunID != "" {
+		fmt.Printf("🍴 Forked from run %s at step %d\n", history[0].ParentRunID, history[0].ParentStep)
+	}
+}
+
+// prepareFork loads the state after a step of a past run and lets the user edit it.
+// It returns false when the fork is abandoned.
+func prepareFork(ctx context.Context, scanner *bufio.Scanner, engine *graph.Engine, args string) (*forkPoint, *graph.AppState, bool) {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		fmt.Println("⚠️  Usage: fork <run-id> <step>")
+		return nil, nil, false
+	}
+	step, err := strconv.Atoi(fields[1])
+	if err != nil {
+		fmt.Println("⚠️  Usage: fork <run-id> <step>")
+		return nil, nil, false
+	}
+
+	history, err := engine.History(ctx, fields[0])
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return nil, nil, false
+	}
+	for _, checkpoint := range history {
+		if checkpoint.Step == step {
+			state, ok := editForkState(scanner, checkpoint.State)
+			return &forkPoint{runID: fields[0], step: step}, state, ok
+		}
+	}
+	fmt.Printf("⚠️  Run %s has no step %d - type 'history %s' to list its steps\n", fields[0], step, fields[0])
+	return nil, nil, false
+}
+
+// editForkState lets the user change the topic and drop search results before forking.
+// It returns false when the user wants to stop here.
+func editForkState(scanner *bufio.Scanner, state *graph.AppState) (*graph.AppState, bool) {
+	for {
+		sources := state.GetRawContents()
+		names := make([]string, 0, len(sources))
+		for name := range sources {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		fmt.Printf("\n📋 Topic: %s\n", state.Topic)
+		fmt.Println("📚 Sources:")
+		for i, name := range names {
+			fmt.Printf("   %d. %s\n", i+1, name)
+		}
+		fmt.Println("Enter 'ok' to fork, 'topic <text>' to change the topic, '-<n>' to drop a source, 'abort' to stop")
+		fmt.Print("fork> ")
+		if !scanner.Scan() {
+			return nil, false
+		}
+
+		input := strings.TrimSpace(scanner.Text())
+		switch {
+		case input == "ok":
+			return state, true
+		case input == "abort":
+			return nil, false
+		case strings.HasPrefix(input, "topic "):
+			state.SetTopic(strings.TrimSpace(strings.TrimPrefix(input, "topic ")))
+		case strings.HasPrefix(input, "-"):
+			if i, ok := queryIndex(input[1:], len(names)); ok {
+				delete(sources, names[i])
+				state.RawContents = sources
+			} else {
+				fmt.Println("⚠️  No such source")
+			}
+		default:
+			fmt.Println("⚠️  Unknown command")
+		}
+	}
+}
+
+// queryIndex parses a 1-based item number into an index
+func queryIndex(s string, count int) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 1 || n > count {
+		return 0, false
+	}
+	return n - 1, true
+}
+
+func displayFailure(result *graph.ExecutionResult, err error, resumable bool) {
+	if errors.Is(err, graph.ErrCancelled) {
+		fmt.Printf("\n⏹️  Execution cancelled: %v\n", err)
+	} else if errors.Is(err, graph.ErrBudgetExceeded) {
+		fmt.Printf("\n💸 Execution stopped: %v\n", err)
+	} else if errors.Is(err, graph.ErrTimeout) {
+		fmt.Printf("\n⏰ Execution timed out: %v\n", err)
+	} else {
+		fmt.Printf("\n❌ Execution failed: %v\n", err)
+	}
+	if resumable && result != nil && result.RunID != "" {
+		fmt.Printf("💾 Progress saved - type 'resume %s' to continue from the last successful node\n", result.RunID)
+	}
+	if result != nil {
+		displayUsage(result.Usage)
+	}
+}
+
+// displayUsage prints the tokens and cost of a run, broken down by node
+func displayUsage(usage graph.Usage) {
+	if usage.TotalTokens() == 0 {
+		return
+	}
+	fmt.Printf("🪙 Tokens: %d (prompt %d, completion %d) - $%.4f\n",
+		usage.TotalTokens(), usage.PromptTokens, usage.CompletionTokens, usage.Cost)
+
+	nodes := make([]string, 0, len(usage.Nodes))
+	for node := range usage.Nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		nodeUsage := usage.Nodes[node]
+		fmt.Printf("   %-40s %6d tokens  $%.4f\n", node, nodeUsage.TotalTokens(), nodeUsage.Cost)
+	}
+	if usage.BudgetExceeded {
+		fmt.Println("💸 The run used up its budget")
 	}
 }
 
 func displayResult(result *graph.ExecutionResult) {
-	fmt.Printf("\n⏱️  Execution time: %v\n", result.ExecutionTime)
+	fmt.Printf("\n🆔 Run ID: %s\n", result.RunID)
+	fmt.Printf("⏱️  Execution time: %v\n", result.ExecutionTime)
 	fmt.Printf("📊 Steps executed: %d\n", result.StepsExecuted)
 	fmt.Printf("🛤️  Path: %s\n", strings.Join(result.Path, " → "))
+	displayUsage(result.Usage)
 	
 	state := result.FinalState
 	
@@ -168,8 +587,8 @@ func displayResult(result *graph.ExecutionResult) {
 	}
 }
 
-func init() {
-	// Set up logging
-	log.SetFlags(log.Ltime | log.Lshortfile)
-	log.SetPrefix("[Graph] ")
+// fatal logs an error the CLI cannot continue after and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
\ No newline at end of file
@@ -4,10 +4,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"syscall/js"
 	"time"
 
 	"github.com/takako/openai-go-demo/graph"
+	"github.com/takako/openai-go-demo/internal/config"
 )
 
 var engine *graph.Engine
@@ -51,8 +53,24 @@ func initializeEngine(this js.Value, args []js.Value) interface{} {
 		serpAPIKey = args[1].String()
 	}
 	
-	var err error
-	engine, err = graph.NewEngine(apiKey, serpAPIKey)
+	// Limits and the LLM provider come from the default configuration, optionally
+	// overridden by a third argument: { maxSteps, timeoutSeconds, nodeTimeoutSeconds,
+	// provider, model, baseUrl }
+	cfg := config.Defaults()
+	cfg.OpenAI.APIKey = apiKey
+	if len(args) > 2 && args[2].Type() == js.TypeObject {
+		applyJSConfig(cfg, args[2])
+	}
+	
+	engineOpts, err := cfg.EngineOptions()
+	if err != nil {
+		return js.ValueOf(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	
+	engine, err = graph.NewEngine(apiKey, serpAPIKey, engineOpts...)
 	if err != nil {
 		return js.ValueOf(map[string]interface{}{
 			"success": false,
@@ -66,6 +84,30 @@ func initializeEngine(this js.Value, args []js.Value) interface{} {
 	})
 }
 
+// applyJSConfig overrides engine limits with the options passed from JavaScript
+func applyJSConfig(cfg *config.Config, options js.Value) {
+	if v := options.Get("maxSteps"); v.Type() == js.TypeNumber {
+		cfg.Graph.MaxSteps = v.Int()
+	}
+	if v := options.Get("timeoutSeconds"); v.Type() == js.TypeNumber {
+		cfg.Graph.Timeout = v.Int()
+	}
+	if v := options.Get("nodeTimeoutSeconds"); v.Type() == js.TypeNumber {
+		cfg.Graph.NodeTimeout = v.Int()
+	}
+	if v := options.Get("provider"); v.Type() == js.TypeString {
+		// The first argument is the key of the selected provider
+		cfg.LLM.Provider = v.String()
+		cfg.LLM.APIKey = cfg.OpenAI.APIKey
+	}
+	if v := options.Get("model"); v.Type() == js.TypeString {
+		cfg.LLM.Model = v.String()
+	}
+	if v := options.Get("baseUrl"); v.Type() == js.TypeString {
+		cfg.LLM.BaseURL = v.String()
+	}
+}
+
 // startResearch starts a research query
 func startResearch(this js.Value, args []js.Value) interface{} {
 	if engine == nil {
@@ -140,8 +182,9 @@ func startResearch(this js.Value, args []js.Value) interface{} {
 		if err != nil {
 			if callback.Type() == js.TypeFunction {
 				callback.Invoke(js.ValueOf(map[string]interface{}{
-					"type":  "error",
-					"error": err.Error(),
+					"type":    "error",
+					"error":   err.Error(),
+					"timeout": errors.Is(err, graph.ErrTimeout),
 				}))
 			}
 			return
@@ -2,16 +2,26 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
 	"github.com/takako/openai-go-demo/graph"
 	"github.com/takako/openai-go-demo/internal/config"
+	"github.com/takako/openai-go-demo/internal/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
 )
 
 var upgrader = websocket.Upgrader{
@@ -21,39 +31,108 @@ var upgrader = websocket.Upgrader{
 }
 
 type WebSocketMessage struct {
-	Type  string `json:"type"`
-	Query string `json:"query,omitempty"`
+	Type  string          `json:"type"`
+	Query string          `json:"query,omitempty"`
+	RunID string          `json:"run_id,omitempty"`
+	State *graph.AppState `json:"state,omitempty"` // reviewed state when resuming an interrupted run or forking
+	Step  int             `json:"step,omitempty"`  // step of the run to fork from
 }
 
 type WebSocketResponse struct {
-	Type      string `json:"type"`
-	Node      string `json:"node,omitempty"`
-	Chunk     string `json:"chunk,omitempty"`
-	Error     string `json:"error,omitempty"`
-	Timestamp int64  `json:"timestamp"`
+	Type      string              `json:"type"`
+	RunID     string              `json:"run_id,omitempty"`
+	Node      string              `json:"node,omitempty"`
+	Path      []string            `json:"path,omitempty"` // nested node path inside subgraphs
+	Chunk     string              `json:"chunk,omitempty"`
+	Error     string              `json:"error,omitempty"`
+	Timeout   bool                `json:"timeout,omitempty"` // the error is a run or node timeout
+	Attempt   int                 `json:"attempt,omitempty"` // attempt about to start for retry messages
+	Interrupt *graph.Interrupt    `json:"interrupt,omitempty"`
+	State     *graph.AppState     `json:"state,omitempty"`   // full state on start, interrupt and snapshot messages
+	Patch     []graph.PatchOp     `json:"patch,omitempty"`   // changes since the previous message of the run
+	Version   int                 `json:"version,omitempty"` // state version after patch
+	History   []*graph.Checkpoint `json:"history,omitempty"` // steps of a run for history messages
+	Usage     *graph.Usage        `json:"usage,omitempty"`   // tokens and cost of the run so far
+	Timestamp int64               `json:"timestamp"`
+}
+
+// wsConn serializes writes of the runs and replies sharing one connection
+type wsConn struct {
+	*websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *wsConn) WriteJSON(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.WriteJSON(v)
 }
 
 func main() {
+	fakeLLM := flag.Bool("fake-llm", false, "answer with a scripted fake LLM and simulated search, without API keys or network")
+	flag.Parse()
+
 	// Load .env file first (for backward compatibility)
 	loadLegacyDotEnv()
 	
 	// Load configuration using viper
-	cfg, err := config.Load()
+	var loadOpts []config.LoadOption
+	if *fakeLLM {
+		loadOpts = append(loadOpts, config.WithLLMProvider(graph.ProviderFake))
+	}
+	cfg, err := config.Load(loadOpts...)
+	if err != nil {
+		fatal("Failed to load configuration", err)
+	}
+
+	// Log through slog as configured by logging.level and logging.format
+	logger, err := cfg.Logging.NewLogger(os.Stderr)
+	if err != nil {
+		fatal("Failed to set up logging", err)
+	}
+	slog.SetDefault(logger)
+
+	// Export spans of runs to an OpenTelemetry collector when enabled
+	shutdownTelemetry, err := telemetry.Setup(context.Background(), cfg.Telemetry)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("Failed to set up telemetry", err)
 	}
 
 	// Log SerpAPI status
+	if *fakeLLM {
+		cfg.SerpAPI.APIKey = ""
+	}
+	model, _ := cfg.Provider().Model(cfg.LLMModel()) // validated by config.Load
+	slog.Info("🤖 Using LLM", "provider", cfg.LLM.Provider, "model", model)
+
 	if cfg.IsSerpAPIEnabled() {
-		log.Println("✅ SerpAPI configured - real web search enabled")
+		slog.Info("✅ SerpAPI configured - real web search enabled")
 	} else {
-		log.Println("⚠️  SERPAPI_KEY not found - will use simulated search")
+		slog.Warn("⚠️  SERPAPI_KEY not found - will use simulated search")
+	}
+
+	// Serve run and server metrics for Prometheus
+	if cfg.Server.MetricsPath != "" {
+		metricsHandler, err := telemetry.MetricsHandler()
+		if err != nil {
+			fatal("Failed to set up metrics", err)
+		}
+		http.Handle(cfg.Server.MetricsPath, metricsHandler)
+	}
+	connections, err := otel.Meter("github.com/takako/openai-go-demo/cmd/web").Int64UpDownCounter(
+		"web.websocket.connections", metric.WithDescription("Open WebSocket connections"))
+	if err != nil {
+		fatal("Failed to create connection metric", err)
 	}
 
 	// Create graph engine
-	engine, err := graph.NewEngine(cfg.OpenAI.APIKey, cfg.SerpAPI.APIKey)
+	engineOpts, err := cfg.EngineOptions()
 	if err != nil {
-		log.Fatalf("Failed to create engine: %v", err)
+		fatal("Failed to load graph definition", err)
+	}
+	engine, err := graph.NewEngine(cfg.OpenAI.APIKey, cfg.SerpAPI.APIKey, engineOpts...)
+	if err != nil {
+		fatal("Failed to create engine", err)
 	}
 
 	// Static file server for CSS/JS assets
@@ -63,12 +142,45 @@ func main() {
 	// Main routes
 	http.HandleFunc("/", serveHome)
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		handleWebSocket(w, r, engine)
+		handleWebSocket(w, r, engine, connections)
+	})
+	http.HandleFunc("/api/graph", func(w http.ResponseWriter, r *http.Request) {
+		serveGraph(w, r, engine)
 	})
 
 	fmt.Printf("🌐 Web Server starting on http://localhost:%s\n", cfg.Server.Port)
 	fmt.Printf("📊 Graph Visualizer: http://localhost:%s\n", cfg.Server.Port)
-	log.Fatal(http.ListenAndServe(":"+cfg.Server.Port, nil))
+	server := &http.Server{Addr: ":" + cfg.Server.Port}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	// Serve until Ctrl+C or SIGTERM, then flush the spans still buffered
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-serverErr:
+		shutdownTelemetry(context.Background())
+		fatal("Web server stopped", err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down web server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Failed to shut down web server", "error", err)
+	}
+	if err := shutdownTelemetry(shutdownCtx); err != nil {
+		slog.Warn("Failed to flush telemetry", "error", err)
+	}
+}
+
+// fatal logs an error the server cannot continue after and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
 
 // loadLegacyDotEnv maintains backward compatibility with existing .env setup
@@ -82,7 +194,7 @@ func loadLegacyDotEnv() {
 	
 	for _, envPath := range possiblePaths {
 		if err := godotenv.Load(envPath); err == nil {
-			log.Printf("Loaded .env from: %s", envPath)
+			slog.Info("Loaded .env", "path", envPath)
 			return
 		}
 	}
@@ -97,35 +209,149 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, htmlPath)
 }
 
-func handleWebSocket(w http.ResponseWriter, r *http.Request, engine *graph.Engine) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+// serveGraph describes the engine's topology as JSON, or as Mermaid or DOT with ?format=mermaid|dot
+func serveGraph(w http.ResponseWriter, r *http.Request, engine *graph.Engine) {
+	switch r.URL.Query().Get("format") {
+	case "mermaid":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, engine.Mermaid())
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		fmt.Fprint(w, engine.DOT())
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(engine.Topology()); err != nil {
+			slog.Error("Failed to encode graph topology", "error", err)
+		}
+	default:
+		http.Error(w, "unknown format, want json, mermaid or dot", http.StatusBadRequest)
+	}
+}
+
+func handleWebSocket(w http.ResponseWriter, r *http.Request, engine *graph.Engine, connections metric.Int64UpDownCounter) {
+	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade failed: %v", err)
+		slog.Warn("WebSocket upgrade failed", "error", err)
 		return
 	}
-	defer conn.Close()
+	defer ws.Close()
+	conn := &wsConn{Conn: ws}
+
+	connections.Add(r.Context(), 1)
+	defer connections.Add(context.Background(), -1)
+
+	slog.Info("📡 New WebSocket connection", "remote_addr", r.RemoteAddr)
 
-	log.Printf("📡 New WebSocket connection from %s", r.RemoteAddr)
+	// Runs started by this client stop when it disconnects
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	for {
 		var msg WebSocketMessage
 		err := conn.ReadJSON(&msg)
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+				slog.Warn("WebSocket error", "error", err)
 			}
 			break
 		}
 
-		if msg.Type == "research" && msg.Query != "" {
-			go handleResearchRequest(conn, engine, msg.Query)
+		switch {
+		case msg.Type == "research" && msg.Query != "":
+			go handleResearchRequest(ctx, conn, engine, msg.Query)
+		case msg.Type == "resume" && msg.RunID != "":
+			go handleResumeRequest(ctx, conn, engine, msg.RunID, msg.State)
+		case msg.Type == "fork" && msg.RunID != "":
+			go handleForkRequest(ctx, conn, engine, msg.RunID, msg.Step, msg.State)
+		case msg.Type == "history" && msg.RunID != "":
+			sendHistory(ctx, conn, engine, msg.RunID)
+		case msg.Type == "snapshot" && msg.RunID != "":
+			// Clients that missed a patch resynchronize from the latest state
+			sendSnapshot(conn, engine, msg.RunID)
+		case msg.Type == "cancel" && msg.RunID != "":
+			// The run reports its cancellation through its own update stream
+			if !engine.Cancel(msg.RunID) {
+				slog.Info("Cancel requested for inactive run", "run_id", msg.RunID)
+			}
 		}
 	}
 }
 
-func handleResearchRequest(conn *websocket.Conn, engine *graph.Engine, query string) {
-	ctx := context.Background()
-	
+func handleResearchRequest(ctx context.Context, conn *wsConn, engine *graph.Engine, query string) {
+	// Execute the research with streaming updates
+	slog.InfoContext(ctx, "🔍 Starting research", "query", query)
+	streamRun(ctx, conn, func(ctx context.Context, updates chan graph.GraphUpdate) (*graph.ExecutionResult, error) {
+		return engine.StreamExecute(ctx, query, updates)
+	})
+}
+
+func handleResumeRequest(ctx context.Context, conn *wsConn, engine *graph.Engine, runID string, state *graph.AppState) {
+	slog.InfoContext(ctx, "🔁 Resuming run", "run_id", runID)
+
+	// An interrupted run continues with the state the user reviewed
+	var opts []graph.ResumeOption
+	if state != nil {
+		opts = append(opts, graph.WithEditedState(state))
+	}
+
+	streamRun(ctx, conn, func(ctx context.Context, updates chan graph.GraphUpdate) (*graph.ExecutionResult, error) {
+		return engine.StreamResume(ctx, runID, updates, opts...)
+	})
+}
+
+func handleForkRequest(ctx context.Context, conn *wsConn, engine *graph.Engine, runID string, step int, state *graph.AppState) {
+	slog.InfoContext(ctx, "🍴 Forking run", "run_id", runID, "step", step)
+
+	// The fork starts from the state the user edited, if any
+	var opts []graph.ResumeOption
+	if state != nil {
+		opts = append(opts, graph.WithEditedState(state))
+	}
+
+	streamRun(ctx, conn, func(ctx context.Context, updates chan graph.GraphUpdate) (*graph.ExecutionResult, error) {
+		return engine.StreamFork(ctx, runID, step, updates, opts...)
+	})
+}
+
+// sendHistory sends the checkpoints of every step of a run
+func sendHistory(ctx context.Context, conn *wsConn, engine *graph.Engine, runID string) {
+	history, err := engine.History(ctx, runID)
+	response := WebSocketResponse{
+		Type:      "history",
+		RunID:     runID,
+		History:   history,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	if err := conn.WriteJSON(response); err != nil {
+		slog.Warn("Failed to send WebSocket message", "error", err)
+	}
+}
+
+// sendSnapshot sends the full state of an in-flight run
+func sendSnapshot(conn *wsConn, engine *graph.Engine, runID string) {
+	state, version, err := engine.Snapshot(runID)
+	response := WebSocketResponse{
+		Type:      "snapshot",
+		RunID:     runID,
+		State:     state,
+		Version:   version,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	if err := conn.WriteJSON(response); err != nil {
+		slog.Warn("Failed to send WebSocket message", "error", err)
+	}
+}
+
+// streamRun executes a run and forwards its graph updates to the WebSocket client
+func streamRun(ctx context.Context, conn *wsConn, execute func(ctx context.Context, updates chan graph.GraphUpdate) (*graph.ExecutionResult, error)) {
 	// Create a channel for graph updates
 	updates := make(chan graph.GraphUpdate, 100)
 	
@@ -133,39 +359,78 @@ func handleResearchRequest(conn *websocket.Conn, engine *graph.Engine, query str
 	done := make(chan bool)
 	go func() {
 		defer func() { done <- true }()
+		failed := false
 		for update := range updates {
+			// Keep draining after a failed write so the engine never blocks on a full channel
+			if failed {
+				continue
+			}
 			wsResponse := WebSocketResponse{
 				Type:      update.Type,
+				RunID:     update.RunID,
 				Node:      update.Node,
+				Path:      update.Path,
 				Chunk:     update.Chunk,
+				Attempt:   update.Attempt,
+				State:     update.State,
+				Patch:     update.Patch,
+				Version:   update.Version,
+				Usage:     update.Usage,
 				Timestamp: update.Timestamp.UnixMilli(),
 			}
 			
 			if update.Error != nil {
 				wsResponse.Error = update.Error.Error()
+				wsResponse.Timeout = errors.Is(update.Error, graph.ErrTimeout)
+			}
+			
+			// Interrupted runs send their pending state for review
+			if update.Type == "interrupt" {
+				wsResponse.Interrupt = update.Interrupt
 			}
 			
 			// Send update to WebSocket client
 			if err := conn.WriteJSON(wsResponse); err != nil {
-				log.Printf("Failed to send WebSocket message: %v", err)
-				return
+				slog.Warn("Failed to send WebSocket message", "error", err)
+				failed = true
 			}
 		}
 	}()
 	
-	// Execute the research with streaming updates
-	log.Printf("🔍 Starting research for query: %s", query)
-	
-	result, err := engine.StreamExecute(ctx, query, updates)
+	result, err := execute(ctx, updates)
 	
-	// StreamExecute already closes the updates channel, so we don't send more messages
+	// The engine already closes the updates channel, so we don't send more messages
 	// Just log the result
-	if err != nil {
-		log.Printf("Research execution failed: %v", err)
+	logger := slog.Default()
+	if result != nil {
+		logger = logger.With("run_id", result.RunID)
+	}
+	var interrupted *graph.InterruptedError
+	if errors.As(err, &interrupted) {
+		logger.InfoContext(ctx, "⏸️  Run waiting for review", "when", interrupted.Interrupt.When, "node", interrupted.Interrupt.Node)
+	} else if errors.Is(err, graph.ErrCancelled) {
+		logger.InfoContext(ctx, "⏹️  Research execution cancelled", "error", err)
+	} else if errors.Is(err, graph.ErrTimeout) {
+		logger.WarnContext(ctx, "⏰ Research execution timed out", "error", err)
+	} else if errors.Is(err, graph.ErrBudgetExceeded) {
+		logger.WarnContext(ctx, "💸 Research execution stopped by its budget", "error", err)
+	} else if err != nil {
+		logger.ErrorContext(ctx, "Research execution failed", "error", err)
 	} else {
-		log.Printf("✅ Research completed in %v", result.ExecutionTime)
+		logger.InfoContext(ctx, "✅ Research completed", "intent", result.FinalState.GetIntent(),
+			"duration", result.ExecutionTime, "tokens", result.Usage.TotalTokens(), "cost", result.Usage.Cost)
 	}
 	
 	// Wait for the WebSocket goroutine to finish processing all messages
 	<-done
-}
\ No newline at end of file
+	
+	// Runs that never started (e.g. unknown run ID) produced no updates
+	if err != nil && result == nil {
+		conn.WriteJSON(WebSocketResponse{
+			Type:      "error",
+			Error:     err.Error(),
+			Timeout:   errors.Is(err, graph.ErrTimeout),
+			Timestamp: time.Now().UnixMilli(),
+		})
+	}
+}
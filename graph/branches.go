@@ -0,0 +1,130 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// FanOut splits the state into parallel branch payloads and merges the branch results back
+type FanOut struct {
+	// Split returns one payload per branch to run
+	Split func(state *AppState) ([]interface{}, error)
+	// Reduce merges the finished branches into the parent state
+	Reduce func(state *AppState, results []BranchResult) error
+}
+
+// Branch identifies one parallel branch of a fan-out
+type Branch struct {
+	Name    string      // branch point name
+	ID      string      // unique branch id, e.g. "search_query_1"
+	Index   int         // position of the payload returned by Split
+	Payload interface{} // payload returned by Split
+}
+
+// BranchResult is the outcome of a single branch
+type BranchResult struct {
+	Branch
+	State *AppState // branch-local copy of the state after the node ran
+	Err   error
+}
+
+type branchContextKey struct{}
+
+// withBranch attaches the branch being executed to the context
+func withBranch(ctx context.Context, branch Branch) context.Context {
+	return context.WithValue(ctx, branchContextKey{}, branch)
+}
+
+// BranchFromContext returns the branch a node is running in, if any
+func BranchFromContext(ctx context.Context) (Branch, bool) {
+	branch, ok := ctx.Value(branchContextKey{}).(Branch)
+	return branch, ok
+}
+
+// BranchRegistry manages fan-out logic for dynamic branch points
+type BranchRegistry struct {
+	fanOuts map[string]FanOut
+}
+
+// NewBranchRegistry creates a new branch registry
+func NewBranchRegistry() *BranchRegistry {
+	registry := &BranchRegistry{
+		fanOuts: make(map[string]FanOut),
+	}
+
+	// Register all fan-outs
+	registry.RegisterFanOut("search_queries", FanOut{
+		Split:  SplitSearchQueries,
+		Reduce: MergeSearchBranches,
+	})
+
+	return registry
+}
+
+// RegisterFanOut registers a fan-out with a name
+func (r *BranchRegistry) RegisterFanOut(name string, fanOut FanOut) {
+	r.fanOuts[name] = fanOut
+}
+
+// GetFanOut retrieves a fan-out by name
+func (r *BranchRegistry) GetFanOut(name string) (FanOut, bool) {
+	fanOut, exists := r.fanOuts[name]
+	return fanOut, exists
+}
+
+// SearchedQueriesKey counts the search queries earlier fan-outs searched, so a
+// run looping back for more queries only searches the new ones
+var SearchedQueriesKey = NewKey[int]("searched_queries", Replace[int]())
+
+// SplitSearchQueries creates one branch per search query not searched yet
+func SplitSearchQueries(state *AppState) ([]interface{}, error) {
+	queries := state.GetSearchQueries()
+	searched := min(Get(state, SearchedQueriesKey), len(queries))
+	if len(queries) == searched {
+		return nil, fmt.Errorf("no new search queries available for branching")
+	}
+
+	payloads := make([]interface{}, 0, len(queries)-searched)
+	for _, q := range queries[searched:] {
+		payloads = append(payloads, q)
+	}
+	return payloads, nil
+}
+
+// MergeSearchBranches merges the branches of SplitSearchQueries and marks their
+// queries as searched
+func MergeSearchBranches(state *AppState, results []BranchResult) error {
+	if err := MergeBranches(state, results); err != nil {
+		return err
+	}
+	Update(state, SearchedQueriesKey, len(state.GetSearchQueries()))
+	return nil
+}
+
+// searchSource names the raw content of a search branch after the position of
+// its query among all queries of the run, so later fan-outs do not overwrite it
+func searchSource(state *AppState, branch Branch) string {
+	return fmt.Sprintf("Search_%s_%d", branch.Name, Get(state, SearchedQueriesKey)+branch.Index+1)
+}
+
+// MergeBranches replays the writes of every successful branch on the state,
+// in branch order, combining them with the reducers of their keys
+func MergeBranches(state *AppState, results []BranchResult) error {
+	var succeeded []*AppState
+	for _, result := range results {
+		if result.Err != nil {
+			slog.Warn("Branch failed", "branch_id", result.ID, "error", result.Err)
+			continue
+		}
+		succeeded = append(succeeded, result.State)
+	}
+	state.replayWrites(succeeded...)
+
+	successCount := len(succeeded)
+	slog.Info("Dynamic branching completed", "successful", successCount, "branches", len(results))
+	if successCount == 0 {
+		return fmt.Errorf("all %d branches failed", len(results))
+	}
+	return nil
+}
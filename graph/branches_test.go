@@ -0,0 +1,225 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	sectionsKey = NewKey("sections", Replace[[]string]())
+	draftsKey   = NewKey("drafts", Append[string]())
+	writersKey  = NewKey("writers", MergeMap[string, string]())
+)
+
+// sectionGraph writes every section of a plan in its own branch and assembles the drafts
+func sectionGraph(write Node, fanOut FanOut) *Builder {
+	plan := func(ctx context.Context, state *AppState) error {
+		Update(state, sectionsKey, strings.Split(state.UserInput, ","))
+		return nil
+	}
+	assemble := func(ctx context.Context, state *AppState) error {
+		state.SetReport(strings.Join(Get(state, draftsKey), "\n"))
+		return nil
+	}
+
+	return NewBuilder().
+		AddNode("plan", plan).
+		AddConditionalEdge("plan", func(ctx context.Context, state *AppState) (string, error) { return BranchPrefix + "write", nil }, BranchPrefix+"write").
+		AddBranch("write", write, fanOut, "assemble").
+		AddNode("assemble", assemble).
+		SetEntry("plan")
+}
+
+var sectionFanOut = FanOut{
+	Split: func(state *AppState) ([]interface{}, error) {
+		var payloads []interface{}
+		for _, section := range Get(state, sectionsKey) {
+			payloads = append(payloads, section)
+		}
+		return payloads, nil
+	},
+	Reduce: MergeBranches,
+}
+
+// writeSection drafts the section of its branch; later sections finish first
+func writeSection(ctx context.Context, state *AppState) error {
+	branch, ok := BranchFromContext(ctx)
+	if !ok {
+		return errors.New("section written outside a branch")
+	}
+	time.Sleep(time.Duration(3-branch.Index) * 10 * time.Millisecond)
+
+	section := branch.Payload.(string)
+	if section == "fail" {
+		return errors.New("section failed")
+	}
+	Update(state, draftsKey, []string{"## " + section})
+	Update(state, writersKey, map[string]string{section: branch.ID})
+	return nil
+}
+
+func TestFanOut(t *testing.T) {
+	engine, err := sectionGraph(writeSection, sectionFanOut).Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := engine.Execute(context.Background(), "intro,body,outro")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	// Branches merge in payload order, whatever order they finish in
+	if want := "## intro\n## body\n## outro"; result.FinalState.Report != want {
+		t.Errorf("report = %q, want %q", result.FinalState.Report, want)
+	}
+	wantWriters := map[string]string{"intro": "write_1", "body": "write_2", "outro": "write_3"}
+	if got := Get(result.FinalState, writersKey); !reflect.DeepEqual(got, wantWriters) {
+		t.Errorf("writers = %v, want %v", got, wantWriters)
+	}
+
+	branches := append([]string(nil), result.Path[1:4]...)
+	sort.Strings(branches)
+	if result.Path[0] != "plan" || result.Path[4] != "assemble" || !reflect.DeepEqual(branches, []string{"write_1", "write_2", "write_3"}) {
+		t.Errorf("path = %v, want plan, the three branches and assemble", result.Path)
+	}
+}
+
+func TestFanOutFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		fanOut  FanOut
+		report  string
+		wantErr string
+	}{
+		{
+			name:   "failed branches are left out",
+			input:  "intro,fail,outro",
+			fanOut: sectionFanOut,
+			report: "## intro\n## outro",
+		},
+		{
+			name:    "every branch failed",
+			input:   "fail,fail",
+			fanOut:  sectionFanOut,
+			wantErr: "all 2 branches failed",
+		},
+		{
+			name:  "split fails",
+			input: "intro",
+			fanOut: FanOut{
+				Split:  func(state *AppState) ([]interface{}, error) { return nil, errors.New("no sections") },
+				Reduce: MergeBranches,
+			},
+			wantErr: "branch write failed to split: no sections",
+		},
+		{
+			name:    "reduce fails",
+			input:   "intro",
+			fanOut:  FanOut{Split: sectionFanOut.Split, Reduce: func(state *AppState, results []BranchResult) error { return errors.New("conflict") }},
+			wantErr: "branch write failed to reduce: conflict",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := sectionGraph(writeSection, tt.fanOut).Compile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := engine.Execute(context.Background(), tt.input)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Execute() error = %v, want %q", err, tt.wantErr)
+				}
+				if contains(result.Path, "assemble") {
+					t.Errorf("path = %v, want the run to stop before the join", result.Path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if result.FinalState.Report != tt.report {
+				t.Errorf("report = %q, want %q", result.FinalState.Report, tt.report)
+			}
+		})
+	}
+}
+
+func TestFanOutMaxConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	running, peak := 0, 0
+	write := func(ctx context.Context, state *AppState) error {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return writeSection(ctx, state)
+	}
+
+	nodes, edges, branches := testRegistries("to_branch:write")
+	nodes.RegisterNode("write", write)
+	branches.RegisterFanOut("sections", FanOut{
+		Split: func(state *AppState) ([]interface{}, error) {
+			return []interface{}{"1", "2", "3", "4", "5", "6"}, nil
+		},
+		Reduce: MergeBranches,
+	})
+	def := parseTestDefinition(t, `
+entry: a
+nodes: [{name: a, edge: to_branch:write}, {name: b}]
+edges: [{name: to_branch:write, routes: ["branch:write"]}]
+branches: [{name: write, fanout: sections, node: write, join: b, max_concurrency: 2}]`)
+	engine, err := newEngine(nodes, edges, branches, def)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := engine.Execute(context.Background(), "input")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if peak != 2 {
+		t.Errorf("%d branches ran at once, want 2", peak)
+	}
+	if got := len(Get(result.FinalState, draftsKey)); got != 6 {
+		t.Errorf("%d drafts, want 6", got)
+	}
+}
+
+func TestSplitSearchQueries(t *testing.T) {
+	state := NewAppState("input")
+	if _, err := SplitSearchQueries(state); err == nil {
+		t.Error("SplitSearchQueries() without queries succeeded")
+	}
+
+	for i := 1; i <= 3; i++ {
+		state.AddSearchQuery(fmt.Sprintf("q%d", i))
+	}
+	payloads, err := SplitSearchQueries(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{"q1", "q2", "q3"}; !reflect.DeepEqual(payloads, want) {
+		t.Errorf("payloads = %v, want %v", payloads, want)
+	}
+	if got := searchSource(state, Branch{Name: "search_query", Index: 2}); got != "Search_search_query_3" {
+		t.Errorf("source = %s, want Search_search_query_3", got)
+	}
+}
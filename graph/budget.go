@@ -0,0 +1,264 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// ErrBudgetExceeded is returned when a run used up its token or cost budget
+var ErrBudgetExceeded = errors.New("run budget exceeded")
+
+// What a run does once its budget is used up
+const (
+	BudgetAbort   = "abort"   // fail the run before the next node or model call
+	BudgetDegrade = "degrade" // keep going, but nodes skip optional work (see OverBudget)
+)
+
+// Budget limits what a single run may spend; zero limits are unlimited.
+// The limits are checked before each node and model call, so calls already in
+// flight, such as those of parallel branches, still finish and count, and a
+// degrading run still writes its report: runs may end somewhat above the limits.
+type Budget struct {
+	MaxTokens  int     // prompt and completion tokens
+	MaxCost    float64 // USD according to the price table
+	OnExceeded string  // BudgetAbort (default) or BudgetDegrade
+}
+
+// ModelPrice is the price of a model in USD per million tokens
+type ModelPrice struct {
+	Prompt     float64 `mapstructure:"prompt" json:"prompt"`
+	Completion float64 `mapstructure:"completion" json:"completion"`
+}
+
+// DefaultPrices are the list prices of the hosted models the assistant uses by default;
+// local models are free
+var DefaultPrices = map[string]ModelPrice{
+	"gpt-4o":                     {Prompt: 2.50, Completion: 10.00},
+	"gpt-4o-2024-08-06":          {Prompt: 2.50, Completion: 10.00},
+	"gpt-4o-mini":                {Prompt: 0.15, Completion: 0.60},
+	"claude-3-5-sonnet-20241022": {Prompt: 3.00, Completion: 15.00},
+	"claude-3-5-haiku-20241022":  {Prompt: 0.80, Completion: 4.00},
+	ProviderFake:                 {},
+}
+
+// Usage counts the tokens a run consumed and what they cost
+type Usage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost"` // USD
+	// Nodes breaks the totals down by nested node path
+	Nodes map[string]Usage `json:"nodes,omitempty"`
+	// BudgetExceeded is set once the run used up its budget
+	BudgetExceeded bool `json:"budget_exceeded,omitempty"`
+}
+
+// TotalTokens returns the prompt and completion tokens together
+func (u Usage) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// WithBudget limits the tokens and cost of every run
+func WithBudget(budget Budget) EngineOption {
+	return func(e *Engine) {
+		e.budget = budget
+	}
+}
+
+// WithPrices sets the model prices used to compute the cost of runs, replacing DefaultPrices
+func WithPrices(prices map[string]ModelPrice) EngineOption {
+	return func(e *Engine) {
+		e.prices = prices
+	}
+}
+
+// usageMeter accumulates the usage of a run and its subgraphs
+type usageMeter struct {
+	mu     sync.Mutex
+	usage  Usage
+	budget Budget
+	prices map[string]ModelPrice
+}
+
+type usageContextKey struct{}
+
+// withMeter attaches the run's meter to ctx; subgraphs keep metering into their parent's run
+func (r *runner) withMeter(ctx context.Context) context.Context {
+	if meter, ok := ctx.Value(usageContextKey{}).(*usageMeter); ok {
+		r.meter = meter
+		return ctx
+	}
+	return context.WithValue(ctx, usageContextKey{}, r.meter)
+}
+
+func newUsageMeter(e *Engine, usage *Usage) *usageMeter {
+	meter := &usageMeter{budget: e.budget, prices: e.prices}
+	if meter.prices == nil {
+		meter.prices = DefaultPrices
+	}
+	if usage != nil {
+		meter.usage = copyUsage(*usage)
+		// A resumed run may continue if its budget was raised in the meantime
+		meter.usage.BudgetExceeded = meter.overLocked()
+	}
+	return meter
+}
+
+// add records the tokens of a model call made by the node in ctx
+func (m *usageMeter) add(ctx context.Context, model string, promptTokens, completionTokens int) {
+	node := tracedNode(ctx)
+	price, known := m.prices[model]
+	if !known {
+		slog.WarnContext(ctx, "⚠️  No price for model, its tokens are not counted in the cost", "model", model)
+	}
+	cost := (float64(promptTokens)*price.Prompt + float64(completionTokens)*price.Completion) / 1e6
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.usage.PromptTokens += promptTokens
+	m.usage.CompletionTokens += completionTokens
+	m.usage.Cost += cost
+	if m.usage.Nodes == nil {
+		m.usage.Nodes = make(map[string]Usage)
+	}
+	nodeUsage := m.usage.Nodes[node]
+	nodeUsage.PromptTokens += promptTokens
+	nodeUsage.CompletionTokens += completionTokens
+	nodeUsage.Cost += cost
+	m.usage.Nodes[node] = nodeUsage
+
+	if !m.usage.BudgetExceeded && m.overLocked() {
+		m.usage.BudgetExceeded = true
+		slog.WarnContext(ctx, "💸 Run budget exhausted", "tokens", m.usage.TotalTokens(), "cost", m.usage.Cost)
+	}
+}
+
+// overLocked reports whether the usage reached the budget; the caller holds m.mu
+func (m *usageMeter) overLocked() bool {
+	return (m.budget.MaxTokens > 0 && m.usage.TotalTokens() >= m.budget.MaxTokens) ||
+		(m.budget.MaxCost > 0 && m.usage.Cost >= m.budget.MaxCost)
+}
+
+// check returns ErrBudgetExceeded once an aborting budget is used up
+func (m *usageMeter) check() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.usage.BudgetExceeded || m.budget.OnExceeded == BudgetDegrade {
+		return nil
+	}
+	return fmt.Errorf("%w: used %d tokens ($%.4f) of %s", ErrBudgetExceeded, m.usage.TotalTokens(), m.usage.Cost, m.budget)
+}
+
+func (m *usageMeter) snapshot() Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return copyUsage(m.usage)
+}
+
+func copyUsage(usage Usage) Usage {
+	nodes := usage.Nodes
+	usage.Nodes = nil
+	for node, nodeUsage := range nodes {
+		if usage.Nodes == nil {
+			usage.Nodes = make(map[string]Usage, len(nodes))
+		}
+		usage.Nodes[node] = nodeUsage
+	}
+	return usage
+}
+
+// validate rejects negative limits and unknown OnExceeded modes
+func (b Budget) validate() error {
+	if b.MaxTokens < 0 || b.MaxCost < 0 {
+		return fmt.Errorf("budget limits must not be negative")
+	}
+	switch b.OnExceeded {
+	case "", BudgetAbort, BudgetDegrade:
+		return nil
+	default:
+		return fmt.Errorf("unknown budget mode %q, want %q or %q", b.OnExceeded, BudgetAbort, BudgetDegrade)
+	}
+}
+
+func (b Budget) String() string {
+	switch {
+	case b.MaxTokens > 0 && b.MaxCost > 0:
+		return fmt.Sprintf("%d tokens / $%.4f", b.MaxTokens, b.MaxCost)
+	case b.MaxTokens > 0:
+		return fmt.Sprintf("%d tokens", b.MaxTokens)
+	default:
+		return fmt.Sprintf("$%.4f", b.MaxCost)
+	}
+}
+
+// OverBudget reports whether the run in ctx used up its budget.
+// Nodes check it to skip optional work, such as further searches, and still finish the run.
+func OverBudget(ctx context.Context) bool {
+	meter, ok := ctx.Value(usageContextKey{}).(*usageMeter)
+	if !ok {
+		return false
+	}
+
+	meter.mu.Lock()
+	defer meter.mu.Unlock()
+	return meter.usage.BudgetExceeded
+}
+
+// MeteredModel wraps a model so the tokens of its calls count against the run's budget.
+// name selects the model's price.
+func MeteredModel(model llms.Model, name string) llms.Model {
+	return meteredModel{Model: model, name: name}
+}
+
+type meteredModel struct {
+	llms.Model
+	name string
+}
+
+func (m meteredModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	meter, ok := ctx.Value(usageContextKey{}).(*usageMeter)
+	if !ok {
+		return m.Model.GenerateContent(ctx, messages, options...)
+	}
+
+	// Aborting budgets also stop the calls of parallel branches
+	if err := meter.check(); err != nil {
+		return nil, err
+	}
+
+	resp, err := m.Model.GenerateContent(ctx, messages, options...)
+	if err != nil {
+		return resp, err
+	}
+	if promptTokens, completionTokens := responseTokens(resp); promptTokens+completionTokens > 0 {
+		meter.add(ctx, m.name, promptTokens, completionTokens)
+	}
+	return resp, nil
+}
+
+// responseTokens returns the token counts the provider reported for a response
+func responseTokens(resp *llms.ContentResponse) (promptTokens, completionTokens int) {
+	for _, choice := range resp.Choices {
+		promptTokens, _ = choice.GenerationInfo["PromptTokens"].(int)
+		completionTokens, _ = choice.GenerationInfo["CompletionTokens"].(int)
+		if promptTokens+completionTokens == 0 {
+			// Anthropic names its counts after input and output
+			promptTokens, _ = choice.GenerationInfo["InputTokens"].(int)
+			completionTokens, _ = choice.GenerationInfo["OutputTokens"].(int)
+		}
+		if promptTokens+completionTokens > 0 {
+			return promptTokens, completionTokens
+		}
+	}
+	return 0, 0
+}
+
+func (m meteredModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
@@ -0,0 +1,68 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBudgetWithFakeLLM(t *testing.T) {
+	t.Run("abort", func(t *testing.T) {
+		engine, err := NewEngine("", "", WithFakeLLM(), WithBudget(Budget{MaxTokens: 300}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The searches use up the budget, so the run stops before the report
+		result, err := engine.Execute(context.Background(), "LLMの最新動向を調べて")
+		if !errors.Is(err, ErrBudgetExceeded) {
+			t.Fatalf("Execute() error = %v, want ErrBudgetExceeded", err)
+		}
+		if !result.Usage.BudgetExceeded || result.Usage.TotalTokens() < 300 {
+			t.Errorf("usage = %+v, want the budget of 300 tokens exceeded", result.Usage)
+		}
+		if contains(result.Path, "draft_outline") || result.FinalState.Report != "" {
+			t.Errorf("path = %v, want the run to stop before the outline", result.Path)
+		}
+	})
+
+	t.Run("degrade", func(t *testing.T) {
+		fake := NewFakeModel()
+		engine, err := NewEngine("", "", WithLLM(fake, ProviderFake),
+			WithBudget(Budget{MaxTokens: 150, OnExceeded: BudgetDegrade}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The queries use up the budget, so the searches and the outline are skipped
+		result, err := engine.Execute(context.Background(), "LLMの最新動向を調べて")
+		if err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		if got := nodesRun(result.Path); len(got) != len(researchPath) {
+			t.Errorf("path = %v, want %v", got, researchPath)
+		}
+		if !result.Usage.BudgetExceeded {
+			t.Error("usage does not record the exceeded budget")
+		}
+		if got := len(result.FinalState.GetRawContents()); got != 0 {
+			t.Errorf("sources = %d, want the searches skipped", got)
+		}
+		if got := result.FinalState.GetOutline(); len(got) != 0 {
+			t.Errorf("outline = %q, want it skipped", got)
+		}
+		if !strings.HasPrefix(result.FinalState.Report, "# LLMの最新動向を調べてに関する調査レポート") {
+			t.Errorf("report = %q, want it written anyway", result.FinalState.Report)
+		}
+
+		// Only the queries and the report were generated after classifying
+		var nodes []string
+		for node := range result.Usage.Nodes {
+			nodes = append(nodes, node)
+		}
+		if len(nodes) != 2 || result.Usage.Nodes["synthesize_and_report"].TotalTokens() == 0 {
+			t.Errorf("usage by node = %v, want only the queries and the report", result.Usage.Nodes)
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Builder constructs an Engine for a custom graph with its own nodes and edges
+type Builder struct {
+	nodes    *NodeRegistry
+	edges    *EdgeRegistry
+	branches *BranchRegistry
+	def      *GraphDefinition
+	index    map[string]int // node name -> position in def.Nodes
+	errs     []error
+}
+
+// NewBuilder creates an empty graph builder
+func NewBuilder() *Builder {
+	return &Builder{
+		nodes:    &NodeRegistry{nodes: make(map[string]Node)},
+		edges:    &EdgeRegistry{edges: make(map[string]Edge)},
+		branches: &BranchRegistry{fanOuts: make(map[string]FanOut)},
+		def:      &GraphDefinition{Name: "custom"},
+		index:    make(map[string]int),
+	}
+}
+
+// SetName sets the graph name reported in errors and definitions
+func (b *Builder) SetName(name string) *Builder {
+	b.def.Name = name
+	return b
+}
+
+// AddNode adds a node to the graph
+func (b *Builder) AddNode(name string, node Node) *Builder {
+	if name == "" || node == nil {
+		b.errs = append(b.errs, fmt.Errorf("node %q must have a name and an implementation", name))
+		return b
+	}
+	if _, exists := b.nodes.GetNode(name); exists {
+		b.errs = append(b.errs, fmt.Errorf("node %s added twice", name))
+		return b
+	}
+
+	b.nodes.RegisterNode(name, node)
+	b.index[name] = len(b.def.Nodes)
+	b.def.Nodes = append(b.def.Nodes, NodeDefinition{Name: name})
+	return b
+}
+
+// AddSubgraph adds a compiled graph as a single node. mapping moves state
+// into the subgraph before it runs and back into the parent afterwards.
+func (b *Builder) AddSubgraph(name string, subgraph *Engine, mapping StateMapping) *Builder {
+	if subgraph == nil {
+		b.errs = append(b.errs, fmt.Errorf("subgraph %q has no engine", name))
+		return b
+	}
+
+	errs := len(b.errs)
+	b.AddNode(name, subgraph.AsNode(name, mapping))
+	if len(b.errs) == errs {
+		// Keep the engine so the topology export can show the subgraph's nodes
+		b.nodes.RegisterSubgraph(name, subgraph, mapping)
+	}
+	return b
+}
+
+// AddEdge adds a fixed transition from one node to another
+func (b *Builder) AddEdge(from, to string) *Builder {
+	node := b.lookup(from)
+	if node == nil {
+		return b
+	}
+	if node.Edge != "" || node.Next != "" {
+		b.errs = append(b.errs, fmt.Errorf("node %s already has an outgoing edge", from))
+		return b
+	}
+
+	node.Next = to
+	return b
+}
+
+// AddConditionalEdge adds an edge function deciding the successor of a node.
+// routes lists every node (or "branch:<name>" signal) the edge may return.
+func (b *Builder) AddConditionalEdge(from string, edge Edge, routes ...string) *Builder {
+	node := b.lookup(from)
+	if node == nil {
+		return b
+	}
+	if edge == nil {
+		b.errs = append(b.errs, fmt.Errorf("conditional edge from %s has no implementation", from))
+		return b
+	}
+	if node.Edge != "" || node.Next != "" {
+		b.errs = append(b.errs, fmt.Errorf("node %s already has an outgoing edge", from))
+		return b
+	}
+
+	edgeName := "after_" + from
+	b.edges.RegisterEdge(edgeName, edge)
+	node.Edge = edgeName
+	b.def.Edges = append(b.def.Edges, EdgeDefinition{Name: edgeName, Routes: routes})
+	return b
+}
+
+// AddBranch adds a dynamic branch point that edges can route to with "branch:<name>".
+// node runs once per payload returned by fanOut.Split, then fanOut.Reduce merges
+// the branch states and the graph continues at join.
+func (b *Builder) AddBranch(name string, node Node, fanOut FanOut, join string) *Builder {
+	if name == "" || node == nil || fanOut.Split == nil || fanOut.Reduce == nil {
+		b.errs = append(b.errs, fmt.Errorf("branch %q must have a name, a node, a split and a reduce function", name))
+		return b
+	}
+	if _, exists := b.branches.GetFanOut(name); exists {
+		b.errs = append(b.errs, fmt.Errorf("branch %s added twice", name))
+		return b
+	}
+
+	if _, exists := b.nodes.GetNode(name); exists {
+		b.errs = append(b.errs, fmt.Errorf("branch %s clashes with a node of the same name", name))
+		return b
+	}
+
+	b.nodes.RegisterNode(name, node)
+	b.branches.RegisterFanOut(name, fanOut)
+	b.def.Branches = append(b.def.Branches, BranchDefinition{
+		Name:   name,
+		FanOut: name,
+		Node:   name,
+		Join:   join,
+	})
+	return b
+}
+
+// SetMaxVisits limits how often a node may run, bounding the loops it is part of
+func (b *Builder) SetMaxVisits(name string, visits int) *Builder {
+	node := b.lookup(name)
+	if node == nil {
+		return b
+	}
+
+	node.MaxVisits = visits
+	return b
+}
+
+// SetEntry sets the first node executed
+func (b *Builder) SetEntry(name string) *Builder {
+	b.def.Entry = name
+	return b
+}
+
+// Compile validates the graph and returns an engine that runs it.
+// The engine runs a copy of the graph, so later changes to the builder and
+// later compilations with other options leave it unchanged.
+func (b *Builder) Compile(opts ...EngineOption) (*Engine, error) {
+	if len(b.errs) > 0 {
+		return nil, fmt.Errorf("invalid graph %q: %w", b.def.Name, errors.Join(b.errs...))
+	}
+
+	nodes := &NodeRegistry{nodes: make(map[string]Node, len(b.nodes.nodes))}
+	for name, node := range b.nodes.nodes {
+		nodes.nodes[name] = node
+	}
+	for name, subgraph := range b.nodes.subgraphs {
+		if nodes.subgraphs == nil {
+			nodes.subgraphs = make(map[string]*Engine)
+		}
+		nodes.subgraphs[name] = subgraph
+	}
+	edges := &EdgeRegistry{edges: make(map[string]Edge, len(b.edges.edges))}
+	for name, edge := range b.edges.edges {
+		edges.edges[name] = edge
+	}
+	branches := &BranchRegistry{fanOuts: make(map[string]FanOut, len(b.branches.fanOuts))}
+	for name, fanOut := range b.branches.fanOuts {
+		branches.fanOuts[name] = fanOut
+	}
+
+	return newEngine(nodes, edges, branches, b.def.clone(), opts...)
+}
+
+// lookup returns the definition of an added node, recording an error if it is missing
+func (b *Builder) lookup(name string) *NodeDefinition {
+	i, exists := b.index[name]
+	if !exists {
+		b.errs = append(b.errs, fmt.Errorf("node %s must be added before its edges", name))
+		return nil
+	}
+	return &b.def.Nodes[i]
+}
@@ -0,0 +1,220 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// reviewGraph drafts a report with llm and publishes it once the model approves it
+func reviewGraph(llm llms.Model) *Builder {
+	draft := func(ctx context.Context, state *AppState) error {
+		report, err := llms.GenerateFromSinglePrompt(ctx, llm, "下書き: "+state.UserInput)
+		if err != nil {
+			return err
+		}
+		state.SetReport(report)
+		return nil
+	}
+	publish := func(ctx context.Context, state *AppState) error {
+		state.SetReport(state.Report + "（公開済み）")
+		return nil
+	}
+	reject := func(ctx context.Context, state *AppState) error {
+		state.SetReport("")
+		return nil
+	}
+	review := func(ctx context.Context, state *AppState) (string, error) {
+		if strings.Contains(state.Report, "承認") {
+			return "publish", nil
+		}
+		return "reject", nil
+	}
+
+	return NewBuilder().
+		SetName("review").
+		AddNode("draft", draft).
+		AddNode("publish", publish).
+		AddNode("reject", reject).
+		AddConditionalEdge("draft", review, "publish", "reject").
+		SetEntry("draft")
+}
+
+func TestBuilderCompile(t *testing.T) {
+	llm := NewFakeModel(
+		FakeResponse(`下書き: (良い案)`, "${1}は承認されました"),
+		FakeResponse(`下書き: `, "差し戻し"),
+	)
+	engine, err := reviewGraph(llm).Compile()
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+
+	tests := []struct {
+		input  string
+		path   []string
+		report string
+	}{
+		{"良い案", []string{"draft", "publish"}, "良い案は承認されました（公開済み）"},
+		{"悪い案", []string{"draft", "reject"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := engine.Execute(context.Background(), tt.input)
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if !reflect.DeepEqual(result.Path, tt.path) {
+				t.Errorf("path = %v, want %v", result.Path, tt.path)
+			}
+			if result.FinalState.Report != tt.report {
+				t.Errorf("report = %q, want %q", result.FinalState.Report, tt.report)
+			}
+		})
+	}
+}
+
+func TestBuilderCompiledEnginesAreIndependent(t *testing.T) {
+	builder := reviewGraph(NewFakeModel(FakeResponse(`下書き: `, "承認")))
+	first, err := builder.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither a second compilation with other options nor later additions reach the first engine
+	if _, err := builder.Compile(WithMaxSteps(1)); err != nil {
+		t.Fatal(err)
+	}
+	builder.AddNode("archive", func(ctx context.Context, state *AppState) error {
+		state.SetReport("archived")
+		return nil
+	}).AddEdge("publish", "archive").SetEntry("archive")
+	second, err := builder.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := first.Execute(context.Background(), "案")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if want := []string{"draft", "publish"}; !reflect.DeepEqual(result.Path, want) {
+		t.Errorf("path of the first engine = %v, want %v", result.Path, want)
+	}
+	if len(first.definition.Nodes) != 3 {
+		t.Errorf("first engine has %d nodes, want 3", len(first.definition.Nodes))
+	}
+
+	result, err = second.Execute(context.Background(), "案")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if want := []string{"archive"}; !reflect.DeepEqual(result.Path, want) {
+		t.Errorf("path of the second engine = %v, want %v", result.Path, want)
+	}
+}
+
+func TestBuilderCompileErrors(t *testing.T) {
+	node := func(ctx context.Context, state *AppState) error { return nil }
+	edge := func(ctx context.Context, state *AppState) (string, error) { return "", nil }
+
+	tests := []struct {
+		name  string
+		build func(b *Builder) *Builder
+		want  string // part of the error
+		kind  string // kind of the validation issue, when the graph itself is invalid
+	}{
+		{
+			name:  "edge to an undeclared node",
+			build: func(b *Builder) *Builder { return b.AddNode("a", node).AddEdge("a", "missing").SetEntry("a") },
+			want:  "node a continues to undeclared node missing",
+			kind:  "unknown_target",
+		},
+		{
+			name: "conditional edge to an undeclared node",
+			build: func(b *Builder) *Builder {
+				return b.AddNode("a", node).AddConditionalEdge("a", edge, "missing", EndRoute).SetEntry("a")
+			},
+			want: "edge after_a routes to undeclared node missing",
+			kind: "unknown_target",
+		},
+		{
+			name:  "undeclared entry",
+			build: func(b *Builder) *Builder { return b.AddNode("a", node).SetEntry("b") },
+			want:  "entry node b is not declared",
+			kind:  "unknown_entry",
+		},
+		{
+			name:  "edge from a node not added yet",
+			build: func(b *Builder) *Builder { return b.AddEdge("a", "b").AddNode("a", node).SetEntry("a") },
+			want:  "node a must be added before its edges",
+		},
+		{
+			name:  "node added twice",
+			build: func(b *Builder) *Builder { return b.AddNode("a", node).AddNode("a", node).SetEntry("a") },
+			want:  "node a added twice",
+		},
+		{
+			name: "second outgoing edge",
+			build: func(b *Builder) *Builder {
+				return b.AddNode("a", node).AddNode("b", node).AddEdge("a", "b").AddEdge("a", "a").SetEntry("a")
+			},
+			want: "node a already has an outgoing edge",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.build(NewBuilder()).Compile()
+			if err == nil {
+				t.Fatal("Compile() succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Compile() error = %v, want %q", err, tt.want)
+			}
+
+			var invalid *ValidationError
+			if errors.As(err, &invalid) != (tt.kind != "") {
+				t.Fatalf("Compile() error = %v, want a validation error: %v", err, tt.kind != "")
+			}
+			if tt.kind != "" && invalid.Issues[0].Kind != tt.kind {
+				t.Errorf("issue kind = %s, want %s", invalid.Issues[0].Kind, tt.kind)
+			}
+		})
+	}
+}
+
+func TestEdgeReceivesRunContext(t *testing.T) {
+	type routeKey struct{}
+	node := func(ctx context.Context, state *AppState) error { return nil }
+	route := func(ctx context.Context, state *AppState) (string, error) {
+		next, ok := ctx.Value(routeKey{}).(string)
+		if !ok {
+			return "", errors.New("edge called without the run's context")
+		}
+		return next, nil
+	}
+	engine, err := NewBuilder().
+		AddNode("a", node).
+		AddNode("b", node).
+		AddNode("c", node).
+		AddConditionalEdge("a", route, "b", "c").
+		SetEntry("a").
+		Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), routeKey{}, "c")
+	result, err := engine.Execute(ctx, "input")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if want := []string{"a", "c"}; !reflect.DeepEqual(result.Path, want) {
+		t.Errorf("path = %v, want %v", result.Path, want)
+	}
+}
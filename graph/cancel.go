@@ -0,0 +1,42 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrCancelled is returned when a run is stopped by its caller or by Engine.Cancel.
+// Cancelled runs keep their last checkpoint and can be resumed.
+var ErrCancelled = errors.New("run cancelled")
+
+// Cancel stops an in-flight run. It reports whether the run was active.
+func (e *Engine) Cancel(runID string) bool {
+	e.mu.Lock()
+	r, exists := e.active[runID]
+	e.mu.Unlock()
+
+	if exists {
+		r.cancel()
+	}
+	return exists
+}
+
+// track registers a run and its cancel function until it returns
+func (e *Engine) track(r *runner, cancel context.CancelFunc) func() {
+	e.mu.Lock()
+	r.cancel = cancel
+	e.active[r.runID] = r
+	e.mu.Unlock()
+
+	return func() {
+		e.mu.Lock()
+		delete(e.active, r.runID)
+		e.mu.Unlock()
+	}
+}
+
+// cancelledError reports a run stopped at node; it matches ErrCancelled and context.Canceled
+func cancelledError(node string) error {
+	return fmt.Errorf("%w at node %s: %w", ErrCancelled, node, context.Canceled)
+}
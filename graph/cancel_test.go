@@ -0,0 +1,79 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// branchStarts reports the first attempt of every branch
+type branchStarts struct {
+	NopHooks
+	started chan NodeEvent
+}
+
+func (h branchStarts) BeforeNode(ctx context.Context, event NodeEvent) {
+	if event.Branch != nil {
+		h.started <- event
+	}
+}
+
+func TestCancelDuringBranch(t *testing.T) {
+	var finished atomic.Int32
+	write := func(ctx context.Context, state *AppState) error {
+		if err := sleep(ctx, 10*time.Second); err != nil {
+			return err
+		}
+		finished.Add(1)
+		return nil
+	}
+
+	store := NewMemoryCheckpointStore()
+	hooks := branchStarts{started: make(chan NodeEvent, 3)}
+	engine, err := sectionGraph(write, sectionFanOut).Compile(WithHooks(hooks), WithCheckpointStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type outcome struct {
+		result *ExecutionResult
+		err    error
+	}
+	done := make(chan outcome)
+	go func() {
+		result, err := engine.Execute(context.Background(), "intro,body,outro")
+		done <- outcome{result, err}
+	}()
+
+	started := <-hooks.started
+	if !engine.Cancel(started.RunID) {
+		t.Fatalf("Cancel(%s) found no active run", started.RunID)
+	}
+
+	var run outcome
+	select {
+	case run = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("run kept going after it was cancelled")
+	}
+	if !errors.Is(run.err, ErrCancelled) || !errors.Is(run.err, context.Canceled) {
+		t.Fatalf("Execute() error = %v, want a cancellation", run.err)
+	}
+	if finished.Load() != 0 || contains(run.result.Path, "assemble") {
+		t.Errorf("path = %v after %d finished branches, want the run to stop in its branches", run.result.Path, finished.Load())
+	}
+
+	// The run is no longer active; it resumes at the node whose branches it stopped in
+	if engine.Cancel(started.RunID) {
+		t.Error("Cancel() of a finished run reported it active")
+	}
+	checkpoint, err := store.Load(context.Background(), started.RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.Status != CheckpointCancelled || checkpoint.NextNode != "plan" {
+		t.Errorf("checkpoint is %s at %s, want cancelled at plan", checkpoint.Status, checkpoint.NextNode)
+	}
+}
@@ -0,0 +1,203 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+)
+
+// Reducer combines the current value of a state key with an update. It may modify
+// current in place: the state hands it a copy of the stored slice or map, made once
+// per write, or once per key while a merge replays the writes of its branches.
+// It must not keep a reference to update.
+type Reducer[T any] func(current, update T) T
+
+// Key is a typed state channel. Every write goes through its reducer, so parallel
+// branches can update the same key and be merged back deterministically.
+type Key[T any] struct {
+	name   string
+	reduce Reducer[T]
+	// field binds the built-in keys to their AppState fields
+	field func(s *AppState) *T
+}
+
+// NewKey declares a custom state key written with the given reducer.
+// Values must be JSON serializable to survive checkpoints.
+func NewKey[T any](name string, reducer Reducer[T]) Key[T] {
+	return Key[T]{name: name, reduce: reducer}
+}
+
+// Name returns the key's name in the serialized state
+func (k Key[T]) Name() string {
+	return k.name
+}
+
+// Replace overwrites the current value with the update
+func Replace[T any]() Reducer[T] {
+	return func(current, update T) T {
+		return update
+	}
+}
+
+// Append appends the update to the current slice
+func Append[T any]() Reducer[[]T] {
+	return func(current, update []T) []T {
+		return append(current, update...)
+	}
+}
+
+// MergeMap adds the entries of the update to the current map, replacing existing keys
+func MergeMap[K comparable, V any]() Reducer[map[K]V] {
+	return func(current, update map[K]V) map[K]V {
+		if current == nil {
+			current = make(map[K]V, len(update))
+		}
+		for k, v := range update {
+			current[k] = v
+		}
+		return current
+	}
+}
+
+// Built-in keys of the research state
+var (
+	IntentKey        = fieldKey("intent", Replace[string](), func(s *AppState) *string { return &s.Intent })
+	TopicKey         = fieldKey("topic", Replace[string](), func(s *AppState) *string { return &s.Topic })
+	ReportKey        = fieldKey("report", Replace[string](), func(s *AppState) *string { return &s.Report })
+	SearchQueriesKey = fieldKey("search_queries", Append[string](), func(s *AppState) *[]string { return &s.SearchQueries })
+	RawContentsKey   = fieldKey("raw_contents", MergeMap[string, string](), func(s *AppState) *map[string]string { return &s.RawContents })
+	OutlineKey       = fieldKey("outline", Replace[[]string](), func(s *AppState) *[]string { return &s.Outline })
+	HistoryKey       = fieldKey("history", Append[Message](), func(s *AppState) *[]Message { return &s.History })
+)
+
+func fieldKey[T any](name string, reducer Reducer[T], field func(s *AppState) *T) Key[T] {
+	return Key[T]{name: name, reduce: reducer, field: field}
+}
+
+// Get returns the value of key, or its zero value when it was never written.
+// Treat returned slices and maps as read-only.
+func Get[T any](s *AppState, key Key[T]) T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return key.load(s)
+}
+
+// Update applies the key's reducer to its current value and the update.
+// On a branch state the write is recorded so MergeBranches can replay it on the parent.
+func Update[T any](s *AppState, key Key[T], update T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key.apply(s, update)
+}
+
+// apply reduces update into the key and records the write; the caller holds s.mu
+func (k Key[T]) apply(s *AppState, update T) {
+	current := k.load(s)
+	// Copy so values handed out earlier never change
+	if !s.owns(k.name) {
+		current = copyValue(current)
+	}
+	k.store(s, k.reduce(current, update))
+	s.touch(k.member())
+	s.record(func(target *AppState) {
+		k.apply(target, update)
+	})
+}
+
+// copyValue returns a shallow copy of slices and maps, which reducers may then modify
+func copyValue[T any](value T) T {
+	v := reflect.ValueOf(&value).Elem()
+	switch {
+	case v.Kind() == reflect.Slice && !v.IsNil():
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(copied, v)
+		v.Set(copied)
+	case v.Kind() == reflect.Map && !v.IsNil():
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			copied.SetMapIndex(iter.Key(), iter.Value())
+		}
+		v.Set(copied)
+	}
+	return value
+}
+
+// member names the key's member of the serialized state
+func (k Key[T]) member() string {
+	if k.field != nil {
+		return k.name
+	}
+	return "channels/" + k.name
+}
+
+// load reads the key; the caller holds s.mu
+func (k Key[T]) load(s *AppState) T {
+	if k.field != nil {
+		return *k.field(s)
+	}
+
+	var value T
+	switch stored := s.channels[k.name].(type) {
+	case nil:
+	case T:
+		value = stored
+	case json.RawMessage:
+		// Values restored from a checkpoint are decoded on first use
+		if err := json.Unmarshal(stored, &value); err != nil {
+			slog.Warn("⚠️  State key holds a value of another type", "key", k.name, "value", string(stored), "want", fmt.Sprintf("%T", value), "error", err)
+		}
+	default:
+		slog.Warn("⚠️  State key holds a value of another type", "key", k.name, "type", fmt.Sprintf("%T", stored), "want", fmt.Sprintf("%T", value))
+	}
+	return value
+}
+
+// store writes the key; the caller holds s.mu
+func (k Key[T]) store(s *AppState, value T) {
+	if k.field != nil {
+		*k.field(s) = value
+		return
+	}
+	if s.channels == nil {
+		s.channels = make(map[string]interface{})
+	}
+	s.channels[k.name] = value
+}
+
+// record keeps a write made on a branch state; the caller holds s.mu
+func (s *AppState) record(write func(target *AppState)) {
+	if s.recording {
+		s.writes = append(s.writes, write)
+	}
+}
+
+// owns reports whether the value of key was already copied by the merge in
+// progress, so it may be modified in place; the caller holds s.mu
+func (s *AppState) owns(key string) bool {
+	if s.merging == nil {
+		return false
+	}
+	owned := s.merging[key]
+	s.merging[key] = true
+	return owned
+}
+
+// replayWrites applies the writes recorded on branch states to s in branch order.
+// The whole merge holds the lock, so each key is copied once rather than once per write.
+func (s *AppState) replayWrites(branches ...*AppState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.merging = make(map[string]bool)
+	defer func() { s.merging = nil }()
+
+	for _, branch := range branches {
+		branch.mu.RLock()
+		writes := append([]func(target *AppState){}, branch.writes...)
+		branch.mu.RUnlock()
+
+		for _, write := range writes {
+			write(s)
+		}
+	}
+}
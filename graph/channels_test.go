@@ -0,0 +1,254 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestReducers(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(s *AppState)
+		get    func(s *AppState) interface{}
+		want   interface{}
+	}{
+		{
+			name: "replace keeps the last write",
+			update: func(s *AppState) {
+				key := NewKey("status", Replace[string]())
+				Update(s, key, "searching")
+				Update(s, key, "done")
+			},
+			get:  func(s *AppState) interface{} { return Get(s, NewKey("status", Replace[string]())) },
+			want: "done",
+		},
+		{
+			name: "replace overwrites a slice",
+			update: func(s *AppState) {
+				key := NewKey("tags", Replace[[]string]())
+				Update(s, key, []string{"a", "b"})
+				Update(s, key, []string{"c"})
+			},
+			get:  func(s *AppState) interface{} { return Get(s, NewKey("tags", Replace[[]string]())) },
+			want: []string{"c"},
+		},
+		{
+			name: "append keeps every write in order",
+			update: func(s *AppState) {
+				key := NewKey("sources", Append[string]())
+				Update(s, key, []string{"a"})
+				Update(s, key, []string{"b", "c"})
+				Update(s, key, nil)
+				Update(s, key, []string{"a"})
+			},
+			get:  func(s *AppState) interface{} { return Get(s, NewKey("sources", Append[string]())) },
+			want: []string{"a", "b", "c", "a"},
+		},
+		{
+			name: "merge map replaces conflicting keys",
+			update: func(s *AppState) {
+				key := NewKey("scores", MergeMap[string, int]())
+				Update(s, key, map[string]int{"a": 1, "b": 2})
+				Update(s, key, map[string]int{"b": 3, "c": 4})
+			},
+			get:  func(s *AppState) interface{} { return Get(s, NewKey("scores", MergeMap[string, int]())) },
+			want: map[string]int{"a": 1, "b": 3, "c": 4},
+		},
+		{
+			name: "built-in keys use their reducers",
+			update: func(s *AppState) {
+				s.AddSearchQuery("q1")
+				s.AddSearchQuery("q2")
+				s.SetRawContent("Search_1", "old")
+				s.SetRawContent("Search_1", "new")
+				s.SetTopic("LLM")
+				s.SetTopic("Go")
+			},
+			get: func(s *AppState) interface{} {
+				return []interface{}{s.GetSearchQueries(), s.GetRawContents(), s.Topic}
+			},
+			want: []interface{}{[]string{"q1", "q2"}, map[string]string{"Search_1": "new"}, "Go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := NewAppState("input")
+			tt.update(state)
+			if got := tt.get(state); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateKeepsHandedOutValues(t *testing.T) {
+	sources := NewKey("sources", Append[string]())
+	scores := NewKey("scores", MergeMap[string, int]())
+
+	state := NewAppState("input")
+	Update(state, sources, []string{"a"})
+	Update(state, scores, map[string]int{"a": 1})
+	heldSources, heldScores := Get(state, sources), Get(state, scores)
+
+	// Writes on the state and on a branch merged into it must not reach values handed out earlier
+	Update(state, sources, []string{"b"})
+	Update(state, scores, map[string]int{"a": 2})
+	branch := state.fork()
+	Update(branch, sources, []string{"c"})
+	Update(branch, scores, map[string]int{"b": 3})
+	if err := MergeBranches(state, []BranchResult{{State: branch}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(heldSources, []string{"a"}) {
+		t.Errorf("handed out sources changed to %v", heldSources)
+	}
+	if !reflect.DeepEqual(heldScores, map[string]int{"a": 1}) {
+		t.Errorf("handed out scores changed to %v", heldScores)
+	}
+	if got := Get(branch, sources); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("branch sources = %v, want [a b c]", got)
+	}
+	if got := Get(state, sources); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("merged sources = %v, want [a b c]", got)
+	}
+	if got := Get(state, scores); !reflect.DeepEqual(got, map[string]int{"a": 2, "b": 3}) {
+		t.Errorf("merged scores = %v, want map[a:2 b:3]", got)
+	}
+}
+
+func TestMergeBranches(t *testing.T) {
+	sources := NewKey("sources", Append[string]())
+	scores := NewKey("scores", MergeMap[string, int]())
+	winner := NewKey("winner", Replace[string]())
+	failed := errors.New("search failed")
+
+	tests := []struct {
+		name    string
+		writes  [][]string // sources written by each branch
+		errs    []error    // branch errors, if any
+		order   []int      // order the branches write in
+		want    []string   // merged sources
+		winner  string
+		scores  map[string]int
+		wantErr bool
+	}{
+		{
+			name:   "replays in branch order",
+			writes: [][]string{{"a1", "a2"}, {"b1"}, {"c1", "c2"}},
+			order:  []int{0, 1, 2},
+			want:   []string{"seed", "a1", "a2", "b1", "c1", "c2"},
+			winner: "branch_3",
+			scores: map[string]int{"shared": 2, "branch_1": 1, "branch_2": 1, "branch_3": 1},
+		},
+		{
+			name:   "ignores the order branches finish in",
+			writes: [][]string{{"a1"}, {"b1", "b2"}, {"c1"}},
+			order:  []int{2, 0, 1},
+			want:   []string{"seed", "a1", "b1", "b2", "c1"},
+			winner: "branch_3",
+			scores: map[string]int{"shared": 2, "branch_1": 1, "branch_2": 1, "branch_3": 1},
+		},
+		{
+			name:   "skips failed branches",
+			writes: [][]string{{"a1"}, {"b1"}, {"c1"}},
+			errs:   []error{nil, nil, failed},
+			order:  []int{0, 1, 2},
+			want:   []string{"seed", "a1", "b1"},
+			winner: "branch_2",
+			scores: map[string]int{"shared": 1, "branch_1": 1, "branch_2": 1},
+		},
+		{
+			name:    "fails when every branch failed",
+			writes:  [][]string{{"a1"}, {"b1"}},
+			errs:    []error{failed, failed},
+			order:   []int{1, 0},
+			want:    []string{"seed"},
+			winner:  "",
+			scores:  map[string]int{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := NewAppState("input")
+			Update(state, sources, []string{"seed"})
+			Update(state, scores, map[string]int{})
+
+			results := make([]BranchResult, len(tt.writes))
+			for i := range tt.writes {
+				results[i] = BranchResult{Branch: Branch{ID: fmt.Sprintf("branch_%d", i+1), Index: i}, State: state.fork()}
+				if i < len(tt.errs) {
+					results[i].Err = tt.errs[i]
+				}
+			}
+
+			// Branches run concurrently but write in the given order
+			var wg sync.WaitGroup
+			turn := make([]chan struct{}, len(tt.order)+1)
+			for i := range turn {
+				turn[i] = make(chan struct{})
+			}
+			for position, i := range tt.order {
+				wg.Add(1)
+				go func(position int, result BranchResult, writes []string) {
+					defer wg.Done()
+					<-turn[position]
+					for _, source := range writes {
+						Update(result.State, sources, []string{source})
+					}
+					Update(result.State, scores, map[string]int{"shared": result.Index, result.ID: 1})
+					Update(result.State, winner, result.ID)
+					close(turn[position+1])
+				}(position, results[i], tt.writes[i])
+			}
+			close(turn[0])
+			wg.Wait()
+
+			err := MergeBranches(state, results)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MergeBranches() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := Get(state, sources); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sources = %v, want %v", got, tt.want)
+			}
+			if got := Get(state, winner); got != tt.winner {
+				t.Errorf("winner = %q, want %q", got, tt.winner)
+			}
+			if got := Get(state, scores); !reflect.DeepEqual(got, tt.scores) {
+				t.Errorf("scores = %v, want %v", got, tt.scores)
+			}
+		})
+	}
+}
+
+func TestMergeBranchesAfterAssign(t *testing.T) {
+	// A subgraph run inside a branch replaces the branch state wholesale;
+	// the merge must replay that before the writes made after it
+	sources := NewKey("sources", Append[string]())
+	state := NewAppState("input")
+	Update(state, sources, []string{"seed"})
+
+	branch := state.fork()
+	Update(branch, sources, []string{"lost"})
+	child := branch.Clone()
+	Update(child, sources, []string{"child"})
+	branch.assign(child)
+	Update(branch, sources, []string{"after"})
+
+	if err := MergeBranches(state, []BranchResult{{State: branch}}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"seed", "lost", "child", "after"}
+	if got := Get(state, sources); !reflect.DeepEqual(got, want) {
+		t.Errorf("sources = %v, want %v", got, want)
+	}
+	if got := Get(child, sources); !reflect.DeepEqual(got, []string{"seed", "lost", "child"}) {
+		t.Errorf("assigned state changed to %v", got)
+	}
+}
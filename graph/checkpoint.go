@@ -0,0 +1,457 @@
+package graph
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checkpoint statuses
+const (
+	CheckpointRunning     = "running"
+	CheckpointCompleted   = "completed"
+	CheckpointFailed      = "failed"
+	CheckpointInterrupted = "interrupted"
+	CheckpointCancelled   = "cancelled"
+)
+
+// ErrCheckpointNotFound is returned when a store has no checkpoint for a run
+var ErrCheckpointNotFound = errors.New("checkpoint not found")
+
+// Checkpoint is the persisted progress of a run after its last successful node
+type Checkpoint struct {
+	RunID     string         `json:"run_id"`
+	Step      int            `json:"step"`
+	Node      string         `json:"node"`      // last node that completed ("" before the first one)
+	NextNode  string         `json:"next_node"` // node to resume from ("" once finished)
+	State     *AppState      `json:"state"`
+	Path      []string       `json:"path"`
+	Visits    map[string]int `json:"visits,omitempty"` // executions per node, for loop limits
+	Status    string         `json:"status"`
+	Error     string         `json:"error,omitempty"`
+	Interrupt *Interrupt     `json:"interrupt,omitempty"` // set while waiting for review
+	UpdatedAt time.Time      `json:"updated_at"`
+
+	// run and step a forked run branched off from
+	ParentRunID string `json:"parent_run_id,omitempty"`
+	ParentStep  int    `json:"parent_step,omitempty"`
+
+	// tokens and cost of the run so far, so resumed runs keep their budget
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+// CheckpointStore persists the latest checkpoint of each run
+type CheckpointStore interface {
+	Save(ctx context.Context, checkpoint *Checkpoint) error
+	Load(ctx context.Context, runID string) (*Checkpoint, error)
+}
+
+// CheckpointHistory is implemented by stores that also keep the latest checkpoint
+// of every step of a run, which past runs are forked from
+type CheckpointHistory interface {
+	// History returns the checkpoints of a run ordered by step
+	History(ctx context.Context, runID string) ([]*Checkpoint, error)
+	// LoadStep returns the checkpoint of a run after the given step
+	LoadStep(ctx context.Context, runID string, step int) (*Checkpoint, error)
+}
+
+// copyCheckpoint deep-copies a checkpoint through its JSON form
+func copyCheckpoint(checkpoint *Checkpoint) (*Checkpoint, error) {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	return decodeCheckpoint(data)
+}
+
+func decodeCheckpoint(data []byte) (*Checkpoint, error) {
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint: %w", err)
+	}
+	if checkpoint.State == nil {
+		return nil, fmt.Errorf("checkpoint for run %s has no state", checkpoint.RunID)
+	}
+	return &checkpoint, nil
+}
+
+// Limits of NewMemoryCheckpointStore on the finished runs it keeps
+const (
+	DefaultMemoryMaxRuns = 100
+	DefaultMemoryTTL     = time.Hour
+)
+
+// MemoryCheckpointStore keeps checkpoints in process memory. Finished runs
+// (completed, failed or cancelled) are evicted once they are older than TTL or,
+// oldest first, beyond MaxRuns; running and interrupted runs are kept.
+type MemoryCheckpointStore struct {
+	MaxRuns int           // finished runs kept (0 = unlimited)
+	TTL     time.Duration // lifetime of finished runs since their last checkpoint (0 = forever)
+
+	mu          sync.RWMutex
+	checkpoints map[string]*Checkpoint
+	steps       map[string]map[int]*Checkpoint
+}
+
+// NewMemoryCheckpointStore creates an in-memory checkpoint store keeping
+// DefaultMemoryMaxRuns finished runs for up to DefaultMemoryTTL
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{
+		MaxRuns:     DefaultMemoryMaxRuns,
+		TTL:         DefaultMemoryTTL,
+		checkpoints: make(map[string]*Checkpoint),
+		steps:       make(map[string]map[int]*Checkpoint),
+	}
+}
+
+// Save stores a copy of the checkpoint and evicts expired finished runs
+func (s *MemoryCheckpointStore) Save(ctx context.Context, checkpoint *Checkpoint) error {
+	stored, err := copyCheckpoint(checkpoint)
+	if err != nil {
+		return err
+	}
+	if stored.UpdatedAt.IsZero() {
+		stored.UpdatedAt = time.Now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoints[checkpoint.RunID] = stored
+	if s.steps[checkpoint.RunID] == nil {
+		s.steps[checkpoint.RunID] = make(map[int]*Checkpoint)
+	}
+	s.steps[checkpoint.RunID][checkpoint.Step] = stored
+	s.evict(time.Now())
+	return nil
+}
+
+// evict drops finished runs past TTL and the oldest beyond MaxRuns
+func (s *MemoryCheckpointStore) evict(now time.Time) {
+	var finished []*Checkpoint
+	for runID, checkpoint := range s.checkpoints {
+		switch checkpoint.Status {
+		case CheckpointCompleted, CheckpointFailed, CheckpointCancelled:
+		default:
+			continue
+		}
+		if s.TTL > 0 && now.Sub(checkpoint.UpdatedAt) > s.TTL {
+			s.delete(runID)
+			continue
+		}
+		finished = append(finished, checkpoint)
+	}
+
+	if s.MaxRuns <= 0 || len(finished) <= s.MaxRuns {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].UpdatedAt.Before(finished[j].UpdatedAt)
+	})
+	for _, checkpoint := range finished[:len(finished)-s.MaxRuns] {
+		s.delete(checkpoint.RunID)
+	}
+}
+
+func (s *MemoryCheckpointStore) delete(runID string) {
+	delete(s.checkpoints, runID)
+	delete(s.steps, runID)
+}
+
+// Load returns a copy of the latest checkpoint of a run
+func (s *MemoryCheckpointStore) Load(ctx context.Context, runID string) (*Checkpoint, error) {
+	s.mu.RLock()
+	stored, exists := s.checkpoints[runID]
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, ErrCheckpointNotFound
+	}
+	return copyCheckpoint(stored)
+}
+
+// History returns copies of the checkpoints of every step of a run
+func (s *MemoryCheckpointStore) History(ctx context.Context, runID string) ([]*Checkpoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	steps, exists := s.steps[runID]
+	if !exists {
+		return nil, ErrCheckpointNotFound
+	}
+	history := make([]*Checkpoint, 0, len(steps))
+	for _, stored := range steps {
+		checkpoint, err := copyCheckpoint(stored)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, checkpoint)
+	}
+	sortByStep(history)
+	return history, nil
+}
+
+// LoadStep returns a copy of the checkpoint of a run after step
+func (s *MemoryCheckpointStore) LoadStep(ctx context.Context, runID string, step int) (*Checkpoint, error) {
+	s.mu.RLock()
+	stored, exists := s.steps[runID][step]
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, ErrCheckpointNotFound
+	}
+	return copyCheckpoint(stored)
+}
+
+func sortByStep(history []*Checkpoint) {
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Step < history[j].Step
+	})
+}
+
+// validRunID restricts run IDs used as file names
+var validRunID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// FileCheckpointStore keeps one JSON file per run in a directory,
+// and the checkpoint of every step in <dir>/<run ID>/<step>.json
+type FileCheckpointStore struct {
+	dir string
+}
+
+// NewFileCheckpointStore creates a JSON file checkpoint store in dir
+func NewFileCheckpointStore(dir string) (*FileCheckpointStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+	return &FileCheckpointStore{dir: dir}, nil
+}
+
+func (s *FileCheckpointStore) path(runID string) (string, error) {
+	if !validRunID.MatchString(runID) {
+		return "", fmt.Errorf("invalid run ID %q", runID)
+	}
+	return filepath.Join(s.dir, runID+".json"), nil
+}
+
+// Save writes the checkpoint atomically to <dir>/<run ID>.json
+func (s *FileCheckpointStore) Save(ctx context.Context, checkpoint *Checkpoint) error {
+	path, err := s.path(checkpoint.RunID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+
+	stepDir := strings.TrimSuffix(path, ".json")
+	if err := os.MkdirAll(stepDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+	if err := writeAtomic(filepath.Join(stepDir, strconv.Itoa(checkpoint.Step)+".json"), data); err != nil {
+		return err
+	}
+	return writeAtomic(path, data)
+}
+
+// writeAtomic replaces the file at path with data
+func writeAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load reads the checkpoint of a run
+func (s *FileCheckpointStore) Load(ctx context.Context, runID string) (*Checkpoint, error) {
+	path, err := s.path(runID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCheckpointNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	return decodeCheckpoint(data)
+}
+
+// History reads the checkpoints of every step of a run
+func (s *FileCheckpointStore) History(ctx context.Context, runID string) ([]*Checkpoint, error) {
+	path, err := s.path(runID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(strings.TrimSuffix(path, ".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCheckpointNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint history: %w", err)
+	}
+
+	var history []*Checkpoint
+	for _, entry := range entries {
+		step, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		checkpoint, err := s.LoadStep(ctx, runID, step)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, checkpoint)
+	}
+	sortByStep(history)
+	return history, nil
+}
+
+// LoadStep reads the checkpoint of a run after step
+func (s *FileCheckpointStore) LoadStep(ctx context.Context, runID string, step int) (*Checkpoint, error) {
+	path, err := s.path(runID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(strings.TrimSuffix(path, ".json"), strconv.Itoa(step)+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCheckpointNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	return decodeCheckpoint(data)
+}
+
+// SQLiteCheckpointStore keeps checkpoints in a SQLite database.
+// The caller opens db with a registered SQLite driver (e.g. modernc.org/sqlite).
+type SQLiteCheckpointStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteCheckpointStore creates the checkpoint table if needed
+func NewSQLiteCheckpointStore(ctx context.Context, db *sql.DB) (*SQLiteCheckpointStore, error) {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS checkpoints (
+		run_id     TEXT PRIMARY KEY,
+		step       INTEGER NOT NULL,
+		status     TEXT NOT NULL,
+		data       TEXT NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint table: %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS checkpoint_steps (
+		run_id     TEXT NOT NULL,
+		step       INTEGER NOT NULL,
+		data       TEXT NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (run_id, step)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint step table: %w", err)
+	}
+	return &SQLiteCheckpointStore{db: db}, nil
+}
+
+// Save upserts the latest checkpoint of a run and of its step
+func (s *SQLiteCheckpointStore) Save(ctx context.Context, checkpoint *Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, `INSERT INTO checkpoint_steps (run_id, step, data, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(run_id, step) DO UPDATE SET
+			data = excluded.data,
+			updated_at = excluded.updated_at`,
+		checkpoint.RunID, checkpoint.Step, string(data), checkpoint.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint step: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, `INSERT INTO checkpoints (run_id, step, status, data, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(run_id) DO UPDATE SET
+			step = excluded.step,
+			status = excluded.status,
+			data = excluded.data,
+			updated_at = excluded.updated_at`,
+		checkpoint.RunID, checkpoint.Step, checkpoint.Status, string(data), checkpoint.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Load returns the latest checkpoint of a run
+func (s *SQLiteCheckpointStore) Load(ctx context.Context, runID string) (*Checkpoint, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, `SELECT data FROM checkpoints WHERE run_id = ?`, runID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCheckpointNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+	return decodeCheckpoint([]byte(data))
+}
+
+// History returns the checkpoints of every step of a run
+func (s *SQLiteCheckpointStore) History(ctx context.Context, runID string) ([]*Checkpoint, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT data FROM checkpoint_steps WHERE run_id = ? ORDER BY step`, runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checkpoint history: %w", err)
+	}
+	defer rows.Close()
+
+	var history []*Checkpoint
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to load checkpoint history: %w", err)
+		}
+		checkpoint, err := decodeCheckpoint([]byte(data))
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, checkpoint)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load checkpoint history: %w", err)
+	}
+	if len(history) == 0 {
+		return nil, ErrCheckpointNotFound
+	}
+	return history, nil
+}
+
+// LoadStep returns the checkpoint of a run after step
+func (s *SQLiteCheckpointStore) LoadStep(ctx context.Context, runID string, step int) (*Checkpoint, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, `SELECT data FROM checkpoint_steps WHERE run_id = ? AND step = ?`, runID, step).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCheckpointNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+	return decodeCheckpoint([]byte(data))
+}
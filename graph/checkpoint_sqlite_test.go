@@ -0,0 +1,29 @@
+//go:build !js
+
+package graph
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	newSQLiteStore = func(t *testing.T) checkpointStore {
+		t.Helper()
+		db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "runs.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		store, err := NewSQLiteCheckpointStore(context.Background(), db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+}
@@ -0,0 +1,309 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// checkpointStore is implemented by all built-in stores
+type checkpointStore interface {
+	CheckpointStore
+	CheckpointHistory
+}
+
+// newSQLiteStore creates an empty SQLite store where a SQLite driver is available
+var newSQLiteStore func(t *testing.T) checkpointStore
+
+// checkpointStores creates an empty store of every built-in kind
+func checkpointStores(t *testing.T) map[string]checkpointStore {
+	t.Helper()
+
+	file, err := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoints"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]checkpointStore{
+		"memory": NewMemoryCheckpointStore(),
+		"file":   file,
+	}
+	if newSQLiteStore != nil {
+		stores["sqlite"] = newSQLiteStore(t)
+	}
+	return stores
+}
+
+func testCheckpoint(runID string, step int, status string, updated time.Time) *Checkpoint {
+	state := NewAppState("LLMの最新動向")
+	state.SetTopic("LLM")
+	for i := 1; i <= step; i++ {
+		state.AddSearchQuery(fmt.Sprintf("q%d", i))
+	}
+
+	next := fmt.Sprintf("node_%d", step+1)
+	if status == CheckpointCompleted {
+		next = ""
+	}
+	return &Checkpoint{
+		RunID:     runID,
+		Step:      step,
+		Node:      fmt.Sprintf("node_%d", step),
+		NextNode:  next,
+		State:     state,
+		Path:      []string{"node_1"},
+		Visits:    map[string]int{"node_1": step},
+		Status:    status,
+		UpdatedAt: updated,
+		Usage:     &Usage{PromptTokens: step, CompletionTokens: step},
+	}
+}
+
+func TestCheckpointStores(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	for name, store := range checkpointStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for step := 0; step <= 2; step++ {
+				if err := store.Save(ctx, testCheckpoint("run-1", step, CheckpointRunning, now)); err != nil {
+					t.Fatalf("Save() error = %v", err)
+				}
+			}
+			// A step saved again keeps only its latest checkpoint
+			failed := testCheckpoint("run-1", 2, CheckpointFailed, now.Add(time.Second))
+			failed.Error = "node node_3 failed"
+			if err := store.Save(ctx, failed); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			if err := store.Save(ctx, testCheckpoint("run-2", 0, CheckpointRunning, now)); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+
+			loaded, err := store.Load(ctx, "run-1")
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			assertCheckpoint(t, loaded, failed)
+
+			history, err := store.History(ctx, "run-1")
+			if err != nil {
+				t.Fatalf("History() error = %v", err)
+			}
+			if len(history) != 3 {
+				t.Fatalf("History() returned %d checkpoints, want 3", len(history))
+			}
+			for step, checkpoint := range history[:2] {
+				assertCheckpoint(t, checkpoint, testCheckpoint("run-1", step, CheckpointRunning, now))
+			}
+			assertCheckpoint(t, history[2], failed)
+
+			step, err := store.LoadStep(ctx, "run-1", 1)
+			if err != nil {
+				t.Fatalf("LoadStep() error = %v", err)
+			}
+			assertCheckpoint(t, step, testCheckpoint("run-1", 1, CheckpointRunning, now))
+
+			// Changing what a store returned does not change what it stores
+			loaded.State.SetTopic("changed")
+			if again, err := store.Load(ctx, "run-1"); err != nil || again.State.Topic != "LLM" {
+				t.Errorf("Load() after changing the loaded state = %v, %v", again, err)
+			}
+		})
+	}
+}
+
+func TestCheckpointStoresMissingRun(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range checkpointStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := store.Save(ctx, testCheckpoint("run-1", 0, CheckpointRunning, time.Now())); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := store.Load(ctx, "missing"); !errors.Is(err, ErrCheckpointNotFound) {
+				t.Errorf("Load() error = %v, want ErrCheckpointNotFound", err)
+			}
+			if _, err := store.History(ctx, "missing"); !errors.Is(err, ErrCheckpointNotFound) {
+				t.Errorf("History() error = %v, want ErrCheckpointNotFound", err)
+			}
+			if _, err := store.LoadStep(ctx, "run-1", 5); !errors.Is(err, ErrCheckpointNotFound) {
+				t.Errorf("LoadStep() error = %v, want ErrCheckpointNotFound", err)
+			}
+		})
+	}
+}
+
+func TestFileCheckpointStoreRejectsPathsAsRunIDs(t *testing.T) {
+	store, err := NewFileCheckpointStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(context.Background(), testCheckpoint("../escape", 0, CheckpointRunning, time.Now())); err == nil {
+		t.Error("Save() with a path as run ID succeeded")
+	}
+}
+
+func assertCheckpoint(t *testing.T, got, want *Checkpoint) {
+	t.Helper()
+
+	gotState, err := stateDocument(got.State)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantState, err := stateDocument(want.State)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotState, wantState) {
+		t.Errorf("state = %v, want %v", gotState, wantState)
+	}
+
+	if !got.UpdatedAt.Equal(want.UpdatedAt) {
+		t.Errorf("updated at %v, want %v", got.UpdatedAt, want.UpdatedAt)
+	}
+	gotRest, wantRest := *got, *want
+	gotRest.State, wantRest.State = nil, nil
+	gotRest.UpdatedAt, wantRest.UpdatedAt = time.Time{}, time.Time{}
+	if !reflect.DeepEqual(gotRest, wantRest) {
+		t.Errorf("checkpoint = %+v, want %+v", gotRest, wantRest)
+	}
+}
+
+func TestMemoryCheckpointStoreEviction(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		maxRuns int
+		ttl     time.Duration
+		saved   []*Checkpoint
+		kept    []string
+		evicted []string
+	}{
+		{
+			name: "finished runs expire after the TTL",
+			ttl:  time.Hour,
+			saved: []*Checkpoint{
+				testCheckpoint("completed", 3, CheckpointCompleted, now.Add(-2*time.Hour)),
+				testCheckpoint("failed", 1, CheckpointFailed, now.Add(-2*time.Hour)),
+				testCheckpoint("cancelled", 1, CheckpointCancelled, now.Add(-2*time.Hour)),
+				testCheckpoint("recent", 3, CheckpointCompleted, now.Add(-time.Minute)),
+			},
+			kept:    []string{"recent"},
+			evicted: []string{"completed", "failed", "cancelled"},
+		},
+		{
+			name: "unfinished runs never expire",
+			ttl:  time.Hour,
+			saved: []*Checkpoint{
+				testCheckpoint("running", 1, CheckpointRunning, now.Add(-2*time.Hour)),
+				testCheckpoint("interrupted", 1, CheckpointInterrupted, now.Add(-2*time.Hour)),
+			},
+			kept: []string{"running", "interrupted"},
+		},
+		{
+			name:    "the oldest finished runs beyond MaxRuns are evicted",
+			maxRuns: 2,
+			saved: []*Checkpoint{
+				testCheckpoint("oldest", 3, CheckpointCompleted, now.Add(-3*time.Minute)),
+				testCheckpoint("running", 1, CheckpointRunning, now.Add(-4*time.Minute)),
+				testCheckpoint("newest", 3, CheckpointFailed, now),
+				testCheckpoint("older", 3, CheckpointCompleted, now.Add(-2*time.Minute)),
+				testCheckpoint("newer", 3, CheckpointCompleted, now.Add(-time.Minute)),
+			},
+			kept:    []string{"running", "newest", "newer"},
+			evicted: []string{"oldest", "older"},
+		},
+		{
+			name: "no limits keep every run",
+			saved: []*Checkpoint{
+				testCheckpoint("old", 3, CheckpointCompleted, now.Add(-24*time.Hour)),
+				testCheckpoint("new", 3, CheckpointCompleted, now),
+			},
+			kept: []string{"old", "new"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryCheckpointStore()
+			store.MaxRuns, store.TTL = tt.maxRuns, tt.ttl
+			for _, checkpoint := range tt.saved {
+				if err := store.Save(ctx, checkpoint); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, runID := range tt.kept {
+				if _, err := store.Load(ctx, runID); err != nil {
+					t.Errorf("Load(%s) error = %v, want the run kept", runID, err)
+				}
+			}
+			for _, runID := range tt.evicted {
+				if _, err := store.Load(ctx, runID); !errors.Is(err, ErrCheckpointNotFound) {
+					t.Errorf("Load(%s) error = %v, want the run evicted", runID, err)
+				}
+				if _, err := store.History(ctx, runID); !errors.Is(err, ErrCheckpointNotFound) {
+					t.Errorf("History(%s) error = %v, want the steps evicted", runID, err)
+				}
+			}
+		})
+	}
+}
+
+func TestResumeFromCheckpointStores(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range checkpointStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// The report fails once, after every search has been checkpointed
+			failing := append([]FakeRule{{
+				Pattern: regexp.MustCompile(`レポートを作成してください`),
+				Err:     errors.New("service unavailable"),
+			}}, DemoScript()...)
+			engine, err := NewEngine("", "", WithFakeLLM(failing...), WithCheckpointStore(store))
+			if err != nil {
+				t.Fatal(err)
+			}
+			failed, err := engine.Execute(ctx, "LLMの最新動向を調べて")
+			if err == nil {
+				t.Fatal("Execute() succeeded, want the report to fail")
+			}
+			checkpoint, err := store.Load(ctx, failed.RunID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if checkpoint.Status != CheckpointFailed || checkpoint.NextNode != "synthesize_and_report" {
+				t.Fatalf("checkpoint is %s at %s, want failed at synthesize_and_report", checkpoint.Status, checkpoint.NextNode)
+			}
+
+			fake := NewFakeModel()
+			engine, err = NewEngine("", "", WithLLM(fake, ProviderFake), WithCheckpointStore(store))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := engine.Resume(ctx, failed.RunID)
+			if err != nil {
+				t.Fatalf("Resume() error = %v", err)
+			}
+
+			// Only the report is written again
+			if prompts := fake.Prompts(); len(prompts) != 1 {
+				t.Errorf("resumed run prompted the model %d times, want once", len(prompts))
+			}
+			if got := len(result.FinalState.GetRawContents()); got != 5 {
+				t.Errorf("resumed run has %d search results, want 5", got)
+			}
+			if checkpoint, err := store.Load(ctx, failed.RunID); err != nil || checkpoint.Status != CheckpointCompleted {
+				t.Errorf("checkpoint after resuming = %v, %v, want completed", checkpoint, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package graph
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BranchPrefix marks an edge result that starts a dynamic branch instead of a node
+const BranchPrefix = "branch:"
+
+// EndRoute is listed in an edge's routes when the edge may finish the run
+const EndRoute = "END"
+
+//go:embed definitions/research.yaml
+var definitionFiles embed.FS
+
+// GraphDefinition describes a graph topology declaratively (YAML or JSON)
+type GraphDefinition struct {
+	Name     string             `yaml:"name" json:"name"`
+	Entry    string             `yaml:"entry" json:"entry"`
+	Nodes    []NodeDefinition   `yaml:"nodes" json:"nodes"`
+	Edges    []EdgeDefinition   `yaml:"edges" json:"edges"`
+	Branches []BranchDefinition `yaml:"branches" json:"branches"`
+}
+
+// NodeDefinition declares a node and how the graph leaves it
+type NodeDefinition struct {
+	Name string `yaml:"name" json:"name"`
+	// Edge names a registered conditional edge evaluated after the node
+	Edge string `yaml:"edge,omitempty" json:"edge,omitempty"`
+	// Next is a fixed successor used when no edge is given
+	Next string `yaml:"next,omitempty" json:"next,omitempty"`
+	// MaxVisits caps how often the node may run in one run (0 = unlimited).
+	// Nodes inside a loop should set it so the loop always terminates.
+	MaxVisits int `yaml:"max_visits,omitempty" json:"max_visits,omitempty"`
+	// Retry retries the node on transient errors
+	Retry *RetryDefinition `yaml:"retry,omitempty" json:"retry,omitempty"`
+	// Timeout limits a single execution of the node, e.g. "2m"
+	Timeout string `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+// EdgeDefinition declares a registered edge and the routes it may return.
+// An edge without routes always finishes the run.
+type EdgeDefinition struct {
+	Name   string   `yaml:"name" json:"name"`
+	Routes []string `yaml:"routes" json:"routes"`
+}
+
+// BranchDefinition declares a dynamic branch point ("branch:<name>")
+type BranchDefinition struct {
+	Name string `yaml:"name" json:"name"`
+	// FanOut names the registered fan-out that splits and reduces the branches
+	FanOut string `yaml:"fanout" json:"fanout"`
+	// Node is executed once per branch payload, in parallel
+	Node string `yaml:"node" json:"node"`
+	// Join is the node executed once every branch has finished
+	Join string `yaml:"join" json:"join"`
+	// MaxConcurrency limits the branches running at once (0 = unlimited)
+	MaxConcurrency int `yaml:"max_concurrency,omitempty" json:"max_concurrency,omitempty"`
+	// Retry retries each branch on transient errors
+	Retry *RetryDefinition `yaml:"retry,omitempty" json:"retry,omitempty"`
+	// Timeout limits a single execution of each branch, e.g. "30s"
+	Timeout string `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+// DefaultDefinition returns the built-in research assistant graph
+func DefaultDefinition() *GraphDefinition {
+	data, err := definitionFiles.ReadFile("definitions/research.yaml")
+	if err != nil {
+		panic(fmt.Sprintf("embedded graph definition missing: %v", err))
+	}
+
+	def, err := ParseDefinition(data, "yaml")
+	if err != nil {
+		panic(fmt.Sprintf("embedded graph definition is invalid: %v", err))
+	}
+	return def
+}
+
+// LoadDefinition reads a graph definition from a YAML or JSON file
+func LoadDefinition(path string) (*GraphDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read graph definition: %w", err)
+	}
+
+	format := "yaml"
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		format = "json"
+	}
+
+	def, err := ParseDefinition(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse graph definition %s: %w", path, err)
+	}
+	return def, nil
+}
+
+// ParseDefinition decodes a graph definition in the given format ("yaml" or "json").
+// Unknown keys are rejected so that typos do not silently drop settings.
+func ParseDefinition(data []byte, format string) (*GraphDefinition, error) {
+	var def GraphDefinition
+
+	switch strings.ToLower(format) {
+	case "json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&def); err != nil {
+			return nil, err
+		}
+	case "yaml", "yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&def); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported graph definition format: %s", format)
+	}
+
+	return &def, nil
+}
+
+// clone returns a deep copy of the definition
+func (d *GraphDefinition) clone() *GraphDefinition {
+	clone := *d
+	clone.Nodes = append([]NodeDefinition(nil), d.Nodes...)
+	for i, node := range clone.Nodes {
+		clone.Nodes[i].Retry = node.Retry.clone()
+	}
+	clone.Edges = append([]EdgeDefinition(nil), d.Edges...)
+	for i, edge := range clone.Edges {
+		clone.Edges[i].Routes = append([]string(nil), edge.Routes...)
+	}
+	clone.Branches = append([]BranchDefinition(nil), d.Branches...)
+	for i, branch := range clone.Branches {
+		clone.Branches[i].Retry = branch.Retry.clone()
+	}
+	return &clone
+}
+
+// Flow converts the definition into the runtime flow structure
+func (d *GraphDefinition) Flow() *GraphFlow {
+	flow := &GraphFlow{
+		Entry:      d.Entry,
+		NodeToEdge: make(map[string]string),
+		NodeToNext: make(map[string]string),
+		EdgeRoutes: make(map[string][]string),
+		Branches:   make(map[string]BranchDefinition),
+		MaxVisits:  make(map[string]int),
+	}
+
+	for _, node := range d.Nodes {
+		if node.MaxVisits > 0 {
+			flow.MaxVisits[node.Name] = node.MaxVisits
+		}
+		if node.Edge != "" {
+			flow.NodeToEdge[node.Name] = node.Edge
+		} else if node.Next != "" {
+			flow.NodeToNext[node.Name] = node.Next
+		}
+	}
+	for _, edge := range d.Edges {
+		flow.EdgeRoutes[edge.Name] = append([]string(nil), edge.Routes...)
+	}
+	for _, branch := range d.Branches {
+		flow.Branches[branch.Name] = branch
+	}
+
+	return flow
+}
@@ -1,12 +1,14 @@
 package graph
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 )
 
-// Edge represents a transition function between nodes
-type Edge func(state *AppState) (string, error)
+// Edge represents a transition function between nodes. ctx is the run's
+// context, carrying its cancellation, trace and logging attributes.
+type Edge func(ctx context.Context, state *AppState) (string, error)
 
 // EdgeRegistry manages edge logic
 type EdgeRegistry struct {
@@ -26,6 +28,7 @@ func NewEdgeRegistry() *EdgeRegistry {
 	registry.RegisterEdge("after_individual_search", registry.AfterIndividualSearch)
 	registry.RegisterEdge("after_merge", registry.AfterMerge)
 	registry.RegisterEdge("after_report", registry.AfterReport)
+	registry.RegisterEdge("review_report", registry.ReviewReport)
 
 	return registry
 }
@@ -42,9 +45,9 @@ func (r *EdgeRegistry) GetEdge(name string) (Edge, bool) {
 }
 
 // AfterClassify decides next node after intent classification
-func (r *EdgeRegistry) AfterClassify(state *AppState) (string, error) {
+func (r *EdgeRegistry) AfterClassify(ctx context.Context, state *AppState) (string, error) {
 	intent := state.GetIntent()
-	log.Printf("Edge decision after classify: intent=%s", intent)
+	slog.InfoContext(ctx, "Edge decision after classify", "intent", intent)
 
 	switch intent {
 	case "research":
@@ -63,17 +66,17 @@ func (r *EdgeRegistry) AfterClassify(state *AppState) (string, error) {
 
 // AfterGenerateQueries decides next node after query generation
 // Returns special format for dynamic branching: "branch:search_query"
-func (r *EdgeRegistry) AfterGenerateQueries(state *AppState) (string, error) {
+func (r *EdgeRegistry) AfterGenerateQueries(ctx context.Context, state *AppState) (string, error) {
 	if len(state.SearchQueries) == 0 {
 		return "", fmt.Errorf("no search queries generated")
 	}
 	
 	// Signal dynamic branching to engine
-	return "branch:search_query", nil
+	return BranchPrefix + "search_query", nil
 }
 
 // AfterSearch decides next node after search execution
-func (r *EdgeRegistry) AfterSearch(state *AppState) (string, error) {
+func (r *EdgeRegistry) AfterSearch(ctx context.Context, state *AppState) (string, error) {
 	if len(state.RawContents) == 0 {
 		return "", fmt.Errorf("no search results collected")
 	}
@@ -81,52 +84,72 @@ func (r *EdgeRegistry) AfterSearch(state *AppState) (string, error) {
 }
 
 // AfterIndividualSearch handles transition after individual search nodes (used in dynamic branching)
-func (r *EdgeRegistry) AfterIndividualSearch(state *AppState) (string, error) {
+func (r *EdgeRegistry) AfterIndividualSearch(ctx context.Context, state *AppState) (string, error) {
 	// This should not be called directly - individual searches are handled by the engine
 	// But we keep it for completeness
 	return "merge_search_results", nil
 }
 
 // AfterMerge decides next node after merging search results
-func (r *EdgeRegistry) AfterMerge(state *AppState) (string, error) {
-	if len(state.RawContents) == 0 {
+func (r *EdgeRegistry) AfterMerge(ctx context.Context, state *AppState) (string, error) {
+	// A run out of budget reports from what it already knows
+	if len(state.RawContents) == 0 && !OverBudget(ctx) {
 		return "", fmt.Errorf("no search results to synthesize")
 	}
-	return "synthesize_and_report", nil
+	return "draft_outline", nil
 }
 
 // AfterReport is the terminal edge
-func (r *EdgeRegistry) AfterReport(state *AppState) (string, error) {
+func (r *EdgeRegistry) AfterReport(ctx context.Context, state *AppState) (string, error) {
 	// Terminal node - no next node
 	return "", nil
 }
 
+// Minimum sources and report length ReviewReport accepts
+const (
+	minReportSources = 3
+	minReportLength  = 1000
+)
+
+// ReviewReport loops back to query generation while the report looks too thin.
+// Used by iterative research graphs; the loop is bounded by max_visits.
+func (r *EdgeRegistry) ReviewReport(ctx context.Context, state *AppState) (string, error) {
+	sources := len(state.GetRawContents())
+	length := len([]rune(state.Report))
+	slog.InfoContext(ctx, "Edge decision after report review", "sources", sources, "length", length)
+
+	if sources < minReportSources || length < minReportLength {
+		return "generate_search_queries", nil
+	}
+	return "", nil
+}
+
 // GraphFlow defines the flow structure
 type GraphFlow struct {
+	// Entry is the first node executed
+	Entry string
 	// Maps node names to their subsequent edge names
 	NodeToEdge map[string]string
+	// Maps node names to a fixed successor (no edge function needed)
+	NodeToNext map[string]string
+	// Maps edge names to the routes they may return
+	EdgeRoutes map[string][]string
+	// Dynamic branch points keyed by branch name
+	Branches map[string]BranchDefinition
+	// Maps node names to the number of times they may run
+	MaxVisits map[string]int
 }
 
 // NewGraphFlow creates the default graph flow
 func NewGraphFlow() *GraphFlow {
-	return &GraphFlow{
-		NodeToEdge: map[string]string{
-			"classify_intent_and_topic": "after_classify",
-			"generate_search_queries":   "after_generate_queries",
-			"execute_parallel_search":   "after_search",
-			"merge_search_results":      "after_merge",
-			"synthesize_and_report":     "after_report",
-			"answer_directly":           "after_report",
-			"handle_chat":               "after_report",
-		},
-	}
+	return DefaultDefinition().Flow()
 }
 
 // GetNextNode determines the next node based on current node and state
-func (f *GraphFlow) GetNextNode(currentNode string, state *AppState, edgeRegistry *EdgeRegistry) (string, error) {
+func (f *GraphFlow) GetNextNode(ctx context.Context, currentNode string, state *AppState, edgeRegistry *EdgeRegistry) (string, error) {
 	edgeName, exists := f.NodeToEdge[currentNode]
 	if !exists {
-		return "", nil // Terminal node
+		return f.NodeToNext[currentNode], nil // Fixed successor or terminal node
 	}
 
 	edge, exists := edgeRegistry.GetEdge(edgeName)
@@ -134,5 +157,33 @@ func (f *GraphFlow) GetNextNode(currentNode string, state *AppState, edgeRegistr
 		return "", fmt.Errorf("edge %s not found", edgeName)
 	}
 
-	return edge(state)
-}
\ No newline at end of file
+	next, err := edge(ctx, state)
+	if err != nil {
+		return "", err
+	}
+	if next == EndRoute {
+		next = ""
+	}
+	if !f.declaresRoute(edgeName, next) {
+		return "", fmt.Errorf("edge %s returned %q, which is not one of its declared routes %v", edgeName, next, f.EdgeRoutes[edgeName])
+	}
+	return next, nil
+}
+
+// declaresRoute reports whether the edge may return next ("" finishes the run).
+// An edge without routes always finishes the run.
+func (f *GraphFlow) declaresRoute(edgeName, next string) bool {
+	routes := f.EdgeRoutes[edgeName]
+	if len(routes) == 0 {
+		return next == ""
+	}
+	if next == "" {
+		next = EndRoute
+	}
+	for _, route := range routes {
+		if route == next {
+			return true
+		}
+	}
+	return false
+}
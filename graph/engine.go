@@ -3,427 +3,343 @@ package graph
 import (
 	"context"
 	"fmt"
-	"log"
-	"strings"
+	"log/slog"
 	"sync"
 	"time"
+
+	"github.com/tmc/langchaingo/llms"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Engine is the graph execution engine
 type Engine struct {
-	nodeRegistry *NodeRegistry
-	edgeRegistry *EdgeRegistry
-	flow         *GraphFlow
-	maxSteps     int
+	nodeRegistry   *NodeRegistry
+	edgeRegistry   *EdgeRegistry
+	branchRegistry *BranchRegistry
+	flow           *GraphFlow
+	definition     *GraphDefinition
+	checkpoints    CheckpointStore
+	maxSteps       int
+	runTimeout     time.Duration
+
+	interruptBefore map[string]bool
+	interruptAfter  map[string]bool
+
+	// retry policies keyed by node name
+	retryPolicies map[string]RetryPolicy
+
+	// timeouts of a single node execution keyed by node name
+	nodeTimeouts       map[string]time.Duration
+	defaultNodeTimeout time.Duration
+
+	// observers and wrappers of node execution
+	hooks      hookChain
+	middleware []Middleware
+
+	// whether streamed updates carry full state clones besides their patches
+	stateSnapshots bool
+
+	// directory runs are traced into; empty disables tracing
+	traceDir string
+
+	// token and cost limits of a run and the model prices they are computed with
+	budget Budget
+	prices map[string]ModelPrice
+
+	// creates the OpenTelemetry spans of runs; nil uses the global provider
+	tracer trace.Tracer
+
+	// model the nodes call and its name; nil keeps the registry's model
+	llm      llms.Model
+	llmModel string
+	// models and sampling options of single nodes keyed by node name
+	nodeModels map[string]NodeModel
+
+	// in-flight runs keyed by run ID
+	mu     sync.Mutex
+	active map[string]*runner
+}
+
+// EngineOption configures an Engine
+type EngineOption func(*Engine)
+
+// WithDefinition replaces the built-in topology with a declarative graph definition
+func WithDefinition(def *GraphDefinition) EngineOption {
+	return func(e *Engine) {
+		e.definition = def
+	}
 }
 
-// NewEngine creates a new graph execution engine
-func NewEngine(apiKey, serpAPIKey string) (*Engine, error) {
-	nodeRegistry, err := NewNodeRegistry(apiKey, serpAPIKey)
+// WithCheckpointStore persists a checkpoint after every node so failed runs can be resumed
+func WithCheckpointStore(store CheckpointStore) EngineOption {
+	return func(e *Engine) {
+		e.checkpoints = store
+	}
+}
+
+// WithLLM makes the nodes call llm, e.g. a Provider's model, instead of OpenAI.
+// model names it in usage, prices and spans.
+func WithLLM(llm llms.Model, model string) EngineOption {
+	return func(e *Engine) {
+		e.llm = llm
+		e.llmModel = model
+	}
+}
+
+// NewEngine creates a new graph execution engine. The nodes call OpenAI with
+// apiKey unless WithLLM is given.
+func NewEngine(apiKey, serpAPIKey string, opts ...EngineOption) (*Engine, error) {
+	nodeRegistry, err := newNodeRegistry(serpAPIKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create node registry: %w", err)
 	}
 
-	return &Engine{
-		nodeRegistry: nodeRegistry,
-		edgeRegistry: NewEdgeRegistry(),
-		flow:         NewGraphFlow(),
-		maxSteps:     25, // Increased for dynamic branching support
-	}, nil
+	engine, err := newEngine(nodeRegistry, NewEdgeRegistry(), NewBranchRegistry(), DefaultDefinition(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := nodeRegistry.useDefaultModel(apiKey); err != nil {
+		return nil, fmt.Errorf("failed to create node registry: %w", err)
+	}
+	return engine, nil
+}
+
+// newEngine assembles and validates an engine from registries and a definition
+func newEngine(nodes *NodeRegistry, edges *EdgeRegistry, branches *BranchRegistry, def *GraphDefinition, opts ...EngineOption) (*Engine, error) {
+	engine := &Engine{
+		nodeRegistry:   nodes,
+		edgeRegistry:   edges,
+		branchRegistry: branches,
+		definition:     def,
+		maxSteps:       25, // Increased for dynamic branching support
+
+		interruptBefore: make(map[string]bool),
+		interruptAfter:  make(map[string]bool),
+		retryPolicies:   make(map[string]RetryPolicy),
+		nodeTimeouts:    make(map[string]time.Duration),
+		nodeModels:      make(map[string]NodeModel),
+		active:          make(map[string]*runner),
+	}
+	for _, opt := range opts {
+		opt(engine)
+	}
+	if engine.llm != nil {
+		engine.nodeRegistry.useModel(engine.llm, engine.llmModel)
+	}
+	for node, model := range engine.nodeModels {
+		if err := model.validate(node); err != nil {
+			return nil, err
+		}
+		if _, exists := engine.nodeRegistry.GetNode(node); !exists {
+			return nil, fmt.Errorf("model configured for unknown node %s", node)
+		}
+		engine.nodeRegistry.useNodeModel(node, model)
+	}
+
+	// Interrupted runs wait in a checkpoint until they are resumed
+	if err := engine.checkInterrupts(); err != nil {
+		return nil, err
+	}
+	if err := engine.budget.validate(); err != nil {
+		return nil, err
+	}
+	if engine.checkpoints == nil && len(engine.interruptBefore)+len(engine.interruptAfter) > 0 {
+		engine.checkpoints = NewMemoryCheckpointStore()
+	}
+
+	// Validate the topology before any run can start
+	if err := engine.definition.Validate(engine.nodeRegistry, engine.edgeRegistry, engine.branchRegistry); err != nil {
+		return nil, fmt.Errorf("invalid graph definition: %w", err)
+	}
+	for _, issue := range engine.Validate() {
+		slog.Warn("⚠️  "+issue.Message, "graph", engine.definition.Name)
+	}
+	engine.flow = engine.definition.Flow()
+	if err := engine.declaredRetries(); err != nil {
+		return nil, err
+	}
+	if err := engine.declaredTimeouts(); err != nil {
+		return nil, err
+	}
+	if err := engine.nodeRegistry.useSearchLimits(engine); err != nil {
+		return nil, err
+	}
+
+	return engine, nil
+}
+
+// declaredRetries adds the retry policies of the definition that no option overrides
+func (e *Engine) declaredRetries() error {
+	declare := func(node string, retry *RetryDefinition) error {
+		if _, overridden := e.retryPolicies[node]; retry == nil || overridden {
+			return nil
+		}
+		policy, err := retry.Policy()
+		if err != nil {
+			return fmt.Errorf("invalid retry policy for %s: %w", node, err)
+		}
+		e.retryPolicies[node] = policy
+		return nil
+	}
+
+	for _, node := range e.definition.Nodes {
+		if err := declare(node.Name, node.Retry); err != nil {
+			return err
+		}
+	}
+	for _, branch := range e.definition.Branches {
+		if err := declare(branch.Node, branch.Retry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate statically checks the engine's graph and returns every problem found
+func (e *Engine) Validate() []ValidationIssue {
+	return ValidateDefinition(e.definition, e.nodeRegistry, e.edgeRegistry, e.branchRegistry)
 }
 
 // ExecutionResult represents the result of graph execution
 type ExecutionResult struct {
+	RunID         string
 	FinalState    *AppState
 	ExecutionTime time.Duration
 	StepsExecuted int
 	Path          []string
+	Interrupt     *Interrupt // set when the run paused for review
+	Usage         Usage      // tokens and cost of the run, including earlier attempts when resumed
 }
 
 // Execute runs the graph with the given input
 func (e *Engine) Execute(ctx context.Context, userInput string) (*ExecutionResult, error) {
-	startTime := time.Now()
-	
-	// Initialize state
+	r := e.newRunner(NewAppState(userInput), nil)
+	return e.run(ctx, r, e.flow.Entry)
+}
+
+// StreamExecute executes the graph with streaming updates.
+// It shares Execute's semantics; updates are only observed and the channel
+// is closed once the run has finished.
+func (e *Engine) StreamExecute(ctx context.Context, userInput string, updates chan<- GraphUpdate) (*ExecutionResult, error) {
+	defer close(updates)
+
 	state := NewAppState(userInput)
-	
-	// Track execution path
-	var path []string
-	
-	// Start with the entry node
-	currentNode := "classify_intent_and_topic"
-	stepsExecuted := 0
-	
-	// Execute the graph
-	for currentNode != "" && stepsExecuted < e.maxSteps {
-		log.Printf("Executing node: %s", currentNode)
-		path = append(path, currentNode)
-		
-		// Update current node in state
-		state.CurrentNode = currentNode
-		
-		// Get and execute the node
-		node, exists := e.nodeRegistry.GetNode(currentNode)
-		if !exists {
-			return nil, fmt.Errorf("node %s not found", currentNode)
-		}
-		
-		// Execute the node
-		if err := node(ctx, state); err != nil {
-			state.SetError(err)
-			return &ExecutionResult{
-				FinalState:    state,
-				ExecutionTime: time.Since(startTime),
-				StepsExecuted: stepsExecuted,
-				Path:          path,
-			}, fmt.Errorf("node %s failed: %w", currentNode, err)
-		}
-		
-		// Determine next node
-		nextNode, err := e.flow.GetNextNode(currentNode, state, e.edgeRegistry)
-		if err != nil {
-			state.SetError(err)
-			return &ExecutionResult{
-				FinalState:    state,
-				ExecutionTime: time.Since(startTime),
-				StepsExecuted: stepsExecuted,
-				Path:          path,
-			}, fmt.Errorf("edge decision failed after %s: %w", currentNode, err)
-		}
-		
-		currentNode = nextNode
-		stepsExecuted++
+	r := e.streamRunner(ctx, state, updates)
+	return e.run(ctx, r, e.flow.Entry)
+}
+
+// Resume continues a checkpointed run from the node after its last successful one,
+// or from the interrupt it is waiting at
+func (e *Engine) Resume(ctx context.Context, runID string, opts ...ResumeOption) (*ExecutionResult, error) {
+	checkpoint, err := e.loadResumable(ctx, runID, opts)
+	if err != nil {
+		return nil, err
 	}
-	
-	// Check if we hit the step limit
-	if stepsExecuted >= e.maxSteps {
-		return nil, fmt.Errorf("execution exceeded maximum steps (%d)", e.maxSteps)
+
+	r := e.newRunner(checkpoint.State, nil)
+	r.restore(checkpoint)
+	return e.run(ctx, r, checkpoint.NextNode)
+}
+
+// StreamResume is Resume with streaming updates; the channel is closed when the run ends
+func (e *Engine) StreamResume(ctx context.Context, runID string, updates chan<- GraphUpdate, opts ...ResumeOption) (*ExecutionResult, error) {
+	defer close(updates)
+
+	checkpoint, err := e.loadResumable(ctx, runID, opts)
+	if err != nil {
+		return nil, err
 	}
-	
-	return &ExecutionResult{
-		FinalState:    state,
-		ExecutionTime: time.Since(startTime),
-		StepsExecuted: stepsExecuted,
-		Path:          path,
-	}, nil
+
+	r := e.streamRunner(ctx, checkpoint.State, updates)
+	r.restore(checkpoint)
+	return e.run(ctx, r, checkpoint.NextNode)
 }
 
-// StreamExecute executes the graph with streaming updates
-func (e *Engine) StreamExecute(ctx context.Context, userInput string, updates chan<- GraphUpdate) (*ExecutionResult, error) {
-	startTime := time.Now()
-	
-	// Initialize state
-	state := NewAppState(userInput)
-	
-	// Set up streaming callback for real-time updates
-	state.SetStreamingCallback(func(nodeId string, chunk string) {
+// loadResumable loads the checkpoint of a run that has not completed yet
+func (e *Engine) loadResumable(ctx context.Context, runID string, opts []ResumeOption) (*Checkpoint, error) {
+	if e.checkpoints == nil {
+		return nil, fmt.Errorf("resume requires a checkpoint store")
+	}
+
+	checkpoint, err := e.checkpoints.Load(ctx, runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load run %s: %w", runID, err)
+	}
+	if checkpoint.Status == CheckpointCompleted {
+		return nil, fmt.Errorf("run %s has already completed", runID)
+	}
+
+	var options resumeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.state != nil {
+		checkpoint.State = options.state.Clone()
+	}
+
+	slog.InfoContext(ctx, "Resuming run", "run_id", runID, "next_node", checkpoint.NextNode, "step", checkpoint.Step)
+	checkpoint.State.SetError(nil)
+	return checkpoint, nil
+}
+
+// streamRunner prepares a run that streams its updates and chunks to updates
+func (e *Engine) streamRunner(ctx context.Context, state *AppState, updates chan<- GraphUpdate) *runner {
+	emit, chunks := streamUpdates(ctx, updates)
+	r := e.newRunner(state, emit)
+	r.streamChunks(chunks)
+	return r
+}
+
+// streamUpdates returns the emitter of a run's updates to updates and the sender of
+// its streaming chunks, which subgraphs share. Once ctx is done the emitter drops
+// updates a stalled consumer does not take; chunks never wait more than 100ms.
+func streamUpdates(ctx context.Context, updates chan<- GraphUpdate) (emit, chunks func(GraphUpdate)) {
+	chunks = func(update GraphUpdate) {
 		// Only skip completely empty chunks
-		if len(chunk) == 0 {
+		if len(update.Chunk) == 0 {
 			return
 		}
-		
+
 		// For very long chunks, truncate but preserve structure
-		displayChunk := chunk
-		if len(chunk) > 2000 {
-			displayChunk = chunk[:2000] + "..."
+		if len(update.Chunk) > 2000 {
+			update.Chunk = update.Chunk[:2000] + "..."
 		}
-		
-		// Send streaming chunk as a special update type
+
 		select {
-		case updates <- GraphUpdate{
-			Type:      "streaming_chunk",
-			Node:      nodeId,
-			State:     state.Clone(),
-			Chunk:     displayChunk,
-			Timestamp: time.Now(),
-		}:
+		case updates <- update:
 		case <-time.After(100 * time.Millisecond):
 			// Timeout to prevent blocking
 		}
-	})
-	
-	// Track execution path
-	var path []string
-	
-	// Start with the entry node
-	currentNode := "classify_intent_and_topic"
-	stepsExecuted := 0
-	
-	// Send initial update
-	updates <- GraphUpdate{
-		Type:      "start",
-		Node:      currentNode,
-		State:     state.Clone(),
-		Timestamp: time.Now(),
 	}
-	
-	// Execute the graph
-	for currentNode != "" && stepsExecuted < e.maxSteps {
-		log.Printf("Executing node: %s", currentNode)
-		path = append(path, currentNode)
-		
-		// Update current node in state
-		state.CurrentNode = currentNode
-		
-		// Send node start update
-		updates <- GraphUpdate{
-			Type:      "node_start",
-			Node:      currentNode,
-			State:     state.Clone(),
-			Timestamp: time.Now(),
-		}
-		
-		// Get and execute the node
-		node, exists := e.nodeRegistry.GetNode(currentNode)
-		if !exists {
-			err := fmt.Errorf("node %s not found", currentNode)
-			updates <- GraphUpdate{
-				Type:      "error",
-				Node:      currentNode,
-				State:     state.Clone(),
-				Error:     err,
-				Timestamp: time.Now(),
-			}
-			close(updates)
-			return nil, err
-		}
-		
-		// Execute the node
-		if err := node(ctx, state); err != nil {
-			state.SetError(err)
-			updates <- GraphUpdate{
-				Type:      "error",
-				Node:      currentNode,
-				State:     state.Clone(),
-				Error:     err,
-				Timestamp: time.Now(),
-			}
-			close(updates)
-			return &ExecutionResult{
-				FinalState:    state,
-				ExecutionTime: time.Since(startTime),
-				StepsExecuted: stepsExecuted,
-				Path:          path,
-			}, fmt.Errorf("node %s failed: %w", currentNode, err)
-		}
-		
-		// Send node complete update
-		updates <- GraphUpdate{
-			Type:      "node_complete",
-			Node:      currentNode,
-			State:     state.Clone(),
-			Timestamp: time.Now(),
-		}
-		
-		// Determine next node
-		nextNode, err := e.flow.GetNextNode(currentNode, state, e.edgeRegistry)
-		if err != nil {
-			state.SetError(err)
-			updates <- GraphUpdate{
-				Type:      "error",
-				Node:      currentNode,
-				State:     state.Clone(),
-				Error:     err,
-				Timestamp: time.Now(),
-			}
-			close(updates)
-			return &ExecutionResult{
-				FinalState:    state,
-				ExecutionTime: time.Since(startTime),
-				StepsExecuted: stepsExecuted,
-				Path:          path,
-			}, fmt.Errorf("edge decision failed after %s: %w", currentNode, err)
-		}
-		
-		// Check for dynamic branching signal
-		if strings.HasPrefix(nextNode, "branch:") {
-			branchType := strings.TrimPrefix(nextNode, "branch:")
-			if branchType == "search_query" {
-				// Execute dynamic search branching
-				if err := e.executeDynamicSearchBranching(ctx, state, updates, &path, &stepsExecuted, startTime); err != nil {
-					close(updates)
-					return &ExecutionResult{
-						FinalState:    state,
-						ExecutionTime: time.Since(startTime),
-						StepsExecuted: stepsExecuted,
-						Path:          path,
-					}, err
-				}
-				// After branching, go to merge node
-				currentNode = "merge_search_results"
-			} else {
-				err := fmt.Errorf("unknown branch type: %s", branchType)
-				updates <- GraphUpdate{
-					Type:      "error",
-					Node:      currentNode,
-					State:     state.Clone(),
-					Error:     err,
-					Timestamp: time.Now(),
-				}
-				close(updates)
-				return nil, err
-			}
-		} else {
-			currentNode = nextNode
+
+	emit = func(update GraphUpdate) {
+		// Prefer delivering over dropping while the consumer keeps up
+		select {
+		case updates <- update:
+			return
+		default:
 		}
-		stepsExecuted++
-	}
-	
-	// Check if we hit the step limit
-	if stepsExecuted >= e.maxSteps {
-		err := fmt.Errorf("execution exceeded maximum steps (%d)", e.maxSteps)
-		updates <- GraphUpdate{
-			Type:      "error",
-			Node:      currentNode,
-			State:     state.Clone(),
-			Error:     err,
-			Timestamp: time.Now(),
+		select {
+		case updates <- update:
+		case <-ctx.Done():
 		}
-		close(updates)
-		return nil, err
 	}
-	
-	// Send completion update
-	updates <- GraphUpdate{
-		Type:      "complete",
-		Node:      "",
-		State:     state.Clone(),
-		Timestamp: time.Now(),
-	}
-	
-	// Close the updates channel after sending all messages
-	close(updates)
-	
-	return &ExecutionResult{
-		FinalState:    state,
-		ExecutionTime: time.Since(startTime),
-		StepsExecuted: stepsExecuted,
-		Path:          path,
-	}, nil
+	return emit, chunks
 }
 
 // GraphUpdate represents a streaming update from the graph execution
 type GraphUpdate struct {
-	Type      string    // "start", "node_start", "node_complete", "error", "complete", "streaming_chunk"
+	Type      string // "start", "node_start", "node_complete", "error", "complete", "streaming_chunk", "interrupt", "retry", "cancelled"
+	RunID     string
 	Node      string
-	State     *AppState
+	Path      []string // Nested node path, e.g. [search_and_merge, search_query_1] inside a subgraph
+	State     *AppState  // Full snapshot: on start and interrupt, or on every update with WithStateSnapshots
+	Patch     []PatchOp  // Changes to the run's state since the previous update
+	Version   int        // State version after Patch
 	Error     error
-	Chunk     string    // For streaming_chunk type
+	Chunk     string     // For streaming_chunk type
+	Interrupt *Interrupt // For interrupt type
+	Attempt   int        // For retry type: the attempt about to start
+	Usage     *Usage     // Tokens and cost of the run so far on node_complete, complete, error and cancelled
 	Timestamp time.Time
 }
-
-// executeDynamicSearchBranching executes individual search queries in parallel
-func (e *Engine) executeDynamicSearchBranching(ctx context.Context, state *AppState, updates chan<- GraphUpdate, path *[]string, stepsExecuted *int, startTime time.Time) error {
-	queries := state.GetSearchQueries()
-	if len(queries) == 0 {
-		return fmt.Errorf("no search queries available for branching")
-	}
-	
-	log.Printf("Starting dynamic search branching with %d queries", len(queries))
-	for i, q := range queries {
-		log.Printf("Query %d: %s", i+1, q)
-	}
-	
-	var wg sync.WaitGroup
-	resultsChan := make(chan struct {
-		queryId  string
-		content  string
-		err      error
-	}, len(queries))
-	
-	// Execute each query as an individual node
-	for i, query := range queries {
-		wg.Add(1)
-		
-		go func(index int, searchQuery string) {
-			defer wg.Done()
-			
-			queryId := fmt.Sprintf("search_query_%d", index+1)
-			*path = append(*path, queryId)
-			
-			// Send node start for individual search
-			updates <- GraphUpdate{
-				Type:      "node_start",
-				Node:      queryId,
-				State:     state.Clone(),
-				Timestamp: time.Now(),
-			}
-			
-			// Create timeout context for individual search
-			searchCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-			defer cancel()
-			
-			// Perform the actual search
-			var content string
-			var err error
-			
-			if e.nodeRegistry.serpAPI != nil {
-				log.Printf("Performing real search for query %d: %s", index+1, searchQuery)
-				content, err = e.nodeRegistry.serpAPI.SearchAndSummarize(searchCtx, searchQuery)
-			} else {
-				log.Printf("Using simulated search for query %d: %s", index+1, searchQuery)
-				content, err = e.nodeRegistry.simulateSearchForBranching(searchCtx, searchQuery, queryId, state)
-			}
-			
-			// Send result to channel
-			select {
-			case resultsChan <- struct {
-				queryId  string
-				content  string
-				err      error
-			}{queryId, content, err}:
-			case <-searchCtx.Done():
-				// Timeout or cancellation
-				return
-			}
-			
-			// Send node complete for individual search
-			if err != nil {
-				updates <- GraphUpdate{
-					Type:      "error",
-					Node:      queryId,
-					State:     state.Clone(),
-					Error:     err,
-					Timestamp: time.Now(),
-				}
-			} else {
-				updates <- GraphUpdate{
-					Type:      "node_complete",
-					Node:      queryId,
-					State:     state.Clone(),
-					Timestamp: time.Now(),
-				}
-			}
-		}(i, query)
-	}
-	
-	// Wait for all searches to complete
-	go func() {
-		wg.Wait()
-		close(resultsChan)
-	}()
-	
-	// Collect results
-	successCount := 0
-	for result := range resultsChan {
-		if result.err != nil {
-			log.Printf("Search error for %s: %v", result.queryId, result.err)
-			continue
-		}
-		
-		// Store result in state with proper source naming
-		sourceName := fmt.Sprintf("Search_%s", result.queryId)
-		state.SetRawContent(sourceName, result.content)
-		successCount++
-	}
-	
-	// Dynamic branching counts as 1 step, not len(queries) steps
-	*stepsExecuted += 1
-	log.Printf("Dynamic branching completed: %d/%d searches successful", successCount, len(queries))
-	
-	if successCount == 0 {
-		return fmt.Errorf("all search branches failed")
-	}
-	
-	return nil
-}
\ No newline at end of file
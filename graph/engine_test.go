@@ -0,0 +1,227 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// nodesRun returns the nodes of a path with the parallel branches sorted,
+// since branches start in any order
+func nodesRun(path []string) []string {
+	var nodes, branches []string
+	for _, node := range path {
+		if strings.HasPrefix(node, "search_query_") {
+			branches = append(branches, node)
+			continue
+		}
+		if len(branches) > 0 {
+			sort.Strings(branches)
+			nodes, branches = append(nodes, branches...), nil
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+var researchPath = []string{
+	"classify_intent_and_topic", "generate_search_queries",
+	"search_query_1", "search_query_2", "search_query_3", "search_query_4", "search_query_5",
+	"merge_search_results", "draft_outline", "synthesize_and_report",
+}
+
+func TestExecuteWithFakeLLM(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		intent   string
+		path     []string
+		sources  int
+		reportOf string // prefix of the report
+	}{
+		{"research", "LLMの最新動向を調べて", "research", researchPath, 5, "# LLMの最新動向を調べてに関する調査レポート"},
+		{"question", "2+2は？", "qa", []string{"classify_intent_and_topic", "answer_directly"}, 0, "フェイクLLMによる回答です。"},
+		{"chat", "こんにちは", "chat", []string{"classify_intent_and_topic", "handle_chat"}, 0, "こんにちは！フェイクLLMです。"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := NewFakeModel()
+			engine, err := NewEngine("", "", WithLLM(fake, ProviderFake))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := engine.Execute(context.Background(), tt.input)
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			state := result.FinalState
+			if state.GetIntent() != tt.intent {
+				t.Errorf("intent = %q, want %q", state.GetIntent(), tt.intent)
+			}
+			if got := nodesRun(result.Path); !reflect.DeepEqual(got, tt.path) {
+				t.Errorf("path = %v, want %v", got, tt.path)
+			}
+			if got := len(state.GetRawContents()); got != tt.sources {
+				t.Errorf("sources = %d, want %d", got, tt.sources)
+			}
+			if !strings.HasPrefix(state.Report, tt.reportOf) {
+				t.Errorf("report = %q, want it to start with %q", state.Report, tt.reportOf)
+			}
+			if result.Usage.TotalTokens() == 0 {
+				t.Error("usage of the fake model was not metered")
+			}
+			for _, prompt := range fake.Prompts() {
+				if strings.Contains(prompt, "このプロンプトへの応答は用意されていません") {
+					t.Errorf("prompt without a scripted response: %q", prompt)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamExecuteWithFakeLLM(t *testing.T) {
+	fake := NewFakeModel()
+	fake.ChunkSize = 8
+	engine, err := NewEngine("", "", WithLLM(fake, ProviderFake))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates := make(chan GraphUpdate, 10)
+	var received []GraphUpdate
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for update := range updates {
+			received = append(received, update)
+		}
+	}()
+	result, err := engine.StreamExecute(context.Background(), "LLMの最新動向を調べて", updates)
+	<-done
+	if err != nil {
+		t.Fatalf("StreamExecute() error = %v", err)
+	}
+
+	if first, last := received[0], received[len(received)-1]; first.Type != "start" || last.Type != "complete" {
+		t.Fatalf("updates run from %s to %s, want start to complete", first.Type, last.Type)
+	}
+
+	var completed []string
+	var report strings.Builder
+	for _, update := range received {
+		if update.RunID != result.RunID {
+			t.Errorf("%s update of run %q, want %q", update.Type, update.RunID, result.RunID)
+		}
+		switch update.Type {
+		case "node_complete":
+			completed = append(completed, update.Node)
+		case "streaming_chunk":
+			if update.Node == "synthesize_and_report" {
+				report.WriteString(update.Chunk)
+			}
+		case "error":
+			t.Errorf("error update from %s: %v", update.Node, update.Error)
+		}
+	}
+	if got := nodesRun(completed); !reflect.DeepEqual(got, researchPath) {
+		t.Errorf("completed nodes = %v, want %v", got, researchPath)
+	}
+	if report.String() != result.FinalState.Report {
+		t.Errorf("streamed report = %q, want %q", report.String(), result.FinalState.Report)
+	}
+
+	// Applying the patches in version order to the start state yields the final state
+	patched, err := stateDocument(received[0].State)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.SliceStable(received, func(i, j int) bool { return received[i].Version < received[j].Version })
+	for _, update := range received {
+		patched = applyPatch(t, patched, update.Patch)
+	}
+	want, err := stateDocument(result.FinalState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(patched, want) {
+		t.Errorf("patched state = %v, want %v", patched, want)
+	}
+}
+
+func TestResumeWithFakeLLM(t *testing.T) {
+	fake := NewFakeModel()
+	engine, err := NewEngine("", "", WithLLM(fake, ProviderFake),
+		WithInterruptAfter("generate_search_queries"),
+		WithInterruptBefore("synthesize_and_report"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// The run pauses for the queries to be reviewed
+	_, err = engine.Execute(ctx, "LLMの最新動向を調べて")
+	var interrupted *InterruptedError
+	if !errors.As(err, &interrupted) {
+		t.Fatalf("Execute() error = %v, want an interrupt", err)
+	}
+	if want := (Interrupt{Node: "generate_search_queries", When: InterruptAfter}); interrupted.Interrupt != want {
+		t.Fatalf("interrupt = %+v, want %+v", interrupted.Interrupt, want)
+	}
+	runID := interrupted.RunID
+	pending, err := engine.Pending(ctx, runID)
+	if err != nil {
+		t.Fatalf("Pending() error = %v", err)
+	}
+
+	// Only the reviewed queries are searched
+	edited := pending.State.Clone()
+	edited.SearchQueries = edited.SearchQueries[:2]
+	_, err = engine.Resume(ctx, runID, WithEditedState(edited))
+	if !errors.As(err, &interrupted) {
+		t.Fatalf("Resume() error = %v, want an interrupt", err)
+	}
+	if want := (Interrupt{Node: "synthesize_and_report", When: InterruptBefore}); interrupted.Interrupt != want {
+		t.Fatalf("interrupt = %+v, want %+v", interrupted.Interrupt, want)
+	}
+	if got := len(interrupted.State.GetRawContents()); got != 2 {
+		t.Errorf("sources before the report = %d, want 2", got)
+	}
+	if got := len(interrupted.State.GetOutline()); got != 4 {
+		t.Fatalf("outline before the report has %d headings, want 4", got)
+	}
+
+	// The report follows the reviewed outline
+	edited = interrupted.State.Clone()
+	edited.Outline = []string{"導入の障壁", edited.Outline[2]}
+	result, err := engine.Resume(ctx, runID, WithEditedState(edited))
+	if err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	if result.RunID != runID {
+		t.Errorf("resumed run ID = %s, want %s", result.RunID, runID)
+	}
+	if !strings.HasPrefix(result.FinalState.Report, "# LLMの最新動向を調べてに関する調査レポート") {
+		t.Errorf("report = %q", result.FinalState.Report)
+	}
+	prompts := fake.Prompts()
+	if want := "- 導入の障壁\n- LLMの最新動向を調べての活用事例\n"; !strings.Contains(prompts[len(prompts)-1], want) {
+		t.Errorf("report prompt = %q, want the outline %q", prompts[len(prompts)-1], want)
+	}
+	if _, err := engine.Resume(ctx, runID); err == nil {
+		t.Error("resuming a completed run succeeded")
+	}
+}
+
+func TestParseOutline(t *testing.T) {
+	response := "## 1. 概要\n- 最新動向\n\n* 活用事例\n・課題\n2) 展望\n```"
+	want := []string{"概要", "最新動向", "活用事例", "課題", "展望"}
+	if got := parseOutline(response); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseOutline() = %q, want %q", got, want)
+	}
+}
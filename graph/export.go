@@ -0,0 +1,208 @@
+package graph
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// StartNode is the pseudo node a topology starts from
+const StartNode = "START"
+
+// Topology describes the structure of a graph, e.g. for the web front end to render
+type Topology struct {
+	Name  string         `json:"name"`
+	Entry string         `json:"entry"`
+	Nodes []TopologyNode `json:"nodes"`
+	Edges []TopologyEdge `json:"edges"`
+}
+
+// TopologyNode is a node, branch point or pseudo node of a topology
+type TopologyNode struct {
+	ID   string `json:"id"`   // node name, "branch:<name>" for branch points, START or END
+	Kind string `json:"kind"` // "node", "subgraph", "branch", "start" or "end"
+	// Branch points: the node run once per payload, its fan-out and the node joining the branches
+	BranchNode string `json:"branch_node,omitempty"`
+	FanOut     string `json:"fanout,omitempty"`
+	Join       string `json:"join,omitempty"`
+	MaxVisits  int    `json:"max_visits,omitempty"`
+	// Subgraph is the topology of a subgraph node
+	Subgraph *Topology `json:"subgraph,omitempty"`
+}
+
+// TopologyEdge is a possible transition between two topology nodes
+type TopologyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`           // "next" (fixed successor), "conditional" or "join" (branches finished)
+	Edge string `json:"edge,omitempty"` // conditional edge deciding the transition
+}
+
+// Topology returns the nodes, possible transitions and branch points of the engine's graph
+func (e *Engine) Topology() *Topology {
+	def := e.definition
+	t := &Topology{Name: def.Name, Entry: def.Entry}
+	t.Nodes = append(t.Nodes, TopologyNode{ID: StartNode, Kind: "start"})
+	t.Edges = append(t.Edges, TopologyEdge{From: StartNode, To: def.Entry, Kind: "next"})
+
+	routes := make(map[string][]string)
+	for _, edge := range def.Edges {
+		routes[edge.Name] = edge.Routes
+	}
+
+	ends := false
+	for _, node := range def.Nodes {
+		topologyNode := TopologyNode{ID: node.Name, Kind: "node", MaxVisits: node.MaxVisits}
+		if subgraph, ok := e.nodeRegistry.subgraphs[node.Name]; ok {
+			topologyNode.Kind = "subgraph"
+			topologyNode.Subgraph = subgraph.Topology()
+		}
+		t.Nodes = append(t.Nodes, topologyNode)
+
+		switch {
+		case node.Edge != "":
+			// An edge without routes may only finish the run
+			if len(routes[node.Edge]) == 0 {
+				t.Edges = append(t.Edges, TopologyEdge{From: node.Name, To: EndRoute, Kind: "conditional", Edge: node.Edge})
+				ends = true
+			}
+			for _, route := range routes[node.Edge] {
+				t.Edges = append(t.Edges, TopologyEdge{From: node.Name, To: route, Kind: "conditional", Edge: node.Edge})
+				ends = ends || route == EndRoute
+			}
+		case node.Next != "":
+			t.Edges = append(t.Edges, TopologyEdge{From: node.Name, To: node.Next, Kind: "next"})
+		default:
+			t.Edges = append(t.Edges, TopologyEdge{From: node.Name, To: EndRoute, Kind: "next"})
+			ends = true
+		}
+	}
+
+	for _, branch := range def.Branches {
+		id := BranchPrefix + branch.Name
+		t.Nodes = append(t.Nodes, TopologyNode{ID: id, Kind: "branch", BranchNode: branch.Node, FanOut: branch.FanOut, Join: branch.Join})
+		t.Edges = append(t.Edges, TopologyEdge{From: id, To: branch.Join, Kind: "join"})
+	}
+
+	if ends {
+		t.Nodes = append(t.Nodes, TopologyNode{ID: EndRoute, Kind: "end"})
+	}
+	return t
+}
+
+// Mermaid renders the engine's graph as a Mermaid flowchart
+func (e *Engine) Mermaid() string {
+	return e.Topology().Mermaid()
+}
+
+// DOT renders the engine's graph in the Graphviz DOT language
+func (e *Engine) DOT() string {
+	return e.Topology().DOT()
+}
+
+// Mermaid renders the topology as a Mermaid flowchart.
+// Subgraph nodes are linked to a block showing their own topology.
+func (t *Topology) Mermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	t.writeMermaid(&b, "", "    ")
+	return b.String()
+}
+
+func (t *Topology) writeMermaid(b *strings.Builder, prefix, indent string) {
+	for _, node := range t.Nodes {
+		id := mermaidID(prefix + node.ID)
+		switch node.Kind {
+		case "start", "end":
+			fmt.Fprintf(b, "%s%s([%q])\n", indent, id, node.ID)
+		case "branch":
+			fmt.Fprintf(b, "%s%s{{%q}}\n", indent, id, node.BranchNode+" ×N")
+		case "subgraph":
+			fmt.Fprintf(b, "%s%s[[%q]]\n", indent, id, node.ID)
+		default:
+			fmt.Fprintf(b, "%s%s[%q]\n", indent, id, node.ID)
+		}
+	}
+
+	for _, edge := range t.Edges {
+		from, to := mermaidID(prefix+edge.From), mermaidID(prefix+edge.To)
+		switch edge.Kind {
+		case "conditional":
+			fmt.Fprintf(b, "%s%s -.->|%s| %s\n", indent, from, edge.Edge, to)
+		case "join":
+			fmt.Fprintf(b, "%s%s ==> %s\n", indent, from, to)
+		default:
+			fmt.Fprintf(b, "%s%s --> %s\n", indent, from, to)
+		}
+	}
+
+	for _, node := range t.Nodes {
+		if node.Subgraph == nil {
+			continue
+		}
+		nested := prefix + node.ID + "/"
+		fmt.Fprintf(b, "%ssubgraph %s [%q]\n", indent, mermaidID(nested+"graph"), node.ID)
+		node.Subgraph.writeMermaid(b, nested, indent+"    ")
+		fmt.Fprintf(b, "%send\n", indent)
+		fmt.Fprintf(b, "%s%s -.- %s\n", indent, mermaidID(prefix+node.ID), mermaidID(nested+StartNode))
+	}
+}
+
+var mermaidUnsafe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// mermaidID turns a node ID into a Mermaid identifier
+func mermaidID(id string) string {
+	return mermaidUnsafe.ReplaceAllString(id, "_")
+}
+
+// DOT renders the topology in the Graphviz DOT language.
+// Subgraph nodes are linked to a cluster showing their own topology.
+func (t *Topology) DOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", t.Name)
+	b.WriteString("    rankdir=TB;\n")
+	b.WriteString("    node [shape=box, style=rounded];\n")
+	t.writeDOT(&b, "", "    ")
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (t *Topology) writeDOT(b *strings.Builder, prefix, indent string) {
+	for _, node := range t.Nodes {
+		id := prefix + node.ID
+		switch node.Kind {
+		case "start", "end":
+			fmt.Fprintf(b, "%s%q [label=%q, shape=oval];\n", indent, id, node.ID)
+		case "branch":
+			fmt.Fprintf(b, "%s%q [label=%q, shape=hexagon];\n", indent, id, node.BranchNode+" ×N")
+		case "subgraph":
+			fmt.Fprintf(b, "%s%q [label=%q, shape=box3d];\n", indent, id, node.ID)
+		default:
+			fmt.Fprintf(b, "%s%q [label=%q];\n", indent, id, node.ID)
+		}
+	}
+
+	for _, edge := range t.Edges {
+		from, to := prefix+edge.From, prefix+edge.To
+		switch edge.Kind {
+		case "conditional":
+			fmt.Fprintf(b, "%s%q -> %q [label=%q, style=dashed];\n", indent, from, to, edge.Edge)
+		case "join":
+			fmt.Fprintf(b, "%s%q -> %q [style=bold];\n", indent, from, to)
+		default:
+			fmt.Fprintf(b, "%s%q -> %q;\n", indent, from, to)
+		}
+	}
+
+	for _, node := range t.Nodes {
+		if node.Subgraph == nil {
+			continue
+		}
+		nested := prefix + node.ID + "/"
+		fmt.Fprintf(b, "%ssubgraph %q {\n", indent, "cluster_"+prefix+node.ID)
+		fmt.Fprintf(b, "%s    label=%q;\n", indent, node.ID)
+		node.Subgraph.writeDOT(b, nested, indent+"    ")
+		fmt.Fprintf(b, "%s}\n", indent)
+		fmt.Fprintf(b, "%s%q -> %q [style=dotted, arrowhead=none];\n", indent, prefix+node.ID, nested+StartNode)
+	}
+}
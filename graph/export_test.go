@@ -0,0 +1,239 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// exportGraph plans sections, writes them in parallel, reviews them until they
+// pass and publishes them through a subgraph
+func exportGraph(t *testing.T) *Engine {
+	t.Helper()
+	node := func(ctx context.Context, state *AppState) error { return nil }
+	route := func(to string) Edge {
+		return func(ctx context.Context, state *AppState) (string, error) { return to, nil }
+	}
+
+	publish, err := NewBuilder().
+		SetName("publish").
+		AddNode("format", node).
+		AddNode("upload", node).
+		AddEdge("format", "upload").
+		SetEntry("format").
+		Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := NewBuilder().
+		SetName("sections").
+		AddNode("plan", node).
+		AddConditionalEdge("plan", route(BranchPrefix+"write"), BranchPrefix+"write").
+		AddBranch("write", node, sectionFanOut, "review").
+		AddNode("review", node).
+		AddConditionalEdge("review", route("publish"), "plan", "publish", EndRoute).
+		AddSubgraph("publish", publish, StateMapping{}).
+		SetMaxVisits("plan", 3).
+		SetEntry("plan").
+		Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return engine
+}
+
+func TestExport(t *testing.T) {
+	topology := exportGraph(t).Topology()
+	data, err := json.MarshalIndent(topology, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format string
+		got    string
+		want   string
+	}{
+		{"mermaid", topology.Mermaid(), wantMermaid},
+		{"dot", topology.DOT(), wantDOT},
+		{"json", string(data), wantJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("%s export =\n%s\nwant\n%s", tt.format, tt.got, tt.want)
+			}
+		})
+	}
+}
+
+const wantMermaid = `flowchart TD
+    START(["START"])
+    plan["plan"]
+    review["review"]
+    publish[["publish"]]
+    branch_write{{"write ×N"}}
+    END(["END"])
+    START --> plan
+    plan -.->|after_plan| branch_write
+    review -.->|after_review| plan
+    review -.->|after_review| publish
+    review -.->|after_review| END
+    publish --> END
+    branch_write ==> review
+    subgraph publish_graph ["publish"]
+        publish_START(["START"])
+        publish_format["format"]
+        publish_upload["upload"]
+        publish_END(["END"])
+        publish_START --> publish_format
+        publish_format --> publish_upload
+        publish_upload --> publish_END
+    end
+    publish -.- publish_START
+`
+
+const wantDOT = `digraph "sections" {
+    rankdir=TB;
+    node [shape=box, style=rounded];
+    "START" [label="START", shape=oval];
+    "plan" [label="plan"];
+    "review" [label="review"];
+    "publish" [label="publish", shape=box3d];
+    "branch:write" [label="write ×N", shape=hexagon];
+    "END" [label="END", shape=oval];
+    "START" -> "plan";
+    "plan" -> "branch:write" [label="after_plan", style=dashed];
+    "review" -> "plan" [label="after_review", style=dashed];
+    "review" -> "publish" [label="after_review", style=dashed];
+    "review" -> "END" [label="after_review", style=dashed];
+    "publish" -> "END";
+    "branch:write" -> "review" [style=bold];
+    subgraph "cluster_publish" {
+        label="publish";
+        "publish/START" [label="START", shape=oval];
+        "publish/format" [label="format"];
+        "publish/upload" [label="upload"];
+        "publish/END" [label="END", shape=oval];
+        "publish/START" -> "publish/format";
+        "publish/format" -> "publish/upload";
+        "publish/upload" -> "publish/END";
+    }
+    "publish" -> "publish/START" [style=dotted, arrowhead=none];
+}
+`
+
+const wantJSON = `{
+  "name": "sections",
+  "entry": "plan",
+  "nodes": [
+    {
+      "id": "START",
+      "kind": "start"
+    },
+    {
+      "id": "plan",
+      "kind": "node",
+      "max_visits": 3
+    },
+    {
+      "id": "review",
+      "kind": "node"
+    },
+    {
+      "id": "publish",
+      "kind": "subgraph",
+      "subgraph": {
+        "name": "publish",
+        "entry": "format",
+        "nodes": [
+          {
+            "id": "START",
+            "kind": "start"
+          },
+          {
+            "id": "format",
+            "kind": "node"
+          },
+          {
+            "id": "upload",
+            "kind": "node"
+          },
+          {
+            "id": "END",
+            "kind": "end"
+          }
+        ],
+        "edges": [
+          {
+            "from": "START",
+            "to": "format",
+            "kind": "next"
+          },
+          {
+            "from": "format",
+            "to": "upload",
+            "kind": "next"
+          },
+          {
+            "from": "upload",
+            "to": "END",
+            "kind": "next"
+          }
+        ]
+      }
+    },
+    {
+      "id": "branch:write",
+      "kind": "branch",
+      "branch_node": "write",
+      "fanout": "write",
+      "join": "review"
+    },
+    {
+      "id": "END",
+      "kind": "end"
+    }
+  ],
+  "edges": [
+    {
+      "from": "START",
+      "to": "plan",
+      "kind": "next"
+    },
+    {
+      "from": "plan",
+      "to": "branch:write",
+      "kind": "conditional",
+      "edge": "after_plan"
+    },
+    {
+      "from": "review",
+      "to": "plan",
+      "kind": "conditional",
+      "edge": "after_review"
+    },
+    {
+      "from": "review",
+      "to": "publish",
+      "kind": "conditional",
+      "edge": "after_review"
+    },
+    {
+      "from": "review",
+      "to": "END",
+      "kind": "conditional",
+      "edge": "after_review"
+    },
+    {
+      "from": "publish",
+      "to": "END",
+      "kind": "next"
+    },
+    {
+      "from": "branch:write",
+      "to": "review",
+      "kind": "join"
+    }
+  ]
+}`
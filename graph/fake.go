@@ -0,0 +1,187 @@
+package graph
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// FakeRule answers prompts matching Pattern with Response. Response may refer
+// to submatches of the pattern, e.g. ${1}; Err makes matching calls fail instead.
+type FakeRule struct {
+	Pattern  *regexp.Regexp
+	Response string
+	Err      error
+}
+
+// FakeResponse is a rule answering prompts matching pattern with response
+func FakeResponse(pattern, response string) FakeRule {
+	return FakeRule{Pattern: regexp.MustCompile(pattern), Response: response}
+}
+
+// FakeModel is a deterministic llms.Model answering from a script, for running
+// graphs in tests and demos without an API key
+type FakeModel struct {
+	Rules     []FakeRule    // the first rule matching the prompt answers it
+	Fallback  string        // response to prompts no rule matches
+	ChunkSize int           // runes per streamed chunk (0 = 16)
+	Latency   time.Duration // delay before the response and between streamed chunks
+
+	mu      sync.Mutex
+	prompts []string
+}
+
+// NewFakeModel creates a fake model answering with rules, or with DemoScript when none are given
+func NewFakeModel(rules ...FakeRule) *FakeModel {
+	if len(rules) == 0 {
+		rules = DemoScript()
+	}
+	return &FakeModel{
+		Rules:    rules,
+		Fallback: "（フェイクLLM）このプロンプトへの応答は用意されていません。",
+	}
+}
+
+// WithFakeLLM makes the nodes call a FakeModel with rules (DemoScript when none
+// are given), so runs need neither an API key nor the network
+func WithFakeLLM(rules ...FakeRule) EngineOption {
+	return WithLLM(NewFakeModel(rules...), ProviderFake)
+}
+
+// DemoScript answers the prompts of the built-in research graph
+func DemoScript() []FakeRule {
+	return []FakeRule{
+		FakeResponse(`(?s)determine the intent.*User input: "([^"\n]*)"`,
+			`{"intent": "qa", "topic": ""}`),
+		FakeResponse(`多様な検索クエリを4-5個生成してください: "([^"]*)"`,
+			`["${1} とは 概要", "${1} 最新動向 ニュース", "${1} 活用事例 応用", "${1} 技術 詳細 実装", "${1} 課題 制限"]`),
+		FakeResponse(`要約を日本語で2-3段落で提供してください: "([^"]*)"`,
+			"「${1}」の検索結果（フェイクLLMによるシミュレーション）です。\n\n"+
+				"${1}は近年注目を集めており、基本的な概念の整理と実用化が並行して進んでいる。\n\n"+
+				"主要な事例では導入効果が報告されている一方、運用面の課題も指摘されている。"),
+		FakeResponse(`「([^」]*)」に関する調査レポートのアウトラインを作成してください`,
+			"${1}の概要\n${1}の最新動向\n${1}の活用事例\n${1}の課題と展望"),
+		FakeResponse(`同じ書式で「([^」]*)」についてのレポートを作成してください`,
+			"# ${1}に関する調査レポート\n\n"+
+				"## 要約\n\n${1}について、フェイクLLMが検索結果を元に作成したデモ用のレポートです。\n\n"+
+				"## 主要な発見事項\n\n"+
+				"1. **概要**: ${1}の基本的な概念が整理されている\n"+
+				"2. **最新動向**: 実用化に向けた取り組みが進んでいる\n"+
+				"3. **課題**: 運用面での制限が指摘されている\n\n"+
+				"## 詳細分析\n\n各検索結果から、${1}は今後も発展が見込まれる分野であることが分かる。\n\n"+
+				"## 関連技術・概念\n\n- **${1}**: 本レポートの調査対象\n\n"+
+				"## 推奨事項・次のステップ\n\n- **追加調査**: 実際のLLMで詳細なレポートを作成する\n"),
+		FakeResponse(`(?s)質問に日本語で簡潔に回答してください: (.*)`,
+			"フェイクLLMによる回答です。「${1}」への実際の回答にはLLMプロバイダーを設定してください。"),
+		FakeResponse(`(?s)親しみやすく helpful に応答してください: (.*)`,
+			"こんにちは！フェイクLLMです。「${1}」というメッセージを受け取りました。"),
+	}
+}
+
+// Prompts returns the prompts the model was called with, in order
+func (m *FakeModel) Prompts() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.prompts...)
+}
+
+func (m *FakeModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	opts := llms.CallOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	var prompt strings.Builder
+	for _, message := range messages {
+		for _, part := range message.Parts {
+			if text, ok := part.(llms.TextContent); ok {
+				prompt.WriteString(text.Text)
+			}
+		}
+	}
+	m.mu.Lock()
+	m.prompts = append(m.prompts, prompt.String())
+	m.mu.Unlock()
+
+	response, err := m.respond(prompt.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := sleep(ctx, m.Latency); err != nil {
+		return nil, err
+	}
+
+	if opts.StreamingFunc != nil {
+		chunkSize := m.ChunkSize
+		if chunkSize <= 0 {
+			chunkSize = 16
+		}
+		runes := []rune(response)
+		for start := 0; start < len(runes); start += chunkSize {
+			if start > 0 {
+				if err := sleep(ctx, m.Latency); err != nil {
+					return nil, err
+				}
+			}
+			end := min(start+chunkSize, len(runes))
+			if err := opts.StreamingFunc(ctx, []byte(string(runes[start:end]))); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{
+		Content:    response,
+		StopReason: "stop",
+		GenerationInfo: map[string]any{
+			"PromptTokens":     fakeTokens(prompt.String()),
+			"CompletionTokens": fakeTokens(response),
+		},
+	}}}, nil
+}
+
+func (m *FakeModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+// respond answers prompt with the first matching rule
+func (m *FakeModel) respond(prompt string) (string, error) {
+	for _, rule := range m.Rules {
+		if rule.Pattern == nil {
+			return rule.Response, rule.Err
+		}
+		match := rule.Pattern.FindStringSubmatchIndex(prompt)
+		if match == nil {
+			continue
+		}
+		if rule.Err != nil {
+			return "", rule.Err
+		}
+		return string(rule.Pattern.ExpandString(nil, rule.Response, prompt, match)), nil
+	}
+	return m.Fallback, nil
+}
+
+// fakeTokens approximates the tokens of text at four bytes per token
+func fakeTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// sleep waits for d unless ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,93 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// History returns the checkpoints of every step of a run, the points it can be forked from
+func (e *Engine) History(ctx context.Context, runID string) ([]*Checkpoint, error) {
+	history, ok := e.checkpoints.(CheckpointHistory)
+	if !ok {
+		return nil, fmt.Errorf("forking requires a checkpoint store that keeps step history")
+	}
+
+	checkpoints, err := history.History(ctx, runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load history of run %s: %w", runID, err)
+	}
+	return checkpoints, nil
+}
+
+// Fork starts a new run from the state a past run had after the given step.
+// WithEditedState replaces that state, e.g. to change the topic or drop a source;
+// the steps before it are not executed again.
+func (e *Engine) Fork(ctx context.Context, runID string, step int, opts ...ResumeOption) (*ExecutionResult, error) {
+	checkpoint, err := e.loadForkable(ctx, runID, step, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r := e.newRunner(checkpoint.State, nil)
+	r.restore(checkpoint)
+	r.store(ctx, r.last)
+	return e.run(ctx, r, checkpoint.NextNode)
+}
+
+// StreamFork is Fork with streaming updates; the channel is closed when the run ends
+func (e *Engine) StreamFork(ctx context.Context, runID string, step int, updates chan<- GraphUpdate, opts ...ResumeOption) (*ExecutionResult, error) {
+	defer close(updates)
+
+	checkpoint, err := e.loadForkable(ctx, runID, step, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r := e.streamRunner(ctx, checkpoint.State, updates)
+	r.restore(checkpoint)
+	r.store(ctx, r.last)
+	return e.run(ctx, r, checkpoint.NextNode)
+}
+
+// loadForkable loads the checkpoint after a step of a run as the first checkpoint of a new run
+func (e *Engine) loadForkable(ctx context.Context, runID string, step int, opts []ResumeOption) (*Checkpoint, error) {
+	history, ok := e.checkpoints.(CheckpointHistory)
+	if !ok {
+		return nil, fmt.Errorf("forking requires a checkpoint store that keeps step history")
+	}
+
+	checkpoint, err := history.LoadStep(ctx, runID, step)
+	if errors.Is(err, ErrCheckpointNotFound) {
+		return nil, fmt.Errorf("run %s has no checkpoint for step %d: %w", runID, step, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load run %s: %w", runID, err)
+	}
+	if checkpoint.NextNode == "" {
+		return nil, fmt.Errorf("run %s has nothing left to run after step %d", runID, step)
+	}
+
+	var options resumeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.state != nil {
+		checkpoint.State = options.state.Clone()
+	}
+
+	forkID := uuid.NewString()
+	slog.InfoContext(ctx, "🍴 Forking run", "run_id", runID, "step", step, "next_node", checkpoint.NextNode, "fork_id", forkID)
+	checkpoint.State.SetError(nil)
+	checkpoint.RunID = forkID
+	checkpoint.ParentRunID = runID
+	checkpoint.ParentStep = step
+	checkpoint.Status = CheckpointRunning
+	checkpoint.Error = ""
+	checkpoint.UpdatedAt = time.Now()
+	return checkpoint, nil
+}
@@ -0,0 +1,127 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// completedResearch runs the research graph to completion on store and returns its history
+func completedResearch(t *testing.T, store CheckpointStore) (string, []*Checkpoint) {
+	t.Helper()
+	ctx := context.Background()
+
+	engine, err := NewEngine("", "", WithFakeLLM(), WithCheckpointStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := engine.Execute(ctx, "LLMの最新動向を調べて")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	history, err := engine.History(ctx, result.RunID)
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	return result.RunID, history
+}
+
+func TestFork(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryCheckpointStore()
+	runID, history := completedResearch(t, store)
+
+	// Fork after the search results were merged, with another topic and a source dropped
+	var merged *Checkpoint
+	for _, checkpoint := range history {
+		if checkpoint.Node == "merge_search_results" {
+			merged = checkpoint
+		}
+	}
+	if merged == nil {
+		t.Fatalf("history has no step after merge_search_results: %v", history)
+	}
+	edited := merged.State.Clone()
+	edited.Topic = "量子コンピュータ"
+	delete(edited.RawContents, "Search_search_query_1")
+
+	fake := NewFakeModel()
+	engine, err := NewEngine("", "", WithLLM(fake, ProviderFake), WithCheckpointStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := engine.Fork(ctx, runID, merged.Step, WithEditedState(edited))
+	if err != nil {
+		t.Fatalf("Fork() error = %v", err)
+	}
+
+	// Only the outline and the report are written; nothing before the step runs again
+	prompts := fake.Prompts()
+	if len(prompts) != 2 {
+		t.Fatalf("fork prompted the model %d times, want twice: %q", len(prompts), prompts)
+	}
+	if !strings.Contains(prompts[0], "「量子コンピュータ」に関する調査レポートのアウトライン") {
+		t.Errorf("outline prompt = %q, want the edited topic", prompts[0])
+	}
+	if strings.Contains(prompts[1], "=== Search_search_query_1 ===") || !strings.Contains(prompts[1], "=== Search_search_query_2 ===") {
+		t.Errorf("report prompt = %q, want the sources without the dropped one", prompts[1])
+	}
+	if got, want := result.Path[len(merged.Path):], []string{"draft_outline", "synthesize_and_report"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("nodes run by the fork = %v, want %v", got, want)
+	}
+	if result.RunID == runID {
+		t.Error("fork reused the run ID of its parent")
+	}
+
+	// The fork records where it came from and leaves its parent unchanged
+	forked, err := engine.History(ctx, result.RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := forked[0]; first.ParentRunID != runID || first.ParentStep != merged.Step || first.Step != merged.Step {
+		t.Errorf("first fork checkpoint = %+v, want step %d of run %s", first, merged.Step, runID)
+	}
+	if last := forked[len(forked)-1]; last.Status != CheckpointCompleted {
+		t.Errorf("fork ended %s, want completed", last.Status)
+	}
+	parent, err := engine.History(ctx, runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parent) != len(history) || parent[len(parent)-1].State.Topic != history[len(history)-1].State.Topic {
+		t.Errorf("parent run has %d steps after the fork, want its %d unchanged steps", len(parent), len(history))
+	}
+}
+
+func TestForkErrors(t *testing.T) {
+	store := NewMemoryCheckpointStore()
+	runID, history := completedResearch(t, store)
+	engine, err := NewEngine("", "", WithFakeLLM(), WithCheckpointStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		runID string
+		step  int
+		want  string
+	}{
+		{"missing step", runID, len(history) + 5, "has no checkpoint for step"},
+		{"missing run", "missing", 1, "has no checkpoint for step"},
+		{"completed step", runID, history[len(history)-1].Step, "has nothing left to run"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := engine.Fork(context.Background(), tt.runID, tt.step)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Fork() error = %v, want %q", err, tt.want)
+			}
+			if strings.Contains(tt.want, "no checkpoint") && !errors.Is(err, ErrCheckpointNotFound) {
+				t.Errorf("Fork() error = %v, want ErrCheckpointNotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package graph
+
+import (
+	"context"
+	"time"
+)
+
+// NodeEvent describes a node execution reported to hooks
+type NodeEvent struct {
+	RunID  string
+	Node   string    // executing node, or the branch ID for branches
+	Path   []string  // nested node path, e.g. [search_and_merge, search_query_1]
+	Branch *Branch   // set when the node runs as a branch
+	State  *AppState // state the node runs against; hooks must not modify it
+	// Duration of the execution, set for AfterNode
+	Duration time.Duration
+}
+
+// EdgeEvent describes a transition taken by a run.
+// To is a "branch:<name>" signal when an edge routes to a branch point, and empty when the run ends.
+type EdgeEvent struct {
+	RunID string
+	From  string
+	To    string
+}
+
+// ChunkEvent describes a streaming chunk produced by a node
+type ChunkEvent struct {
+	RunID string
+	Node  string
+	Chunk string
+}
+
+// Hooks observe the execution of a run. Branches run in parallel, so hooks
+// must be safe for concurrent use. Embed NopHooks to implement only some of them.
+type Hooks interface {
+	// BeforeNode is called before every attempt of a node
+	BeforeNode(ctx context.Context, event NodeEvent)
+	// AfterNode is called after every attempt of a node with its error, if any
+	AfterNode(ctx context.Context, event NodeEvent, err error)
+	// OnEdge is called when the run moves from one node to the next
+	OnEdge(ctx context.Context, event EdgeEvent)
+	// OnError is called when the run fails, times out or is cancelled at a node
+	OnError(ctx context.Context, event NodeEvent, err error)
+	// OnChunk is called for every streaming chunk, in Execute as well as StreamExecute
+	OnChunk(ctx context.Context, event ChunkEvent)
+}
+
+// NopHooks implements Hooks with methods that do nothing
+type NopHooks struct{}
+
+func (NopHooks) BeforeNode(ctx context.Context, event NodeEvent)           {}
+func (NopHooks) AfterNode(ctx context.Context, event NodeEvent, err error) {}
+func (NopHooks) OnEdge(ctx context.Context, event EdgeEvent)               {}
+func (NopHooks) OnError(ctx context.Context, event NodeEvent, err error)   {}
+func (NopHooks) OnChunk(ctx context.Context, event ChunkEvent)             {}
+
+// Middleware wraps the execution of a node. node is the executing node,
+// or the branch ID for branches (see BranchFromContext for the payload).
+type Middleware func(node string, next Node) Node
+
+// ChainMiddleware composes middleware; the first one is the outermost
+func ChainMiddleware(middleware ...Middleware) Middleware {
+	return func(node string, next Node) Node {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			next = middleware[i](node, next)
+		}
+		return next
+	}
+}
+
+// WithHooks registers hooks called around every node, edge and chunk of a run.
+// Hooks observe the engine they are registered on; a subgraph reports to the hooks of its own engine.
+func WithHooks(hooks ...Hooks) EngineOption {
+	return func(e *Engine) {
+		e.hooks = append(e.hooks, hooks...)
+	}
+}
+
+// WithMiddleware wraps every node execution; the first middleware is the outermost.
+// Middleware runs inside the node timeout and once per retry attempt.
+func WithMiddleware(middleware ...Middleware) EngineOption {
+	return func(e *Engine) {
+		e.middleware = append(e.middleware, middleware...)
+	}
+}
+
+// hookChain calls every registered hook in order
+type hookChain []Hooks
+
+func (c hookChain) BeforeNode(ctx context.Context, event NodeEvent) {
+	for _, h := range c {
+		h.BeforeNode(ctx, event)
+	}
+}
+
+func (c hookChain) AfterNode(ctx context.Context, event NodeEvent, err error) {
+	for _, h := range c {
+		h.AfterNode(ctx, event, err)
+	}
+}
+
+func (c hookChain) OnEdge(ctx context.Context, event EdgeEvent) {
+	for _, h := range c {
+		h.OnEdge(ctx, event)
+	}
+}
+
+func (c hookChain) OnError(ctx context.Context, event NodeEvent, err error) {
+	for _, h := range c {
+		h.OnError(ctx, event, err)
+	}
+}
+
+func (c hookChain) OnChunk(ctx context.Context, event ChunkEvent) {
+	for _, h := range c {
+		h.OnChunk(ctx, event)
+	}
+}
+
+// nodeEvent describes the execution of node against state
+func (r *runner) nodeEvent(ctx context.Context, node string, state *AppState) NodeEvent {
+	event := NodeEvent{
+		RunID: r.runID,
+		Node:  node,
+		Path:  r.nodePath(node),
+		State: state,
+	}
+	if branch, ok := BranchFromContext(ctx); ok && branch.ID == node {
+		event.Branch = &branch
+	}
+	return event
+}
+
+// edge reports a transition to the trace and the hooks
+func (r *runner) edge(ctx context.Context, from, to string) {
+	traceEdge(ctx, from, to)
+	if len(r.engine.hooks) == 0 {
+		return
+	}
+	r.engine.hooks.OnEdge(ctx, EdgeEvent{RunID: r.runID, From: from, To: to})
+}
+
+// observeChunks reports the streaming chunks of the run's state (and its branches) to the hooks
+func (r *runner) observeChunks(ctx context.Context) {
+	if len(r.engine.hooks) == 0 {
+		return
+	}
+	r.state.observeStreaming(func(node string, chunk string) {
+		r.engine.hooks.OnChunk(ctx, ChunkEvent{RunID: r.runID, Node: node, Chunk: chunk})
+	})
+}
@@ -0,0 +1,118 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// eventLog records what hooks and middleware see, in order
+type eventLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *eventLog) add(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, fmt.Sprintf(format, args...))
+}
+
+// loggingHooks logs every hook call under its name
+type loggingHooks struct {
+	name string
+	log  *eventLog
+}
+
+func (h loggingHooks) BeforeNode(ctx context.Context, event NodeEvent) {
+	h.log.add("%s before %s", h.name, event.Node)
+}
+
+func (h loggingHooks) AfterNode(ctx context.Context, event NodeEvent, err error) {
+	h.log.add("%s after %s: %v", h.name, event.Node, err)
+}
+
+func (h loggingHooks) OnEdge(ctx context.Context, event EdgeEvent) {
+	h.log.add("%s edge %s → %s", h.name, event.From, event.To)
+}
+
+func (h loggingHooks) OnError(ctx context.Context, event NodeEvent, err error) {
+	h.log.add("%s error %s: %v", h.name, event.Node, err)
+}
+
+func (h loggingHooks) OnChunk(ctx context.Context, event ChunkEvent) {
+	h.log.add("%s chunk %s: %s", h.name, event.Node, event.Chunk)
+}
+
+// loggingMiddleware logs entering and leaving every node under its name
+func loggingMiddleware(name string, log *eventLog) Middleware {
+	return func(node string, next Node) Node {
+		return func(ctx context.Context, state *AppState) error {
+			log.add("%s enters %s", name, node)
+			err := next(ctx, state)
+			log.add("%s leaves %s", name, node)
+			return err
+		}
+	}
+}
+
+func TestHookAndMiddlewareOrder(t *testing.T) {
+	log := &eventLog{}
+	failed := errors.New("failed")
+	attempts := 0
+	engine, err := NewBuilder().
+		AddNode("a", func(ctx context.Context, state *AppState) error {
+			log.add("a runs")
+			state.OnStreamingChunk("a", "chunk")
+			return nil
+		}).
+		AddNode("b", func(ctx context.Context, state *AppState) error {
+			attempts++
+			log.add("b runs")
+			if attempts == 1 {
+				return failed
+			}
+			return nil
+		}).
+		AddNode("c", func(ctx context.Context, state *AppState) error { return failed }).
+		AddEdge("a", "b").
+		AddEdge("b", "c").
+		SetEntry("a").
+		Compile(
+			WithHooks(loggingHooks{"h1", log}, loggingHooks{"h2", log}),
+			WithMiddleware(loggingMiddleware("outer", log), loggingMiddleware("inner", log)),
+			WithRetryPolicy("b", RetryPolicy{MaxAttempts: 2, Retryable: func(error) bool { return true }}),
+		)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := engine.Execute(context.Background(), "input"); !errors.Is(err, failed) {
+		t.Fatalf("Execute() error = %v, want c to fail", err)
+	}
+
+	// attempt lists the calls around one attempt of node: hooks in registration
+	// order, the first middleware outermost
+	attempt := func(node string, err error, runs ...string) []string {
+		events := []string{"h1 before " + node, "h2 before " + node, "outer enters " + node, "inner enters " + node}
+		events = append(events, runs...)
+		return append(events, "inner leaves "+node, "outer leaves "+node,
+			fmt.Sprintf("h1 after %s: %v", node, err), fmt.Sprintf("h2 after %s: %v", node, err))
+	}
+	var want []string
+	want = append(want, attempt("a", nil, "a runs", "h1 chunk a: chunk", "h2 chunk a: chunk")...)
+	want = append(want, "h1 edge a → b", "h2 edge a → b")
+	// Hooks see every retry attempt
+	want = append(want, attempt("b", failed, "b runs")...)
+	want = append(want, attempt("b", nil, "b runs")...)
+	want = append(want, "h1 edge b → c", "h2 edge b → c")
+	want = append(want, attempt("c", failed)...)
+	want = append(want, "h1 error c: node c failed: failed", "h2 error c: node c failed: failed")
+
+	if !reflect.DeepEqual(log.events, want) {
+		t.Errorf("events =\n%q\nwant\n%q", log.events, want)
+	}
+}
@@ -0,0 +1,95 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+)
+
+// Interrupt positions
+const (
+	InterruptBefore = "before"
+	InterruptAfter  = "after"
+)
+
+// Interrupt describes where a run was paused for human review
+type Interrupt struct {
+	Node string `json:"node"`
+	When string `json:"when"` // InterruptBefore or InterruptAfter
+}
+
+// InterruptedError is returned when a run pauses at an interrupt.
+// The run continues with Engine.Resume, optionally with an edited state.
+type InterruptedError struct {
+	RunID     string
+	Interrupt Interrupt
+	State     *AppState // pending state awaiting review
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("run %s interrupted %s %s", e.RunID, e.Interrupt.When, e.Interrupt.Node)
+}
+
+// WithInterruptBefore pauses every run before the given nodes execute
+func WithInterruptBefore(nodes ...string) EngineOption {
+	return func(e *Engine) {
+		for _, node := range nodes {
+			e.interruptBefore[node] = true
+		}
+	}
+}
+
+// WithInterruptAfter pauses every run after the given nodes complete, before their edge is taken
+func WithInterruptAfter(nodes ...string) EngineOption {
+	return func(e *Engine) {
+		for _, node := range nodes {
+			e.interruptAfter[node] = true
+		}
+	}
+}
+
+// ResumeOption configures how a checkpointed run continues
+type ResumeOption func(*resumeOptions)
+
+type resumeOptions struct {
+	state *AppState
+}
+
+// WithEditedState continues the run with a reviewed copy of its pending state
+func WithEditedState(state *AppState) ResumeOption {
+	return func(o *resumeOptions) {
+		o.state = state
+	}
+}
+
+// Pending returns the checkpoint of a run waiting at an interrupt
+func (e *Engine) Pending(ctx context.Context, runID string) (*Checkpoint, error) {
+	if e.checkpoints == nil {
+		return nil, fmt.Errorf("interrupts require a checkpoint store")
+	}
+
+	checkpoint, err := e.checkpoints.Load(ctx, runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load run %s: %w", runID, err)
+	}
+	if checkpoint.Status != CheckpointInterrupted {
+		return nil, fmt.Errorf("run %s is not waiting at an interrupt (status: %s)", runID, checkpoint.Status)
+	}
+	return checkpoint, nil
+}
+
+// checkInterrupts verifies that interrupt nodes are part of the graph
+func (e *Engine) checkInterrupts() error {
+	declared := make(map[string]bool)
+	for _, node := range e.definition.Nodes {
+		declared[node.Name] = true
+	}
+
+	for _, nodes := range []map[string]bool{e.interruptBefore, e.interruptAfter} {
+		for node := range nodes {
+			if !declared[node] {
+				return fmt.Errorf("interrupt node %s is not declared in graph %q", node, e.definition.Name)
+			}
+		}
+	}
+	return nil
+}
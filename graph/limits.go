@@ -0,0 +1,158 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrTimeout matches every *TimeoutError with errors.Is
+var ErrTimeout = errors.New("timeout")
+
+// ErrMaxSteps is returned when a run executes more nodes than the step limit allows
+var ErrMaxSteps = errors.New("execution exceeded maximum steps")
+
+// Timeout scopes
+const (
+	TimeoutRun  = "run"
+	TimeoutNode = "node"
+)
+
+// TimeoutError is returned when a run deadline or a node timeout expires
+type TimeoutError struct {
+	Scope   string // TimeoutRun or TimeoutNode
+	Node    string // node running when the deadline expired
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Scope == TimeoutNode {
+		return fmt.Sprintf("node %s timed out after %v", e.Node, e.Timeout)
+	}
+	return fmt.Sprintf("run deadline of %v exceeded at node %s", e.Timeout, e.Node)
+}
+
+// Is makes timeouts match ErrTimeout and context.DeadlineExceeded
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout || target == context.DeadlineExceeded
+}
+
+// WithMaxSteps limits the number of nodes a run may execute
+func WithMaxSteps(steps int) EngineOption {
+	return func(e *Engine) {
+		e.maxSteps = steps
+	}
+}
+
+// WithRunTimeout sets a deadline for every run (and every resumed run)
+func WithRunTimeout(timeout time.Duration) EngineOption {
+	return func(e *Engine) {
+		e.runTimeout = timeout
+	}
+}
+
+// WithNodeTimeout limits a single execution of a node (or the node of a branch point).
+// It overrides any timeout declared in the graph definition. An expired node timeout
+// is a RetryOnTimeout error, so a retry policy runs the node again with a fresh timeout.
+func WithNodeTimeout(node string, timeout time.Duration) EngineOption {
+	return func(e *Engine) {
+		e.nodeTimeouts[node] = timeout
+	}
+}
+
+// WithDefaultNodeTimeout limits every node without a timeout of its own
+func WithDefaultNodeTimeout(timeout time.Duration) EngineOption {
+	return func(e *Engine) {
+		e.defaultNodeTimeout = timeout
+	}
+}
+
+// nodeTimeout returns the timeout applying to a node (0 = none)
+func (e *Engine) nodeTimeout(node string) time.Duration {
+	if timeout, exists := e.nodeTimeouts[node]; exists {
+		return timeout
+	}
+	return e.defaultNodeTimeout
+}
+
+// declaredTimeouts adds the timeouts of the definition that no option overrides
+func (e *Engine) declaredTimeouts() error {
+	declare := func(node, timeout string) error {
+		if _, overridden := e.nodeTimeouts[node]; timeout == "" || overridden {
+			return nil
+		}
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout for %s: %w", node, err)
+		}
+		e.nodeTimeouts[node] = d
+		return nil
+	}
+
+	for _, node := range e.definition.Nodes {
+		if err := declare(node.Name, node.Timeout); err != nil {
+			return err
+		}
+	}
+	for _, branch := range e.definition.Branches {
+		if err := declare(branch.Node, branch.Timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withRunDeadline applies the engine's run deadline to ctx
+func (r *runner) withRunDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.engine.runTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	r.deadline = time.Now().Add(r.engine.runTimeout)
+	return context.WithDeadline(ctx, r.deadline)
+}
+
+// runNode executes a node under the timeout configured for key (the node name,
+// or the branch point's node for branches), translating expired deadlines into *TimeoutError
+func (r *runner) runNode(ctx context.Context, name, key string, node Node, state *AppState) error {
+	nodeCtx := ctx
+	timeout := r.engine.nodeTimeout(key)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		nodeCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Middleware and hooks see every attempt under the node timeout
+	if len(r.engine.middleware) > 0 {
+		node = ChainMiddleware(r.engine.middleware...)(name, node)
+	}
+	nodeCtx = withNodeKey(nodeCtx, key)
+	nodeCtx, span := startNodeSpan(nodeCtx, name, key)
+	event := r.nodeEvent(ctx, name, state)
+	traceNodeStart(nodeCtx, event)
+	r.engine.hooks.BeforeNode(nodeCtx, event)
+	start := time.Now()
+
+	err := node(nodeCtx, state)
+	switch {
+	case err == nil:
+	case r.deadlineExceeded(ctx):
+		err = &TimeoutError{Scope: TimeoutRun, Node: name, Timeout: r.engine.runTimeout}
+	case timeout > 0 && errors.Is(nodeCtx.Err(), context.DeadlineExceeded):
+		err = &TimeoutError{Scope: TimeoutNode, Node: name, Timeout: timeout}
+	}
+
+	event.Duration = time.Since(start)
+	traceNodeEnd(nodeCtx, event, err)
+	r.engine.hooks.AfterNode(nodeCtx, event, err)
+	metrics.nodeFinished(nodeCtx, key, event.Duration, err)
+	endSpan(span, err)
+	return err
+}
+
+// deadlineExceeded reports whether ctx expired because of the run deadline
+// rather than a deadline set by the caller
+func (r *runner) deadlineExceeded(ctx context.Context) bool {
+	return !r.deadline.IsZero() && errors.Is(ctx.Err(), context.DeadlineExceeded) && !time.Now().Before(r.deadline)
+}
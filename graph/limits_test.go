@@ -0,0 +1,209 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitNode waits for d or until its context is done
+func waitNode(d time.Duration) Node {
+	return func(ctx context.Context, state *AppState) error {
+		return sleep(ctx, d)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	engine, err := NewBuilder().
+		AddNode("fast", waitNode(0)).
+		AddNode("slow", waitNode(time.Second)).
+		AddEdge("fast", "slow").
+		SetEntry("fast").
+		Compile(WithRunTimeout(20 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	result, err := engine.Execute(context.Background(), "input")
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("run took %v after its deadline of 20ms", elapsed)
+	}
+
+	var timeout *TimeoutError
+	if !errors.As(err, &timeout) {
+		t.Fatalf("Execute() error = %v, want a timeout", err)
+	}
+	if want := (TimeoutError{Scope: TimeoutRun, Node: "slow", Timeout: 20 * time.Millisecond}); *timeout != want {
+		t.Errorf("timeout = %+v, want %+v", *timeout, want)
+	}
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Execute() error = %v, want it to match ErrTimeout and context.DeadlineExceeded", err)
+	}
+	if want := []string{"fast", "slow"}; !reflect.DeepEqual(result.Path, want) {
+		t.Errorf("path = %v, want %v", result.Path, want)
+	}
+}
+
+func TestMaxSteps(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps int
+		err   bool
+	}{
+		{"as many steps as nodes run", 6, false},
+		{"fewer steps than nodes run", 5, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Three rounds of a and b run six nodes
+			engine, err := roundGraph(3).Compile(WithMaxSteps(tt.steps))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := engine.Execute(context.Background(), "input")
+			if !tt.err {
+				if err != nil {
+					t.Fatalf("Execute() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrMaxSteps) {
+				t.Fatalf("Execute() error = %v, want ErrMaxSteps", err)
+			}
+			if len(result.Path) != tt.steps {
+				t.Errorf("%d nodes ran, want %d", len(result.Path), tt.steps)
+			}
+		})
+	}
+}
+
+func TestNodeTimeouts(t *testing.T) {
+	const def = `
+entry: a
+nodes:
+  - {name: a, next: b, timeout: 2s}
+  - {name: b, edge: to_branch:worker}
+  - {name: c}
+edges: [{name: to_branch:worker, routes: ["branch:worker"]}]
+branches: [{name: worker, fanout: split, node: worker, join: c, timeout: 3s}]`
+
+	tests := []struct {
+		name string
+		opts []EngineOption
+		want map[string]time.Duration
+	}{
+		{
+			name: "declared timeouts of nodes and branches",
+			want: map[string]time.Duration{"a": 2 * time.Second, "b": 0, "worker": 3 * time.Second},
+		},
+		{
+			name: "options override declared timeouts",
+			opts: []EngineOption{WithNodeTimeout("a", time.Second), WithNodeTimeout("worker", 0)},
+			want: map[string]time.Duration{"a": time.Second, "b": 0, "worker": 0},
+		},
+		{
+			name: "the default applies to nodes without a timeout",
+			opts: []EngineOption{WithDefaultNodeTimeout(5 * time.Second)},
+			want: map[string]time.Duration{"a": 2 * time.Second, "b": 5 * time.Second, "worker": 3 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes, edges, branches := testRegistries("to_branch:worker")
+			engine, err := newEngine(nodes, edges, branches, parseTestDefinition(t, def), tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for node, want := range tt.want {
+				if got := engine.nodeTimeout(node); got != want {
+					t.Errorf("timeout of %s = %v, want %v", node, got, want)
+				}
+			}
+		})
+	}
+
+}
+
+func TestSearchSubgraphUsesSearchTimeout(t *testing.T) {
+	def, err := LoadDefinition("definitions/research-subgraph.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := NewEngine("", "", WithFakeLLM(), WithDefinition(def), WithNodeTimeout("search_query", 5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Searches inside search_and_merge are limited like those of the parent graph
+	if got := engine.nodeRegistry.search.nodeTimeout("search_query"); got != 5*time.Second {
+		t.Errorf("search timeout inside the subgraph = %v, want 5s", got)
+	}
+	if got := engine.nodeRegistry.searchTimeout; got != 5*time.Second {
+		t.Errorf("search timeout of execute_parallel_search = %v, want 5s", got)
+	}
+}
+
+func TestNodeTimeoutRetries(t *testing.T) {
+	strict, err := (&RetryDefinition{MaxAttempts: 3, RetryOn: []string{RetryOnServerError}}).Policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		policy   *RetryPolicy
+		slow     int // attempts that exceed the timeout
+		attempts int
+		wantErr  string
+	}{
+		{"without a retry policy", nil, 1, 1, "node slow timed out after 10ms"},
+		{"retried with a fresh timeout", &RetryPolicy{MaxAttempts: 3}, 1, 2, ""},
+		{"retried until the attempts run out", &RetryPolicy{MaxAttempts: 3}, 3, 3, "after 3 attempts: node slow timed out after 10ms"},
+		{"retry_on without timeout", &strict, 1, 1, "node slow timed out after 10ms"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			slow := func(ctx context.Context, state *AppState) error {
+				attempts++
+				if attempts <= tt.slow {
+					return waitNode(time.Second)(ctx, state)
+				}
+				return nil
+			}
+
+			opts := []EngineOption{WithNodeTimeout("slow", 10*time.Millisecond)}
+			if tt.policy != nil {
+				opts = append(opts, WithRetryPolicy("slow", *tt.policy))
+			}
+			engine, err := NewBuilder().AddNode("slow", slow).SetEntry("slow").Compile(opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = engine.Execute(context.Background(), "input")
+			if attempts != tt.attempts {
+				t.Errorf("%d attempts, want %d", attempts, tt.attempts)
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Execute() error = %v", err)
+				}
+				return
+			}
+
+			var timeout *TimeoutError
+			if !errors.As(err, &timeout) || timeout.Scope != TimeoutNode || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Execute() error = %v, want a node timeout %q", err, tt.wantErr)
+			}
+		})
+	}
+}
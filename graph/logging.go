@@ -0,0 +1,65 @@
+package graph
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// LogHandler wraps handler so records logged with the context of a run carry its
+// run ID and, inside nodes, the nested node path, branch ID and classified intent.
+// Install it with slog.SetDefault(slog.New(graph.LogHandler(handler))).
+func LogHandler(handler slog.Handler) slog.Handler {
+	return logHandler{Handler: handler}
+}
+
+type logHandler struct {
+	slog.Handler
+}
+
+type logRunContextKey struct{}
+
+// withLogRun attaches the run ID to records logged by the runner between nodes
+func withLogRun(ctx context.Context, runID string) context.Context {
+	if nestedRun(ctx) {
+		// Records of a subgraph run belong to the parent's node
+		return ctx
+	}
+	return context.WithValue(ctx, logRunContextKey{}, runID)
+}
+
+func (h logHandler) Handle(ctx context.Context, record slog.Record) error {
+	if scope, ok := ctx.Value(runScopeContextKey{}).(runScope); ok {
+		record.AddAttrs(slog.String("run_id", scope.runID))
+		if !hasAttr(record, "node") {
+			// The runner names the node it is about to execute itself
+			record.AddAttrs(slog.String("node", strings.Join(scope.path, "/")))
+		}
+		if scope.intent != "" {
+			record.AddAttrs(slog.String("intent", scope.intent))
+		}
+	} else if runID, ok := ctx.Value(logRunContextKey{}).(string); ok {
+		record.AddAttrs(slog.String("run_id", runID))
+	}
+	if branch, ok := BranchFromContext(ctx); ok {
+		record.AddAttrs(slog.String("branch_id", branch.ID))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func hasAttr(record slog.Record, key string) bool {
+	found := false
+	record.Attrs(func(attr slog.Attr) bool {
+		found = attr.Key == key
+		return !found
+	})
+	return found
+}
+
+func (h logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return logHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h logHandler) WithGroup(name string) slog.Handler {
+	return logHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,75 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LoopLimitError is returned when a node runs more often than its max_visits allows
+type LoopLimitError struct {
+	Node  string
+	Limit int
+	// Loop lists the nodes of the cycle that led back to Node, starting and ending with it
+	Loop []string
+}
+
+func (e *LoopLimitError) Error() string {
+	return fmt.Sprintf("node %s exceeded its limit of %d visits in loop %s", e.Node, e.Limit, strings.Join(e.Loop, " → "))
+}
+
+// visit counts an execution of node and enforces its visit limit
+func (r *runner) visit(node string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.visits == nil {
+		r.visits = make(map[string]int)
+	}
+	r.visits[node]++
+
+	limit := r.engine.flow.MaxVisits[node]
+	if limit > 0 && r.visits[node] > limit {
+		return &LoopLimitError{Node: node, Limit: limit, Loop: r.loop(node)}
+	}
+	return nil
+}
+
+// loop returns the declared nodes executed since node last ran, closing the cycle with node.
+// Callers hold r.mu.
+func (r *runner) loop(node string) []string {
+	declared := make(map[string]bool)
+	for _, n := range r.engine.definition.Nodes {
+		declared[n.Name] = true
+	}
+
+	// Branch executions appear in the path under their branch IDs; skip them
+	var nodes []string
+	for _, n := range r.path {
+		if declared[n] {
+			nodes = append(nodes, n)
+		}
+	}
+
+	start := len(nodes)
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if nodes[i] == node {
+			start = i
+			break
+		}
+	}
+
+	loop := append([]string(nil), nodes[start:]...)
+	return append(loop, node)
+}
+
+// copyVisits snapshots the visit counts for a checkpoint. Callers hold r.mu.
+func (r *runner) copyVisits() map[string]int {
+	if len(r.visits) == 0 {
+		return nil
+	}
+	visits := make(map[string]int, len(r.visits))
+	for node, count := range r.visits {
+		visits[node] = count
+	}
+	return visits
+}
@@ -0,0 +1,197 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var roundsKey = NewKey("rounds", Replace[int]())
+
+// roundGraph loops from a through b back to a until a has run rounds times;
+// a may run at most three times
+func roundGraph(rounds int) *Builder {
+	count := func(ctx context.Context, state *AppState) error {
+		Update(state, roundsKey, Get(state, roundsKey)+1)
+		return nil
+	}
+	again := func(ctx context.Context, state *AppState) (string, error) {
+		if Get(state, roundsKey) >= rounds {
+			return EndRoute, nil
+		}
+		return "a", nil
+	}
+
+	return NewBuilder().
+		AddNode("a", count).
+		AddNode("b", func(ctx context.Context, state *AppState) error { return nil }).
+		AddEdge("a", "b").
+		AddConditionalEdge("b", again, "a", EndRoute).
+		SetMaxVisits("a", 3).
+		SetEntry("a")
+}
+
+func TestMaxVisits(t *testing.T) {
+	tests := []struct {
+		name   string
+		rounds int
+		path   []string
+		loop   []string // loop of the limit error, if the run exceeds the limit
+	}{
+		{"a single round", 1, []string{"a", "b"}, nil},
+		{"as many rounds as visits", 3, []string{"a", "b", "a", "b", "a", "b"}, nil},
+		{"more rounds than visits", 4, []string{"a", "b", "a", "b", "a", "b"}, []string{"a", "b", "a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := roundGraph(tt.rounds).Compile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := engine.Execute(context.Background(), "input")
+			if tt.loop != nil {
+				var loopErr *LoopLimitError
+				if !errors.As(err, &loopErr) {
+					t.Fatalf("Execute() error = %v, want a loop limit error", err)
+				}
+				if loopErr.Node != "a" || loopErr.Limit != 3 || !reflect.DeepEqual(loopErr.Loop, tt.loop) {
+					t.Errorf("loop limit error = %+v, want a limited to 3 in loop %v", loopErr, tt.loop)
+				}
+				if want := "node a exceeded its limit of 3 visits in loop a → b → a"; err.Error() != want {
+					t.Errorf("error = %q, want %q", err, want)
+				}
+			} else if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if !reflect.DeepEqual(result.Path, tt.path) {
+				t.Errorf("path = %v, want %v", result.Path, tt.path)
+			}
+		})
+	}
+}
+
+func TestMaxVisitsSurviveResume(t *testing.T) {
+	engine, err := roundGraph(4).Compile(WithInterruptBefore("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// Every round pauses, so each resume restores the visits from the checkpoint
+	_, err = engine.Execute(ctx, "input")
+	for round := 1; round <= 3; round++ {
+		var interrupted *InterruptedError
+		if !errors.As(err, &interrupted) {
+			t.Fatalf("round %d: error = %v, want an interrupt", round, err)
+		}
+		_, err = engine.Resume(ctx, interrupted.RunID)
+	}
+
+	var loopErr *LoopLimitError
+	if !errors.As(err, &loopErr) || loopErr.Node != "a" {
+		t.Fatalf("Resume() error = %v, want a to exceed its visits", err)
+	}
+}
+
+func TestSplitSearchQueriesSearchesNewQueriesOnly(t *testing.T) {
+	state := NewAppState("input")
+	for i := 1; i <= 3; i++ {
+		state.AddSearchQuery(fmt.Sprintf("q%d", i))
+	}
+	Update(state, SearchedQueriesKey, 3)
+	if _, err := SplitSearchQueries(state); err == nil {
+		t.Error("SplitSearchQueries() without new queries succeeded")
+	}
+
+	// The next round searches the added queries, numbering their results after the earlier ones
+	state.AddSearchQuery("q4")
+	state.AddSearchQuery("q5")
+	payloads, err := SplitSearchQueries(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{"q4", "q5"}; !reflect.DeepEqual(payloads, want) {
+		t.Errorf("payloads = %v, want %v", payloads, want)
+	}
+	if got := searchSource(state, Branch{Name: "search_query", Index: 1}); got != "Search_search_query_5" {
+		t.Errorf("source = %s, want Search_search_query_5", got)
+	}
+}
+
+func TestLoopWithFakeLLM(t *testing.T) {
+	def, err := LoadDefinition("definitions/iterative-research.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	longReport := "# 調査レポート\n\n" + strings.Repeat("詳細な分析。", 200)
+
+	tests := []struct {
+		name        string
+		rules       []FakeRule
+		wantQueries int
+		wantErr     bool
+	}{
+		{
+			name: "loops until the report is thorough",
+			rules: append([]FakeRule{
+				// The second round searches new queries and sees their results
+				FakeResponse(`(?s)多様な検索クエリを4-5個生成してください.*調査済みです`, `["追加1", "追加2", "追加3"]`),
+				FakeResponse(`(?s)レポートを作成してください.*Search_search_query_8`, longReport),
+			}, DemoScript()...),
+			wantQueries: 8,
+		},
+		{
+			name:    "fails once the loop exceeds its visits",
+			rules:   DemoScript(),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := NewEngine("", "", WithFakeLLM(tt.rules...), WithDefinition(def))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := engine.Execute(context.Background(), "LLMの最新動向を調べて")
+			if tt.wantErr {
+				var loopErr *LoopLimitError
+				if !errors.As(err, &loopErr) {
+					t.Fatalf("Execute() error = %v, want a loop limit error", err)
+				}
+				// Branch executions are left out of the loop
+				wantLoop := []string{"generate_search_queries", "merge_search_results", "draft_outline", "synthesize_and_report", "generate_search_queries"}
+				if loopErr.Node != "generate_search_queries" || loopErr.Limit != 3 || !reflect.DeepEqual(loopErr.Loop, wantLoop) {
+					t.Errorf("loop limit error = %+v, want generate_search_queries limited to 3 in loop %v", loopErr, wantLoop)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+
+			state := result.FinalState
+			if got := len(state.GetSearchQueries()); got != tt.wantQueries {
+				t.Errorf("queries = %d, want %d", got, tt.wantQueries)
+			}
+			// Each round searches only its own queries
+			if got := len(state.GetRawContents()); got != tt.wantQueries {
+				t.Errorf("sources = %d, want %d", got, tt.wantQueries)
+			}
+			for i := 1; i <= tt.wantQueries; i++ {
+				if _, ok := state.GetRawContents()[fmt.Sprintf("Search_search_query_%d", i)]; !ok {
+					t.Errorf("missing result of query %d in %v", i, state.GetRawContents())
+				}
+			}
+			if state.Report != longReport {
+				t.Errorf("report = %.40q, want the thorough report", state.Report)
+			}
+		})
+	}
+}
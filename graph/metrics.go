@@ -0,0 +1,136 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// engineMetrics are the OpenTelemetry instruments of all engines. They report to
+// the global meter provider (see otel.SetMeterProvider), which e.g. the web
+// server exports for Prometheus.
+type engineMetrics struct {
+	runsStarted  metric.Int64Counter
+	runsFinished metric.Int64Counter
+	nodeDuration metric.Float64Histogram
+	branchFanOut metric.Int64Histogram
+	llmTokens    metric.Int64Counter
+	searches     metric.Int64Counter
+}
+
+var metrics = newEngineMetrics()
+
+func newEngineMetrics() *engineMetrics {
+	meter := otel.Meter(instrumentationName)
+	m := &engineMetrics{}
+	var errs [6]error
+	m.runsStarted, errs[0] = meter.Int64Counter("graph.runs.started",
+		metric.WithDescription("Runs started, including resumed and forked runs"))
+	m.runsFinished, errs[1] = meter.Int64Counter("graph.runs.finished",
+		metric.WithDescription("Runs finished by intent and status (completed, failed, timeout, cancelled or interrupted)"))
+	m.nodeDuration, errs[2] = meter.Float64Histogram("graph.node.duration",
+		metric.WithDescription("Duration of node attempts by node and status"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120))
+	m.branchFanOut, errs[3] = meter.Int64Histogram("graph.branch.fanout",
+		metric.WithDescription("Number of branches started by a branch point"),
+		metric.WithExplicitBucketBoundaries(1, 2, 3, 5, 8, 13, 20))
+	m.llmTokens, errs[4] = meter.Int64Counter("llm.tokens",
+		metric.WithDescription("LLM tokens by model and type (prompt or completion)"))
+	m.searches, errs[5] = meter.Int64Counter("search.requests",
+		metric.WithDescription("Web search requests by provider and status (ok or error)"))
+
+	// Instruments only fail for invalid names, which is a programming error
+	if err := errors.Join(errs[:]...); err != nil {
+		panic(fmt.Sprintf("graph: invalid metric instruments: %v", err))
+	}
+	return m
+}
+
+// runStarted counts a run; subgraph runs are part of their parent's run
+func (m *engineMetrics) runStarted(ctx context.Context) {
+	if nestedRun(ctx) {
+		return
+	}
+	m.runsStarted.Add(ctx, 1)
+}
+
+// runFinished counts the outcome of a run by the intent it was classified with
+func (m *engineMetrics) runFinished(ctx context.Context, result *ExecutionResult, err error) {
+	if nestedRun(ctx) {
+		return
+	}
+	intent := ""
+	if result != nil && result.FinalState != nil {
+		intent = result.FinalState.GetIntent()
+	}
+	m.runsFinished.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("intent", intent),
+		attribute.String("status", runStatus(err)),
+	))
+}
+
+func (m *engineMetrics) nodeFinished(ctx context.Context, key string, duration time.Duration, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	m.nodeDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(
+		attribute.String("node", key),
+		attribute.String("status", status),
+	))
+}
+
+func (m *engineMetrics) branchStarted(ctx context.Context, branch string, fanOut int) {
+	m.branchFanOut.Record(ctx, int64(fanOut), metric.WithAttributes(attribute.String("branch", branch)))
+}
+
+func (m *engineMetrics) tokensUsed(ctx context.Context, model string, promptTokens, completionTokens int) {
+	m.llmTokens.Add(ctx, int64(promptTokens), metric.WithAttributes(
+		attribute.String("model", model),
+		attribute.String("type", "prompt"),
+	))
+	m.llmTokens.Add(ctx, int64(completionTokens), metric.WithAttributes(
+		attribute.String("model", model),
+		attribute.String("type", "completion"),
+	))
+}
+
+func (m *engineMetrics) searched(ctx context.Context, provider string, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	m.searches.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("provider", provider),
+		attribute.String("status", status),
+	))
+}
+
+// nestedRun reports whether ctx belongs to a node of another run, i.e. a subgraph run starts in it
+func nestedRun(ctx context.Context) bool {
+	_, nested := ctx.Value(runScopeContextKey{}).(runScope)
+	return nested
+}
+
+// runStatus names the outcome of a run for spans and metrics
+func runStatus(err error) string {
+	var interrupted *InterruptedError
+	switch {
+	case err == nil:
+		return "completed"
+	case errors.As(err, &interrupted):
+		return "interrupted"
+	case errors.Is(err, ErrCancelled):
+		return "cancelled"
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	default:
+		return "failed"
+	}
+}
@@ -0,0 +1,117 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// metricsReader collects the engine metrics. The instruments are created from
+// the global meter provider, which can only be set once, so all tests share it.
+var metricsReader = sync.OnceValue(func() *sdkmetric.ManualReader {
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	return reader
+})
+
+// collectMetrics returns the sums of the counters and the counts of the
+// histograms, keyed by metric name and attributes
+func collectMetrics(t *testing.T) map[string]float64 {
+	t.Helper()
+	var data metricdata.ResourceMetrics
+	if err := metricsReader().Collect(context.Background(), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]float64{}
+	key := func(name string, attrs attribute.Set) string {
+		return name + "{" + attrs.Encoded(attribute.DefaultEncoder()) + "}"
+	}
+	for _, scope := range data.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, point := range data.DataPoints {
+					values[key(m.Name, point.Attributes)] += float64(point.Value)
+				}
+			case metricdata.Histogram[float64]:
+				for _, point := range data.DataPoints {
+					values[key(m.Name, point.Attributes)] += float64(point.Count)
+				}
+			case metricdata.Histogram[int64]:
+				for _, point := range data.DataPoints {
+					values[key(m.Name, point.Attributes)] += float64(point.Count)
+				}
+			}
+		}
+	}
+	return values
+}
+
+func TestMetricsWithFakeLLM(t *testing.T) {
+	failing := append([]FakeRule{{
+		Pattern: regexp.MustCompile(`レポートを作成してください`),
+		Err:     errors.New("service unavailable"),
+	}}, DemoScript()...)
+
+	tests := []struct {
+		name  string
+		rules []FakeRule
+		want  map[string]float64 // increase of each metric, tokens are checked against the usage
+	}{
+		{
+			name: "completed",
+			want: map[string]float64{
+				"graph.runs.started{}": 1,
+				"graph.runs.finished{intent=research,status=completed}":     1,
+				"graph.node.duration{node=search_query,status=ok}":          5,
+				"graph.node.duration{node=synthesize_and_report,status=ok}": 1,
+				"graph.branch.fanout{branch=search_query}":                  1,
+			},
+		},
+		{
+			name:  "failed",
+			rules: failing,
+			want: map[string]float64{
+				"graph.runs.started{}":                                         1,
+				"graph.runs.finished{intent=research,status=failed}":           1,
+				"graph.node.duration{node=search_query,status=ok}":             5,
+				"graph.node.duration{node=synthesize_and_report,status=error}": 1,
+				"graph.node.duration{node=synthesize_and_report,status=ok}":    0,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := NewEngine("", "", WithFakeLLM(tt.rules...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			before := collectMetrics(t)
+			result, _ := engine.Execute(context.Background(), "LLMの最新動向を調べて")
+			after := collectMetrics(t)
+
+			want := map[string]float64{
+				"llm.tokens{model=fake,type=prompt}":     float64(result.Usage.PromptTokens),
+				"llm.tokens{model=fake,type=completion}": float64(result.Usage.CompletionTokens),
+			}
+			for key, value := range tt.want {
+				want[key] = value
+			}
+			for key, value := range want {
+				if got := after[key] - before[key]; got != value {
+					t.Errorf("%s increased by %v, want %v", key, got, value)
+				}
+			}
+		})
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"regexp"
 	"strings"
 	"sync"
@@ -12,7 +12,6 @@ import (
 
 	"github.com/tidwall/gjson"
 	"github.com/tmc/langchaingo/llms"
-	"github.com/tmc/langchaingo/llms/openai"
 	"github.com/takako/openai-go-demo/tools"
 )
 
@@ -24,18 +23,48 @@ type NodeRegistry struct {
 	nodes     map[string]Node
 	llm       llms.Model
 	serpAPI   *tools.SerpAPIClient
+	// models of the nodes that do not use llm, keyed by node name
+	nodeModels map[string]nodeModel
+	// engines of the subgraph nodes, for exporting their topology
+	subgraphs map[string]*Engine
+	// built-in search subgraph, recompiled with the limits of the engine using it
+	search *Engine
+	// bounds each search of execute_parallel_search (0 = none)
+	searchTimeout time.Duration
 }
 
-// NewNodeRegistry creates a new node registry with an LLM
-func NewNodeRegistry(apiKey, serpAPIKey string) (*NodeRegistry, error) {
-	llm, err := openai.New(
-		openai.WithToken(apiKey),
-		openai.WithModel("gpt-4o-2024-08-06"),
-	)
+// defaultModel is the OpenAI model the nodes use unless another model is given
+const defaultModel = "gpt-4o-2024-08-06"
+
+// RegistryOption configures a NodeRegistry
+type RegistryOption func(*NodeRegistry)
+
+// WithRegistryLLM makes the nodes call llm instead of the default OpenAI model.
+// model names it in usage, prices and spans.
+func WithRegistryLLM(llm llms.Model, model string) RegistryOption {
+	return func(r *NodeRegistry) {
+		r.useModel(llm, model)
+	}
+}
+
+// NewNodeRegistry creates a new node registry with an LLM, by default OpenAI's
+// defaultModel with apiKey
+func NewNodeRegistry(apiKey, serpAPIKey string, opts ...RegistryOption) (*NodeRegistry, error) {
+	registry, err := newNodeRegistry(serpAPIKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create LLM: %w", err)
+		return nil, err
+	}
+	for _, opt := range opts {
+		opt(registry)
 	}
+	if err := registry.useDefaultModel(apiKey); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
 
+// newNodeRegistry registers the built-in nodes; they need a model before they run
+func newNodeRegistry(serpAPIKey string) (*NodeRegistry, error) {
 	// Initialize SerpAPI client (optional)
 	var serpAPI *tools.SerpAPIClient
 	if serpAPIKey != "" {
@@ -44,7 +73,6 @@ func NewNodeRegistry(apiKey, serpAPIKey string) (*NodeRegistry, error) {
 
 	registry := &NodeRegistry{
 		nodes:   make(map[string]Node),
-		llm:     llm,
 		serpAPI: serpAPI,
 	}
 
@@ -52,19 +80,85 @@ func NewNodeRegistry(apiKey, serpAPIKey string) (*NodeRegistry, error) {
 	registry.RegisterNode("classify_intent_and_topic", registry.ClassifyIntentAndTopic)
 	registry.RegisterNode("generate_search_queries", registry.GenerateSearchQueries)
 	registry.RegisterNode("execute_parallel_search", registry.ExecuteParallelSearch)
+	registry.RegisterNode("search_query", registry.SearchQuery)
 	registry.RegisterNode("merge_search_results", registry.MergeSearchResults)
+	registry.RegisterNode("draft_outline", registry.DraftOutline)
 	registry.RegisterNode("synthesize_and_report", registry.SynthesizeAndReport)
 	registry.RegisterNode("answer_directly", registry.AnswerDirectly)
 	registry.RegisterNode("handle_chat", registry.HandleChat)
 
+	// Search and merge packaged as a subgraph node
+	search, err := registry.SearchSubgraph()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build search subgraph: %w", err)
+	}
+	registry.RegisterSubgraph("search_and_merge", search, SearchMapping)
+	registry.search = search
+
 	return registry, nil
 }
 
+// useModel makes the nodes call llm, metered and traced under the name model
+func (r *NodeRegistry) useModel(llm llms.Model, model string) {
+	r.llm = TelemetryModel(TracedModel(MeteredModel(llm, model)), model)
+}
+
+// useNodeModel makes a node call its own model or sample with its own options
+func (r *NodeRegistry) useNodeModel(node string, model NodeModel) {
+	selected := nodeModel{options: model.callOptions()}
+	if model.LLM != nil {
+		selected.llm = TelemetryModel(TracedModel(MeteredModel(model.LLM, model.Model)), model.Model)
+	}
+	if r.nodeModels == nil {
+		r.nodeModels = make(map[string]nodeModel)
+	}
+	r.nodeModels[node] = selected
+}
+
+// model returns the model of the executing node
+func (r *NodeRegistry) model(ctx context.Context) llms.Model {
+	key, _ := ctx.Value(nodeKeyContextKey{}).(string)
+	selected, ok := r.nodeModels[key]
+	if !ok {
+		return r.llm
+	}
+	llm := selected.llm
+	if llm == nil {
+		llm = r.llm
+	}
+	if len(selected.options) == 0 {
+		return llm
+	}
+	return optionsModel{Model: llm, options: selected.options}
+}
+
+// useDefaultModel falls back to OpenAI's defaultModel when no model was given
+func (r *NodeRegistry) useDefaultModel(apiKey string) error {
+	if r.llm != nil {
+		return nil
+	}
+	llm, err := Provider{Name: ProviderOpenAI, APIKey: apiKey}.NewModel(defaultModel)
+	if err != nil {
+		return fmt.Errorf("failed to create LLM: %w", err)
+	}
+	r.useModel(llm, defaultModel)
+	return nil
+}
+
 // RegisterNode registers a node with a name
 func (r *NodeRegistry) RegisterNode(name string, node Node) {
 	r.nodes[name] = node
 }
 
+// RegisterSubgraph registers a compiled graph as a single node
+func (r *NodeRegistry) RegisterSubgraph(name string, subgraph *Engine, mapping StateMapping) {
+	r.RegisterNode(name, subgraph.AsNode(name, mapping))
+	if r.subgraphs == nil {
+		r.subgraphs = make(map[string]*Engine)
+	}
+	r.subgraphs[name] = subgraph
+}
+
 // GetNode retrieves a node by name
 func (r *NodeRegistry) GetNode(name string) (Node, bool) {
 	node, exists := r.nodes[name]
@@ -73,17 +167,17 @@ func (r *NodeRegistry) GetNode(name string) (Node, bool) {
 
 // ClassifyIntentAndTopic determines user intent and extracts the topic  
 func (r *NodeRegistry) ClassifyIntentAndTopic(ctx context.Context, state *AppState) error {
-	log.Printf("DEBUG: Classifying input: '%s'", state.UserInput)
+	slog.DebugContext(ctx, "Classifying input", "input", state.UserInput)
 	
 	// First, try keyword-based classification for reliable results
-	keywordResult := r.classifyByKeywords(state.UserInput)
-	log.Printf("DEBUG: Keyword classification result: intent=%s, topic=%s", keywordResult.Intent, keywordResult.Topic)
+	keywordResult := r.classifyByKeywords(ctx, state.UserInput)
+	slog.DebugContext(ctx, "Keyword classification result", "intent", keywordResult.Intent, "topic", keywordResult.Topic)
 	
 	// If keyword classification returns research, ALWAYS use it (highest priority)
 	if keywordResult.Intent == "research" {
 		state.SetIntent(keywordResult.Intent)
 		state.SetTopic(keywordResult.Topic)
-		log.Printf("✅ Classified by keywords: intent=%s, topic=%s", keywordResult.Intent, keywordResult.Topic)
+		slog.InfoContext(ctx, "✅ Classified by keywords", "intent", keywordResult.Intent, "topic", keywordResult.Topic)
 		return nil
 	}
 	
@@ -91,7 +185,7 @@ func (r *NodeRegistry) ClassifyIntentAndTopic(ctx context.Context, state *AppSta
 	if keywordResult.Intent == "chat" {
 		state.SetIntent(keywordResult.Intent)
 		state.SetTopic(keywordResult.Topic)
-		log.Printf("✅ Classified by keywords: intent=%s, topic=%s", keywordResult.Intent, keywordResult.Topic)
+		slog.InfoContext(ctx, "✅ Classified by keywords", "intent", keywordResult.Intent, "topic", keywordResult.Topic)
 		return nil
 	}
 	
@@ -130,7 +224,7 @@ Respond in JSON format:
 }`, state.UserInput)
 
 	// NO STREAMING for classification to ensure complete JSON response
-	response, err := llms.GenerateFromSinglePrompt(ctx, r.llm, prompt)
+	response, err := llms.GenerateFromSinglePrompt(ctx, r.model(ctx), prompt)
 	if err != nil {
 		return fmt.Errorf("failed to classify intent: %w", err)
 	}
@@ -142,7 +236,7 @@ Respond in JSON format:
 	}
 	if err := json.Unmarshal([]byte(response), &result); err != nil {
 		// Fallback: use keyword-based classification
-		result = r.classifyByKeywords(state.UserInput)
+		result = r.classifyByKeywords(ctx, state.UserInput)
 	}
 
 	// Additional validation: ensure "教えて" type questions go to research
@@ -169,17 +263,17 @@ Respond in JSON format:
 	state.SetIntent(result.Intent)
 	state.SetTopic(result.Topic)
 	
-	log.Printf("Classified intent: %s, topic: %s", result.Intent, result.Topic)
+	slog.InfoContext(ctx, "Classified intent", "intent", result.Intent, "topic", result.Topic)
 	return nil
 }
 
 // classifyByKeywords provides fallback keyword-based classification
-func (r *NodeRegistry) classifyByKeywords(input string) struct {
+func (r *NodeRegistry) classifyByKeywords(ctx context.Context, input string) struct {
 	Intent string `json:"intent"`
 	Topic  string `json:"topic"`
 } {
 	lower := strings.ToLower(input)
-	log.Printf("DEBUG: Keyword analysis for: '%s' -> '%s'", input, lower)
+	slog.DebugContext(ctx, "Keyword analysis", "input", input, "normalized", lower)
 	
 	// Research keywords (broad match) - より包括的に
 	researchKeywords := []string{
@@ -235,14 +329,14 @@ func (r *NodeRegistry) classifyByKeywords(input string) struct {
 	// Check for research (broad match)
 	for _, keyword := range researchKeywords {
 		if strings.Contains(lower, keyword) {
-			log.Printf("DEBUG: ✅ RESEARCH keyword matched: '%s'", keyword)
+			slog.DebugContext(ctx, "Research keyword matched", "keyword", keyword)
 			return struct {
 				Intent string `json:"intent"`
 				Topic  string `json:"topic"`
 			}{"research", input}
 		}
 	}
-	log.Printf("DEBUG: ❌ No research keywords matched")
+	slog.DebugContext(ctx, "No research keywords matched")
 	
 	// Default to research for educational/informational queries
 	return struct {
@@ -265,9 +359,14 @@ func (r *NodeRegistry) GenerateSearchQueries(ctx context.Context, state *AppStat
 JSON配列形式で検索クエリを返してください:
 ["クエリ1", "クエリ2", "クエリ3", "クエリ4", "クエリ5"]`, state.Topic)
 
+	// When looping back for more research, ask for queries covering new ground
+	if existing := state.GetSearchQueries(); len(existing) > 0 {
+		prompt += fmt.Sprintf("\n\n以下のクエリは調査済みです。これらとは異なる観点のクエリを生成してください:\n- %s", strings.Join(existing, "\n- "))
+	}
+
 	// Use streaming for real-time updates
 	var response strings.Builder
-	_, err := llms.GenerateFromSinglePrompt(ctx, r.llm, prompt, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+	_, err := llms.GenerateFromSinglePrompt(ctx, r.model(ctx), prompt, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
 		response.Write(chunk)
 		state.OnStreamingChunk("generate_search_queries", string(chunk))
 		return nil
@@ -279,15 +378,15 @@ JSON配列形式で検索クエリを返してください:
 	// Parse the JSON response using gjson library
 	var queries []string
 	responseStr := response.String()
-	log.Printf("DEBUG: Raw LLM response for query generation: %q", responseStr)
+	slog.DebugContext(ctx, "Raw LLM response for query generation", "response", responseStr)
 	
 	// Extract JSON array using regex and gjson
 	if parsedQueries := parseQueriesWithGJson(responseStr); len(parsedQueries) > 0 {
 		queries = parsedQueries
-		log.Printf("DEBUG: gjson parsing successful, got %d queries", len(queries))
+		slog.DebugContext(ctx, "gjson parsing successful", "queries", len(queries))
 	} else {
 		// Fallback to text extraction
-		log.Printf("DEBUG: gjson parsing failed, using text extraction fallback")
+		slog.DebugContext(ctx, "gjson parsing failed, using text extraction fallback")
 		queries = extractQueriesFromText(responseStr)
 	}
 
@@ -295,23 +394,26 @@ JSON配列形式で検索クエリを返してください:
 	for i, query := range queries {
 		query = strings.TrimSpace(query)
 		if query != "" {
-			log.Printf("DEBUG: Adding query %d: %q", i+1, query)
+			slog.DebugContext(ctx, "Adding query", "index", i+1, "query", query)
 			state.AddSearchQuery(query)
 		} else {
-			log.Printf("DEBUG: Skipping empty query at index %d", i+1)
+			slog.DebugContext(ctx, "Skipping empty query", "index", i+1)
 		}
 	}
 
 	finalQueries := state.GetSearchQueries()
-	log.Printf("Generated %d search queries total", len(finalQueries))
-	for i, q := range finalQueries {
-		log.Printf("Final query %d: %q", i+1, q)
-	}
+	slog.InfoContext(ctx, "Generated search queries", "queries", finalQueries)
 	return nil
 }
 
 // ExecuteParallelSearch performs concurrent searches
 func (r *NodeRegistry) ExecuteParallelSearch(ctx context.Context, state *AppState) error {
+	// A run out of budget reports from what it already knows
+	if OverBudget(ctx) {
+		slog.WarnContext(ctx, "💸 Budget exhausted, skipping searches", "queries", len(state.SearchQueries))
+		return nil
+	}
+
 	var wg sync.WaitGroup
 	resultsChan := make(chan struct {
 		source  string
@@ -325,8 +427,11 @@ func (r *NodeRegistry) ExecuteParallelSearch(ctx context.Context, state *AppStat
 		go func(idx int, q string) {
 			defer wg.Done()
 			
-			// Create timeout context for individual search
-			searchCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			// Bound each search like a search_query branch
+			searchCtx, cancel := context.WithCancel(ctx)
+			if r.searchTimeout > 0 {
+				searchCtx, cancel = context.WithTimeout(ctx, r.searchTimeout)
+			}
 			defer cancel()
 			
 			// Perform real search using SerpAPI or fallback to simulation
@@ -364,29 +469,84 @@ func (r *NodeRegistry) ExecuteParallelSearch(ctx context.Context, state *AppStat
 	// Collect results
 	for result := range resultsChan {
 		if result.err != nil {
-			log.Printf("Search error for %s: %v", result.source, result.err)
+			slog.WarnContext(ctx, "Search failed", "source", result.source, "error", result.err)
 			continue
 		}
 		state.SetRawContent(result.source, result.content)
 	}
 
-	log.Printf("Collected %d search results", len(state.RawContents))
+	slog.InfoContext(ctx, "Collected search results", "results", len(state.RawContents))
+	return nil
+}
+
+// SearchQuery performs the search for a single query branch (payload: the query string)
+func (r *NodeRegistry) SearchQuery(ctx context.Context, state *AppState) error {
+	branch, ok := BranchFromContext(ctx)
+	if !ok {
+		return fmt.Errorf("search_query must run as a branch")
+	}
+	query, ok := branch.Payload.(string)
+	if !ok {
+		return fmt.Errorf("branch %s payload is %T, want a query string", branch.ID, branch.Payload)
+	}
+
+	// A run out of budget reports from the results it already has
+	if OverBudget(ctx) {
+		slog.WarnContext(ctx, "💸 Budget exhausted, skipping search", "query", query)
+		return nil
+	}
+
+	// The engine bounds each search with the branch timeout
+	var content string
+	var err error
+	if r.searchesWeb(ctx) {
+		slog.InfoContext(ctx, "Performing real search", "query", query)
+		content, err = r.serpSearch(ctx, query)
+	} else {
+		slog.InfoContext(ctx, "Using simulated search", "query", query)
+		content, err = r.simulateSearchForBranching(ctx, query, branch.ID, state)
+	}
+	if err != nil {
+		return err
+	}
+
+	state.SetRawContent(searchSource(state, branch), content)
 	return nil
 }
 
+// searchesWeb reports whether a search goes to SerpAPI rather than the LLM.
+// Replays follow the trace, so recorded searches replay without SERPAPI_KEY.
+func (r *NodeRegistry) searchesWeb(ctx context.Context) bool {
+	if searched, replaying := replaysSearch(ctx); replaying {
+		return searched
+	}
+	return r.serpAPI != nil
+}
+
 // realSearch performs actual web search using SerpAPI
 func (r *NodeRegistry) realSearch(ctx context.Context, query string) (string, error) {
 	// If SerpAPI is available, use real search
-	if r.serpAPI != nil {
-		log.Printf("Performing real search for: %s", query)
-		return r.serpAPI.SearchAndSummarize(ctx, query)
+	if r.searchesWeb(ctx) {
+		slog.InfoContext(ctx, "Performing real search", "query", query)
+		return r.serpSearch(ctx, query)
 	}
 	
 	// Fallback to LLM-simulated search
-	log.Printf("SerpAPI not available, falling back to simulated search for: %s", query)
+	slog.InfoContext(ctx, "SerpAPI not available, falling back to simulated search", "query", query)
 	return r.simulateSearch(ctx, query)
 }
 
+// serpSearch searches with SerpAPI; replays answer from the recorded results
+func (r *NodeRegistry) serpSearch(ctx context.Context, query string) (string, error) {
+	ctx, span := startSearchSpan(ctx, "serpapi", query)
+	content, err := TracedSearch(ctx, query, func(ctx context.Context) (string, error) {
+		return r.serpAPI.SearchAndSummarize(ctx, query)
+	})
+	metrics.searched(ctx, "serpapi", err)
+	endSpan(span, err)
+	return content, err
+}
+
 // simulateSearch simulates a search operation using LLM
 func (r *NodeRegistry) simulateSearch(ctx context.Context, query string) (string, error) {
 	prompt := fmt.Sprintf(`以下の検索クエリに対する簡潔で事実に基づいた要約を日本語で2-3段落で提供してください: "%s"
@@ -395,7 +555,7 @@ func (r *NodeRegistry) simulateSearch(ctx context.Context, query string) (string
 
 	// Use streaming for real-time updates
 	var response strings.Builder
-	_, err := llms.GenerateFromSinglePrompt(ctx, r.llm, prompt, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+	_, err := llms.GenerateFromSinglePrompt(ctx, r.model(ctx), prompt, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
 		response.Write(chunk)
 		// Note: simulateSearch doesn't have access to state, so no streaming callback here
 		return nil
@@ -418,7 +578,7 @@ func (r *NodeRegistry) simulateSearchForBranching(ctx context.Context, query, qu
 
 	// Use streaming for real-time updates
 	var response strings.Builder
-	_, err := llms.GenerateFromSinglePrompt(ctx, r.llm, prompt, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+	_, err := llms.GenerateFromSinglePrompt(ctx, r.model(ctx), prompt, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
 		response.Write(chunk)
 		// Send streaming updates for this individual query node
 		state.OnStreamingChunk(queryId, string(chunk))
@@ -490,25 +650,79 @@ func extractQueriesFromText(text string) []string {
 		}
 	}
 	
-	log.Printf("DEBUG: Text extraction found %d queries", len(queries))
+	slog.Debug("Text extraction finished", "queries", len(queries))
 	return queries
 }
 
 // MergeSearchResults merges the results from individual search branches
 func (r *NodeRegistry) MergeSearchResults(ctx context.Context, state *AppState) error {
 	searchResults := state.GetRawContents()
-	log.Printf("Merging search results from %d individual searches", len(searchResults))
+	slog.InfoContext(ctx, "Merging search results", "results", len(searchResults))
 	
 	// Results are already stored in state by the dynamic branching engine
 	// This node just validates that we have results and logs the merge completion
 	if len(searchResults) == 0 {
+		// A run out of budget may have skipped every search
+		if OverBudget(ctx) {
+			slog.WarnContext(ctx, "💸 Budget exhausted before any search, reporting without results")
+			return nil
+		}
 		return fmt.Errorf("no search results to merge")
 	}
 	
-	log.Printf("Successfully merged %d search results", len(searchResults))
+	slog.InfoContext(ctx, "Successfully merged search results", "results", len(searchResults))
 	return nil
 }
 
+// DraftOutline drafts the section headings of the report from the search results.
+// Reviewers can edit them before synthesize_and_report writes the report along them.
+func (r *NodeRegistry) DraftOutline(ctx context.Context, state *AppState) error {
+	// A run out of budget writes the report without an outline
+	if OverBudget(ctx) {
+		slog.WarnContext(ctx, "💸 Budget exhausted, skipping outline")
+		return nil
+	}
+
+	var allContent strings.Builder
+	for source, content := range state.GetRawContents() {
+		allContent.WriteString(fmt.Sprintf("=== %s ===\n%s\n\n", source, content))
+	}
+
+	prompt := fmt.Sprintf(`以下の検索結果を元に、「%s」に関する調査レポートのアウトラインを作成してください。
+詳細分析の章見出しを4-6個、1行に1つずつ出力してください。見出し以外は出力しないでください。
+
+検索結果:
+%s`, state.Topic, allContent.String())
+
+	response, err := llms.GenerateFromSinglePrompt(ctx, r.model(ctx), prompt)
+	if err != nil {
+		return fmt.Errorf("failed to generate outline: %w", err)
+	}
+
+	outline := parseOutline(response)
+	if len(outline) == 0 {
+		return fmt.Errorf("no outline generated")
+	}
+	state.SetOutline(outline)
+	slog.InfoContext(ctx, "Drafted report outline", "sections", len(outline))
+	return nil
+}
+
+// outlineMarker matches the list and heading markers models put before outline headings
+var outlineMarker = regexp.MustCompile(`^((#+|[-*・]|\d+[.)．、])\s*)+`)
+
+// parseOutline extracts one heading per non-empty line of the response
+func parseOutline(text string) []string {
+	var outline []string
+	for _, line := range strings.Split(text, "\n") {
+		heading := strings.TrimSpace(outlineMarker.ReplaceAllString(strings.TrimSpace(line), ""))
+		if heading != "" && !strings.HasPrefix(heading, "```") {
+			outline = append(outline, heading)
+		}
+	}
+	return outline
+}
+
 // SynthesizeAndReport creates a comprehensive report from search results
 func (r *NodeRegistry) SynthesizeAndReport(ctx context.Context, state *AppState) error {
 	// Combine all search results
@@ -517,6 +731,13 @@ func (r *NodeRegistry) SynthesizeAndReport(ctx context.Context, state *AppState)
 		allContent.WriteString(fmt.Sprintf("=== %s ===\n%s\n\n", source, content))
 	}
 
+	// The reviewed outline structures the detailed analysis
+	var outline string
+	if headings := state.GetOutline(); len(headings) > 0 {
+		outline = "「## 詳細分析」は次のアウトラインの見出しを小見出し（###）として、この順序で構成してください:\n- " +
+			strings.Join(headings, "\n- ") + "\n\n"
+	}
+
 	prompt := fmt.Sprintf(`以下は調査レポートの例です。この例と同じ書式で「%s」に関するレポートを作成してください。
 
 検索結果:
@@ -551,16 +772,15 @@ AI開発の分野では新しいツールやフレームワークが急速に発
 - **導入検討**: 既存プロジェクトへのLangChain導入を検討する
 - **技術習得**: チーム全体でのAI開発スキルの向上を図る
 
-上記の例と同じ書式で「%s」についてのレポートを作成してください。`, state.Topic, allContent.String(), state.Topic)
+%s上記の例と同じ書式で「%s」についてのレポートを作成してください。`, state.Topic, allContent.String(), outline, state.Topic)
 
 	// Use streaming for real-time updates
 	var report strings.Builder
-	_, err := llms.GenerateFromSinglePrompt(ctx, r.llm, prompt, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+	_, err := llms.GenerateFromSinglePrompt(ctx, r.model(ctx), prompt, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
 		report.Write(chunk)
 		
-		// Debug: Log chunk content to see what we're getting
 		chunkStr := string(chunk)
-		log.Printf("LLM chunk received: %q (length: %d)", chunkStr, len(chunkStr))
+		slog.DebugContext(ctx, "LLM chunk received", "chunk", chunkStr, "length", len(chunkStr))
 		
 		state.OnStreamingChunk("synthesize_and_report", chunkStr)
 		return nil
@@ -571,7 +791,7 @@ AI開発の分野では新しいツールやフレームワークが急速に発
 
 	reportStr := report.String()
 	state.SetReport(reportStr)
-	log.Printf("Generated report with %d characters", len(reportStr))
+	slog.InfoContext(ctx, "Generated report", "characters", len(reportStr))
 	return nil
 }
 
@@ -581,7 +801,7 @@ func (r *NodeRegistry) AnswerDirectly(ctx context.Context, state *AppState) erro
 	
 	// Use streaming for real-time updates
 	var response strings.Builder
-	_, err := llms.GenerateFromSinglePrompt(ctx, r.llm, prompt, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+	_, err := llms.GenerateFromSinglePrompt(ctx, r.model(ctx), prompt, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
 		response.Write(chunk)
 		state.OnStreamingChunk("answer_directly", string(chunk))
 		return nil
@@ -600,7 +820,7 @@ func (r *NodeRegistry) HandleChat(ctx context.Context, state *AppState) error {
 	
 	// Use streaming for real-time updates
 	var response strings.Builder
-	_, err := llms.GenerateFromSinglePrompt(ctx, r.llm, prompt, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+	_, err := llms.GenerateFromSinglePrompt(ctx, r.model(ctx), prompt, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
 		response.Write(chunk)
 		state.OnStreamingChunk("handle_chat", string(chunk))
 		return nil
@@ -0,0 +1,211 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// PatchOp is a JSON Patch (RFC 6902) operation on the serialized AppState
+type PatchOp struct {
+	Op    string      `json:"op"`   // "add", "remove" or "replace"
+	Path  string      `json:"path"` // JSON Pointer, e.g. /raw_contents/Search_1; /- appends to an array
+	Value interface{} `json:"value"`
+}
+
+// WithStateSnapshots makes every streamed update carry a full State clone
+// in addition to its patch, as clients written before patches expect
+func WithStateSnapshots() EngineOption {
+	return func(e *Engine) {
+		e.stateSnapshots = true
+	}
+}
+
+// Snapshot returns the state of an in-flight run as of its latest update,
+// with the version the following patches apply to
+func (e *Engine) Snapshot(runID string) (*AppState, int, error) {
+	e.mu.Lock()
+	r, exists := e.active[runID]
+	e.mu.Unlock()
+	if !exists {
+		return nil, 0, fmt.Errorf("run %s is not active", runID)
+	}
+
+	r.mu.Lock()
+	document, version := r.emitted, r.version
+	r.mu.Unlock()
+
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to snapshot run %s: %w", runID, err)
+	}
+	state := NewAppState("")
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, 0, fmt.Errorf("failed to snapshot run %s: %w", runID, err)
+	}
+	return state, version, nil
+}
+
+// statePatch builds the patch of the members written to the run's state since the
+// previous update and advances the version. Only those members are serialized; the
+// whole state is diffed when it was replaced. The first call only records the
+// baseline the start update sends in full.
+func (r *runner) statePatch() ([]PatchOp, int) {
+	members, all, err := r.state.changedMembers()
+	r.mu.Lock()
+	emitted, isDocument := r.emitted.(map[string]interface{})
+	r.mu.Unlock()
+
+	var patch []PatchOp
+	var document interface{}
+	if err == nil {
+		if all || !isDocument {
+			document, err = stateDocument(r.state)
+			if err == nil && emitted != nil {
+				patch = diffDocuments("", emitted, document, nil)
+			}
+		} else {
+			patch, document, err = patchMembers(emitted, members)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		slog.Warn("⚠️  Failed to diff state", "run_id", r.runID, "error", err)
+		// Diff the whole state next time, since these changes were not sent
+		r.state.touchAll()
+		return nil, r.version
+	}
+	if r.emitted == nil || len(patch) > 0 {
+		r.version++
+	}
+	r.emitted = document
+	return patch, r.version
+}
+
+// patchMembers returns the operations applying the changed members to the emitted
+// document, and the document with the changes. The emitted document is left
+// unchanged, since operations of earlier updates may still refer to its values.
+func patchMembers(emitted map[string]interface{}, members map[string]json.RawMessage) ([]PatchOp, interface{}, error) {
+	document := make(map[string]interface{}, len(emitted))
+	for key, value := range emitted {
+		document[key] = value
+	}
+
+	var ops []PatchOp
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var value interface{}
+		if raw := members[name]; raw != nil {
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return nil, nil, fmt.Errorf("failed to decode state member %s: %w", name, err)
+			}
+		}
+
+		// Keys of custom channels are members of the channels object
+		parent, key, path := document, name, "/"+escapePointer(name)
+		if channel, ok := strings.CutPrefix(name, "channels/"); ok {
+			channels, exists := document["channels"].(map[string]interface{})
+			if !exists {
+				if members[name] == nil {
+					continue
+				}
+				ops = append(ops, PatchOp{Op: "add", Path: "/channels", Value: map[string]interface{}{}})
+			}
+			copied := make(map[string]interface{}, len(channels)+1)
+			for k, v := range channels {
+				copied[k] = v
+			}
+			document["channels"] = copied
+			parent, key, path = copied, channel, "/channels/"+escapePointer(channel)
+		}
+
+		old, exists := parent[key]
+		switch {
+		case members[name] == nil && exists:
+			ops = append(ops, PatchOp{Op: "remove", Path: path})
+			delete(parent, key)
+		case members[name] == nil:
+		case !exists:
+			ops = append(ops, PatchOp{Op: "add", Path: path, Value: value})
+			parent[key] = value
+		default:
+			ops = diffDocuments(path, old, value, ops)
+			parent[key] = value
+		}
+	}
+	return ops, document, nil
+}
+
+// stateDocument returns the state in its generic JSON form
+func stateDocument(state *AppState) (interface{}, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// diffDocuments appends the operations turning from into to at path.
+// Objects are diffed per key and arrays that only grew are appended to.
+func diffDocuments(path string, from, to interface{}, ops []PatchOp) []PatchOp {
+	switch to := to.(type) {
+	case map[string]interface{}:
+		if from, ok := from.(map[string]interface{}); ok {
+			for _, key := range sortedKeys(from) {
+				if _, exists := to[key]; !exists {
+					ops = append(ops, PatchOp{Op: "remove", Path: path + "/" + escapePointer(key)})
+				}
+			}
+			for _, key := range sortedKeys(to) {
+				old, exists := from[key]
+				if !exists {
+					ops = append(ops, PatchOp{Op: "add", Path: path + "/" + escapePointer(key), Value: to[key]})
+					continue
+				}
+				ops = diffDocuments(path+"/"+escapePointer(key), old, to[key], ops)
+			}
+			return ops
+		}
+	case []interface{}:
+		if from, ok := from.([]interface{}); ok && len(from) <= len(to) && reflect.DeepEqual(from, to[:len(from)]) {
+			for _, value := range to[len(from):] {
+				ops = append(ops, PatchOp{Op: "add", Path: path + "/-", Value: value})
+			}
+			return ops
+		}
+	}
+
+	if !reflect.DeepEqual(from, to) {
+		ops = append(ops, PatchOp{Op: "replace", Path: path, Value: to})
+	}
+	return ops
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapePointer escapes a key for use in a JSON Pointer
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
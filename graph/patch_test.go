@@ -0,0 +1,274 @@
+package graph
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// applyPatch applies a patch the way a client does: to the JSON it received
+func applyPatch(t *testing.T, document interface{}, patch []PatchOp) interface{} {
+	t.Helper()
+
+	// Clients receive the operations as JSON, so values never alias the new document
+	var received []PatchOp
+	decodeJSON(t, encodeJSON(t, patch), &received)
+
+	for _, op := range received {
+		var tokens []string
+		if op.Path != "" {
+			for _, token := range strings.Split(strings.TrimPrefix(op.Path, "/"), "/") {
+				tokens = append(tokens, strings.NewReplacer("~1", "/", "~0", "~").Replace(token))
+			}
+		}
+		document = applyOp(t, document, tokens, op)
+	}
+	return document
+}
+
+func applyOp(t *testing.T, node interface{}, tokens []string, op PatchOp) interface{} {
+	t.Helper()
+	if len(tokens) == 0 {
+		if op.Op != "replace" {
+			t.Fatalf("%s of the whole document", op.Op)
+		}
+		return op.Value
+	}
+
+	switch node := node.(type) {
+	case map[string]interface{}:
+		key := tokens[0]
+		if len(tokens) > 1 {
+			node[key] = applyOp(t, node[key], tokens[1:], op)
+			return node
+		}
+		switch _, exists := node[key]; {
+		case op.Op == "add":
+			node[key] = op.Value
+		case op.Op == "replace" && exists:
+			node[key] = op.Value
+		case op.Op == "remove" && exists:
+			delete(node, key)
+		default:
+			t.Fatalf("cannot %s missing member %s", op.Op, op.Path)
+		}
+		return node
+	case []interface{}:
+		if len(tokens) == 1 && tokens[0] == "-" && op.Op == "add" {
+			return append(node, op.Value)
+		}
+		index, err := strconv.Atoi(tokens[0])
+		if err != nil || index < 0 || index >= len(node) {
+			t.Fatalf("invalid array index in %s", op.Path)
+		}
+		node[index] = applyOp(t, node[index], tokens[1:], op)
+		return node
+	}
+	t.Fatalf("cannot apply %s %s to %T", op.Op, op.Path, node)
+	return nil
+}
+
+func encodeJSON(t *testing.T, value interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func decodeJSON(t *testing.T, data []byte, value interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(data, value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// document parses JSON into the generic form diffDocuments works on
+func document(t *testing.T, data string) interface{} {
+	t.Helper()
+	var doc interface{}
+	decodeJSON(t, []byte(data), &doc)
+	return doc
+}
+
+func TestDiffDocumentsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		wantOps  []string // op and path of every operation, in order
+	}{
+		{"unchanged", `{"a": 1, "b": [1, 2], "c": {"d": "x"}}`, `{"a": 1, "b": [1, 2], "c": {"d": "x"}}`, nil},
+		{"replaced scalar", `{"a": 1}`, `{"a": 2}`, []string{"replace /a"}},
+		{"added key", `{"a": 1}`, `{"a": 1, "b": {"c": true}}`, []string{"add /b"}},
+		{"removed key", `{"a": 1, "b": 2}`, `{"a": 1}`, []string{"remove /b"}},
+		{"removed nested key", `{"a": {"b": 1, "c": 2}}`, `{"a": {"c": 2}}`, []string{"remove /a/b"}},
+		{"removed and added keys", `{"b": 1, "d": 2}`, `{"a": 3, "d": 2}`, []string{"remove /b", "add /a"}},
+		{"escaped keys", `{"a/b": 1, "c~d": 2}`, `{"a/b": 3, "e/f~g": 4}`, []string{"remove /c~0d", "replace /a~1b", "add /e~1f~0g"}},
+		{"grown array", `{"a": [1, 2]}`, `{"a": [1, 2, 3, 4]}`, []string{"add /a/-", "add /a/-"}},
+		{"grown empty array", `{"a": []}`, `{"a": [{"b": 1}]}`, []string{"add /a/-"}},
+		{"shrunk array", `{"a": [1, 2, 3]}`, `{"a": [1, 2]}`, []string{"replace /a"}},
+		{"emptied array", `{"a": [1]}`, `{"a": []}`, []string{"replace /a"}},
+		{"changed array element", `{"a": [1, 2, 3]}`, `{"a": [1, 5, 3, 4]}`, []string{"replace /a"}},
+		{"null became object", `{"a": null}`, `{"a": {"b": 1}}`, []string{"replace /a"}},
+		{"object became array", `{"a": {"b": 1}}`, `{"a": [1]}`, []string{"replace /a"}},
+		{"replaced document", `[1, 2]`, `{"a": 1}`, []string{"replace "}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch := diffDocuments("", document(t, tt.from), document(t, tt.to), nil)
+
+			var ops []string
+			for _, op := range patch {
+				ops = append(ops, op.Op+" "+op.Path)
+			}
+			if !reflect.DeepEqual(ops, tt.wantOps) {
+				t.Errorf("operations = %q, want %q", ops, tt.wantOps)
+			}
+			if got, want := applyPatch(t, document(t, tt.from), patch), document(t, tt.to); !reflect.DeepEqual(got, want) {
+				t.Errorf("patched document = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestStatePatchRoundTrip(t *testing.T) {
+	sources := NewKey("sources", Append[string]())
+
+	tests := []struct {
+		name   string
+		before func(s *AppState)
+		change func(s *AppState)
+	}{
+		{
+			name:   "search results",
+			change: func(s *AppState) { s.AddSearchQuery("q1"); s.SetRawContent("Search_1/a~b", "result") },
+		},
+		{
+			name:   "report replaced",
+			before: func(s *AppState) { s.SetReport("draft") },
+			change: func(s *AppState) { s.SetReport("final") },
+		},
+		{
+			name:   "error cleared removes the key",
+			before: func(s *AppState) { s.SetError(errors.New("search failed")) },
+			change: func(s *AppState) { s.SetError(nil) },
+		},
+		{
+			name:   "custom key added and appended",
+			before: func(s *AppState) { Update(s, sources, []string{"a"}) },
+			change: func(s *AppState) {
+				Update(s, sources, []string{"b", "c"})
+				Update(s, NewKey("count", Replace[int]()), 3)
+			},
+		},
+		{
+			name:   "first custom key adds the channels",
+			change: func(s *AppState) { Update(s, sources, []string{"a"}) },
+		},
+		{
+			name:   "outline set",
+			change: func(s *AppState) { s.SetOutline([]string{"概要", "課題"}) },
+		},
+		{
+			name:   "outline cleared removes the key",
+			before: func(s *AppState) { s.SetOutline([]string{"概要"}) },
+			change: func(s *AppState) { s.SetOutline(nil) },
+		},
+		{
+			name:   "current node and merged branch writes",
+			before: func(s *AppState) { s.SetRawContent("Search_1", "result") },
+			change: func(s *AppState) {
+				s.setCurrentNode("merge")
+				branch := s.fork()
+				branch.SetRawContent("Search_2", "result")
+				Update(branch, sources, []string{"b"})
+				s.replayWrites(branch)
+			},
+		},
+		{
+			name: "state reset shrinks arrays and removes entries",
+			before: func(s *AppState) {
+				s.AddSearchQuery("q1")
+				s.AddSearchQuery("q2")
+				s.SetRawContent("Search_1", "result")
+			},
+			change: func(s *AppState) { s.reset(NewAppState("input")) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := NewAppState("input")
+			if tt.before != nil {
+				tt.before(state)
+			}
+			r := &runner{state: state}
+
+			// The first patch only sets the baseline a client receives in full
+			baseline, err := stateDocument(state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if patch, version := r.statePatch(); patch != nil || version != 1 {
+				t.Fatalf("baseline patch = %v at version %d, want none at version 1", patch, version)
+			}
+			if patch, version := r.statePatch(); patch != nil || version != 1 {
+				t.Fatalf("unchanged patch = %v at version %d, want none at version 1", patch, version)
+			}
+
+			tt.change(state)
+			patch, version := r.statePatch()
+			if len(patch) == 0 || version != 2 {
+				t.Fatalf("patch = %v at version %d, want changes at version 2", patch, version)
+			}
+
+			want, err := stateDocument(state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := applyPatch(t, baseline, patch); !reflect.DeepEqual(got, want) {
+				t.Errorf("patched state = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// countedValue counts how often it is serialized
+type countedValue struct{ serialized *int }
+
+func (v countedValue) MarshalJSON() ([]byte, error) {
+	*v.serialized++
+	return []byte(`"value"`), nil
+}
+
+func TestStatePatchSerializesWrittenMembersOnly(t *testing.T) {
+	serialized := 0
+	large := NewKey("large", Replace[countedValue]())
+	state := NewAppState("input")
+	Update(state, large, countedValue{&serialized})
+	r := &runner{state: state}
+	r.statePatch()
+	if serialized != 1 {
+		t.Fatalf("baseline serialized the key %d times, want once", serialized)
+	}
+
+	state.SetReport("report")
+	patch, version := r.statePatch()
+	if serialized != 1 {
+		t.Errorf("patch of the report serialized the unchanged key")
+	}
+	if want := []PatchOp{{Op: "replace", Path: "/report", Value: "report"}}; !reflect.DeepEqual(patch, want) || version != 2 {
+		t.Errorf("patch = %v at version %d, want %v at version 2", patch, version, want)
+	}
+
+	// Writing a key serializes it again, even when its value did not change
+	Update(state, large, countedValue{&serialized})
+	if patch, version := r.statePatch(); patch != nil || version != 2 || serialized != 2 {
+		t.Errorf("patch = %v at version %d after serializing the key %d times, want none at version 2 after twice", patch, version, serialized)
+	}
+}
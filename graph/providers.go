@@ -0,0 +1,187 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/anthropic"
+	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/tmc/langchaingo/llms/openai"
+)
+
+// LLM providers a Provider can create models of
+const (
+	ProviderOpenAI           = "openai"
+	ProviderAnthropic        = "anthropic"
+	ProviderOllama           = "ollama"
+	ProviderOpenAICompatible = "openai_compatible" // any server implementing the OpenAI API
+	ProviderFake             = "fake"              // FakeModel answering from DemoScript
+)
+
+// Provider describes the LLM backend the nodes call
+type Provider struct {
+	Name    string // one of the Provider* constants; "" is OpenAI
+	APIKey  string // optional for Ollama and OpenAI-compatible servers
+	BaseURL string // overrides the provider's endpoint; required for OpenAI-compatible servers
+}
+
+// defaultModels are used when no model is given
+var defaultModels = map[string]string{
+	ProviderOpenAI:    defaultModel,
+	ProviderAnthropic: "claude-3-5-sonnet-20241022",
+	ProviderFake:      ProviderFake,
+}
+
+// Validate reports whether the provider is known and has what it needs to connect
+func (p Provider) Validate() error {
+	switch p.Name {
+	case "", ProviderOpenAI, ProviderAnthropic:
+		if p.APIKey == "" {
+			return fmt.Errorf("provider %s requires an API key", p.name())
+		}
+	case ProviderOllama, ProviderFake:
+	case ProviderOpenAICompatible:
+		if p.BaseURL == "" {
+			return fmt.Errorf("provider %s requires a base URL", p.Name)
+		}
+	default:
+		return fmt.Errorf("unknown LLM provider %q", p.Name)
+	}
+	return nil
+}
+
+// Model returns model, or the provider's default model when it is empty
+func (p Provider) Model(model string) (string, error) {
+	if model != "" {
+		return model, nil
+	}
+	if model, ok := defaultModels[p.name()]; ok {
+		return model, nil
+	}
+	return "", fmt.Errorf("provider %s requires a model", p.name())
+}
+
+// NewModel creates a client of the provider for model
+func (p Provider) NewModel(model string) (llms.Model, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	model, err := p.Model(model)
+	if err != nil {
+		return nil, err
+	}
+
+	var llm llms.Model
+	switch p.name() {
+	case ProviderOpenAI, ProviderOpenAICompatible:
+		opts := []openai.Option{openai.WithModel(model)}
+		// OpenAI-compatible servers often ignore the key, but the client requires one
+		apiKey := p.APIKey
+		if apiKey == "" {
+			apiKey = "none"
+		}
+		opts = append(opts, openai.WithToken(apiKey))
+		if p.BaseURL != "" {
+			opts = append(opts, openai.WithBaseURL(p.BaseURL))
+		}
+		llm, err = openai.New(opts...)
+	case ProviderAnthropic:
+		opts := []anthropic.Option{anthropic.WithToken(p.APIKey), anthropic.WithModel(model)}
+		if p.BaseURL != "" {
+			opts = append(opts, anthropic.WithBaseURL(p.BaseURL))
+		}
+		llm, err = anthropic.New(opts...)
+	case ProviderOllama:
+		opts := []ollama.Option{ollama.WithModel(model)}
+		if p.BaseURL != "" {
+			opts = append(opts, ollama.WithServerURL(p.BaseURL))
+		}
+		llm, err = ollama.New(opts...)
+	case ProviderFake:
+		// Stream slowly enough for demos to show progress
+		fake := NewFakeModel()
+		fake.Latency = 20 * time.Millisecond
+		llm = fake
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s model %s: %w", p.name(), model, err)
+	}
+	return llm, nil
+}
+
+func (p Provider) name() string {
+	if p.Name == "" {
+		return ProviderOpenAI
+	}
+	return p.Name
+}
+
+// NodeModel selects the model a node calls and how it samples
+type NodeModel struct {
+	LLM         llms.Model // nil keeps the engine's model
+	Model       string     // names LLM in usage, prices and spans
+	Temperature *float64   // nil keeps the model's default
+	MaxTokens   int        // 0 keeps the model's default
+}
+
+// WithNodeModel makes a node (or the node of a branch point) call its own model,
+// e.g. a cheap one for classification, or sample with its own temperature and token limit
+func WithNodeModel(node string, model NodeModel) EngineOption {
+	return func(e *Engine) {
+		e.nodeModels[node] = model
+	}
+}
+
+func (m NodeModel) validate(node string) error {
+	if m.LLM != nil && m.Model == "" {
+		return fmt.Errorf("model of node %s has no name", node)
+	}
+	if m.Temperature != nil && *m.Temperature < 0 {
+		return fmt.Errorf("temperature of node %s must not be negative", node)
+	}
+	if m.MaxTokens < 0 {
+		return fmt.Errorf("max tokens of node %s must not be negative", node)
+	}
+	return nil
+}
+
+// callOptions are the sampling options the node's calls start from
+func (m NodeModel) callOptions() []llms.CallOption {
+	var opts []llms.CallOption
+	if m.Temperature != nil {
+		opts = append(opts, llms.WithTemperature(*m.Temperature))
+	}
+	if m.MaxTokens > 0 {
+		opts = append(opts, llms.WithMaxTokens(m.MaxTokens))
+	}
+	return opts
+}
+
+// nodeModel is a node's model, already metered and traced, and its sampling options
+type nodeModel struct {
+	llm     llms.Model // nil uses the registry's model
+	options []llms.CallOption
+}
+
+type nodeKeyContextKey struct{}
+
+// withNodeKey attaches the registered name of the executing node, which selects its model
+func withNodeKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, nodeKeyContextKey{}, key)
+}
+
+// optionsModel applies sampling options before those of each call
+type optionsModel struct {
+	llms.Model
+	options []llms.CallOption
+}
+
+func (m optionsModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	return m.Model.GenerateContent(ctx, messages, append(append([]llms.CallOption(nil), m.options...), options...)...)
+}
+
+func (m optionsModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
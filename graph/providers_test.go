@@ -0,0 +1,233 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/anthropic"
+	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/tmc/langchaingo/llms/openai"
+)
+
+func TestProviderNewModel(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		model    string
+		want     llms.Model // type of the created model
+		wantErr  string
+	}{
+		{name: "openai by default", provider: Provider{APIKey: "key"}, want: &openai.LLM{}},
+		{name: "openai", provider: Provider{Name: ProviderOpenAI, APIKey: "key"}, model: "gpt-4o", want: &openai.LLM{}},
+		{name: "openai without key", provider: Provider{Name: ProviderOpenAI}, wantErr: "provider openai requires an API key"},
+		{name: "anthropic", provider: Provider{Name: ProviderAnthropic, APIKey: "key"}, want: &anthropic.LLM{}},
+		{name: "anthropic without key", provider: Provider{Name: ProviderAnthropic}, wantErr: "provider anthropic requires an API key"},
+		{name: "ollama", provider: Provider{Name: ProviderOllama}, model: "llama3.2", want: &ollama.LLM{}},
+		{name: "ollama without model", provider: Provider{Name: ProviderOllama}, wantErr: "provider ollama requires a model"},
+		{
+			name:     "openai compatible",
+			provider: Provider{Name: ProviderOpenAICompatible, BaseURL: "http://localhost:8000/v1"},
+			model:    "qwen2.5",
+			want:     &openai.LLM{},
+		},
+		{
+			name:     "openai compatible without base URL",
+			provider: Provider{Name: ProviderOpenAICompatible},
+			model:    "qwen2.5",
+			wantErr:  "provider openai_compatible requires a base URL",
+		},
+		{name: "fake", provider: Provider{Name: ProviderFake}, want: &FakeModel{}},
+		{name: "unknown", provider: Provider{Name: "palm"}, wantErr: `unknown LLM provider "palm"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm, err := tt.provider.NewModel(tt.model)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewModel() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewModel() error = %v", err)
+			}
+			if got, want := fmt.Sprintf("%T", llm), fmt.Sprintf("%T", tt.want); got != want {
+				t.Errorf("NewModel() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestProviderModel(t *testing.T) {
+	tests := []struct {
+		provider string
+		model    string
+		want     string
+	}{
+		{"", "", defaultModel},
+		{ProviderOpenAI, "", defaultModel},
+		{ProviderAnthropic, "", "claude-3-5-sonnet-20241022"},
+		{ProviderFake, "", ProviderFake},
+		{ProviderOllama, "llama3.2", "llama3.2"},
+		{ProviderAnthropic, "claude-3-5-haiku-20241022", "claude-3-5-haiku-20241022"},
+	}
+	for _, tt := range tests {
+		got, err := Provider{Name: tt.provider}.Model(tt.model)
+		if err != nil || got != tt.want {
+			t.Errorf("Provider{%q}.Model(%q) = %q, %v, want %q", tt.provider, tt.model, got, err, tt.want)
+		}
+	}
+}
+
+func TestOpenAICompatibleProvider(t *testing.T) {
+	var request struct {
+		Model string `json:"model"`
+	}
+	var path, authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, authorization = r.URL.Path, r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"id": "chatcmpl-1", "object": "chat.completion", "model": "qwen2.5",
+			"choices": [{"index": 0, "message": {"role": "assistant", "content": "こんにちは"}, "finish_reason": "stop"}],
+			"usage": {"prompt_tokens": 3, "completion_tokens": 2, "total_tokens": 5}
+		}`))
+	}))
+	defer server.Close()
+
+	// The server is called with the configured model, without an API key of its own
+	llm, err := Provider{Name: ProviderOpenAICompatible, BaseURL: server.URL + "/v1"}.NewModel("qwen2.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, err := llms.GenerateFromSinglePrompt(context.Background(), llm, "挨拶して")
+	if err != nil {
+		t.Fatalf("GenerateFromSinglePrompt() error = %v", err)
+	}
+	if answer != "こんにちは" {
+		t.Errorf("answer = %q, want こんにちは", answer)
+	}
+	if path != "/v1/chat/completions" || request.Model != "qwen2.5" || authorization != "Bearer none" {
+		t.Errorf("request to %s for model %q with %q, want /v1/chat/completions for qwen2.5 with Bearer none", path, request.Model, authorization)
+	}
+}
+
+// samplingModel records the temperature and token limit of each call by the
+// first line of its prompt
+type samplingModel struct {
+	*FakeModel
+	mu       sync.Mutex
+	sampling map[string]sampling
+}
+
+type sampling struct {
+	temperature float64
+	maxTokens   int
+}
+
+func newSamplingModel() *samplingModel {
+	return &samplingModel{FakeModel: NewFakeModel(), sampling: map[string]sampling{}}
+}
+
+func (m *samplingModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	opts := llms.CallOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	text := messages[len(messages)-1].Parts[0].(llms.TextContent).Text
+	m.mu.Lock()
+	m.sampling[strings.SplitN(text, "\n", 2)[0]] = sampling{opts.Temperature, opts.MaxTokens}
+	m.mu.Unlock()
+	return m.FakeModel.GenerateContent(ctx, messages, options...)
+}
+
+func (m *samplingModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+func TestNodeModels(t *testing.T) {
+	main, cheap := newSamplingModel(), NewFakeModel()
+	temperature := 0.2
+	engine, err := NewEngine("", "", WithLLM(main, "main"),
+		WithPrices(map[string]ModelPrice{"main": {Prompt: 10, Completion: 10}, "cheap": {Prompt: 1, Completion: 1}}),
+		// The branches of search_query summarize with the cheap model
+		WithNodeModel("search_query", NodeModel{LLM: cheap, Model: "cheap"}),
+		WithNodeModel("synthesize_and_report", NodeModel{Temperature: &temperature, MaxTokens: 500}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := engine.Execute(context.Background(), "LLMの最新動向を調べて")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	if got := len(cheap.Prompts()); got != 5 {
+		t.Errorf("cheap model prompted %d times, want once per search", got)
+	}
+	for _, prompt := range main.Prompts() {
+		if strings.Contains(prompt, "要約を日本語で") {
+			t.Errorf("main model summarized a search: %q", prompt)
+		}
+	}
+
+	// Usage is priced by the model each node called
+	for node, usage := range result.Usage.Nodes {
+		price := 10.0
+		if strings.HasPrefix(node, "search_query_") {
+			price = 1
+		}
+		if want := float64(usage.TotalTokens()) * price / 1e6; math.Abs(usage.Cost-want) > 1e-12 {
+			t.Errorf("cost of %s = %v, want %v", node, usage.Cost, want)
+		}
+	}
+
+	// Only the report samples with its own options
+	reported := false
+	for prompt, got := range main.sampling {
+		want := sampling{}
+		if strings.Contains(prompt, "同じ書式で") {
+			want, reported = sampling{temperature, 500}, true
+		}
+		if got != want {
+			t.Errorf("call %q sampled with %+v, want %+v", prompt, got, want)
+		}
+	}
+	if !reported {
+		t.Error("main model did not write the report")
+	}
+}
+
+func TestNodeModelErrors(t *testing.T) {
+	negative := -0.5
+	tests := []struct {
+		node    string
+		model   NodeModel
+		wantErr string
+	}{
+		{"search_query", NodeModel{LLM: NewFakeModel()}, "model of node search_query has no name"},
+		{"search_query", NodeModel{Temperature: &negative}, "temperature of node search_query must not be negative"},
+		{"search_query", NodeModel{MaxTokens: -1}, "max tokens of node search_query must not be negative"},
+		{"summarize", NodeModel{MaxTokens: 100}, "model configured for unknown node summarize"},
+	}
+	for _, tt := range tests {
+		_, err := NewEngine("", "", WithFakeLLM(), WithNodeModel(tt.node, tt.model))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("NewEngine() error = %v, want %q", err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/takako/openai-go-demo/tools"
+)
+
+// Retryable error classes used by RetryDefinition.RetryOn
+const (
+	RetryOnTimeout     = "timeout"      // node timeouts and deadlines exceeded inside the node
+	RetryOnRateLimit   = "rate_limit"   // HTTP 429
+	RetryOnServerError = "server_error" // HTTP 5xx
+	RetryOnNetwork     = "network"      // connection failures
+)
+
+// RetryPolicy controls how the engine retries a failing node
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	// Backoff is the delay before the first retry
+	Backoff time.Duration
+	// MaxBackoff caps the delay between attempts (0 = no cap)
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after every retry (defaults to 2)
+	Multiplier float64
+	// Jitter randomizes each delay by up to this fraction (0.2 = ±20%)
+	Jitter float64
+	// Retryable reports whether an error is worth retrying (defaults to IsTransient)
+	Retryable func(error) bool
+}
+
+// RetryDefinition declares a retry policy in a graph definition
+type RetryDefinition struct {
+	MaxAttempts int     `yaml:"max_attempts" json:"max_attempts"`
+	Backoff     string  `yaml:"backoff,omitempty" json:"backoff,omitempty"`         // e.g. "500ms"
+	MaxBackoff  string  `yaml:"max_backoff,omitempty" json:"max_backoff,omitempty"` // e.g. "10s"
+	Multiplier  float64 `yaml:"multiplier,omitempty" json:"multiplier,omitempty"`
+	Jitter      float64 `yaml:"jitter,omitempty" json:"jitter,omitempty"`
+	// RetryOn lists the retryable error classes (default: all of them)
+	RetryOn []string `yaml:"retry_on,omitempty" json:"retry_on,omitempty"`
+}
+
+// clone returns a copy of the declaration; nil stays nil
+func (d *RetryDefinition) clone() *RetryDefinition {
+	if d == nil {
+		return nil
+	}
+	clone := *d
+	clone.RetryOn = append([]string(nil), d.RetryOn...)
+	return &clone
+}
+
+// Policy converts the declaration into a retry policy
+func (d *RetryDefinition) Policy() (RetryPolicy, error) {
+	policy := RetryPolicy{
+		MaxAttempts: d.MaxAttempts,
+		Multiplier:  d.Multiplier,
+		Jitter:      d.Jitter,
+	}
+
+	var err error
+	if d.Backoff != "" {
+		if policy.Backoff, err = time.ParseDuration(d.Backoff); err != nil {
+			return RetryPolicy{}, fmt.Errorf("invalid backoff %q: %w", d.Backoff, err)
+		}
+	}
+	if d.MaxBackoff != "" {
+		if policy.MaxBackoff, err = time.ParseDuration(d.MaxBackoff); err != nil {
+			return RetryPolicy{}, fmt.Errorf("invalid max_backoff %q: %w", d.MaxBackoff, err)
+		}
+	}
+
+	switch {
+	case d.MaxAttempts < 1:
+		return RetryPolicy{}, fmt.Errorf("max_attempts must be at least 1")
+	case policy.Backoff < 0 || policy.MaxBackoff < 0:
+		return RetryPolicy{}, fmt.Errorf("backoff must not be negative")
+	case d.Multiplier < 0:
+		return RetryPolicy{}, fmt.Errorf("multiplier must not be negative")
+	case d.Jitter < 0 || d.Jitter > 1:
+		return RetryPolicy{}, fmt.Errorf("jitter must be between 0 and 1")
+	}
+
+	if len(d.RetryOn) > 0 {
+		classes := make(map[string]bool)
+		for _, class := range d.RetryOn {
+			switch class {
+			case RetryOnTimeout, RetryOnRateLimit, RetryOnServerError, RetryOnNetwork:
+				classes[class] = true
+			default:
+				return RetryPolicy{}, fmt.Errorf("unknown retry_on class %q", class)
+			}
+		}
+		policy.Retryable = func(err error) bool {
+			return classes[ErrorClass(err)]
+		}
+	}
+
+	return policy, nil
+}
+
+// WithRetryPolicy retries a node (or the node of a branch point) according to policy.
+// It overrides any retry declared in the graph definition.
+func WithRetryPolicy(node string, policy RetryPolicy) EngineOption {
+	return func(e *Engine) {
+		e.retryPolicies[node] = policy
+	}
+}
+
+// statusCodePattern finds HTTP status codes in errors of clients that only report them as text
+var statusCodePattern = regexp.MustCompile(`status(?: code)?:? (\d{3})`)
+
+// ErrorClass classifies an error into a retryable class, or "" when it is not transient
+func ErrorClass(err error) string {
+	var statusErr *tools.StatusError
+	status := 0
+	if errors.As(err, &statusErr) {
+		status = statusErr.StatusCode
+	} else if match := statusCodePattern.FindStringSubmatch(err.Error()); match != nil {
+		status, _ = strconv.Atoi(match[1])
+	}
+
+	var netErr net.Error
+	switch {
+	case status == 429:
+		return RetryOnRateLimit
+	case status >= 500:
+		return RetryOnServerError
+	case errors.Is(err, context.DeadlineExceeded):
+		return RetryOnTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return RetryOnTimeout
+	case errors.As(err, &netErr):
+		return RetryOnNetwork
+	default:
+		return ""
+	}
+}
+
+// IsTransient reports whether an error is likely to go away on retry
+func IsTransient(err error) bool {
+	return ErrorClass(err) != ""
+}
+
+// retryable reports whether err should be retried under the policy
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsTransient(err)
+}
+
+// delay returns the wait before the given retry (1 = first retry)
+func (p RetryPolicy) delay(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	delay := float64(p.Backoff) * math.Pow(multiplier, float64(retry-1))
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		delay *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay)
+}
+
+// withRetry runs attempt until it succeeds, fails permanently or the policy is exhausted.
+// onRetry is called before every retry with the attempt about to start.
+func withRetry(ctx context.Context, policy RetryPolicy, attempt func() error, onRetry func(attempt int, err error)) error {
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+		// The run itself was cancelled or ran out of time; retrying cannot help
+		if ctx.Err() != nil || n >= maxAttempts || !policy.retryable(err) {
+			if n > 1 {
+				return fmt.Errorf("after %d attempts: %w", n, err)
+			}
+			return err
+		}
+
+		onRetry(n+1, err)
+		delay := policy.delay(n)
+		slog.WarnContext(ctx, "🔁 Retrying", "delay", delay, "attempt", n+1, "max_attempts", maxAttempts, "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("retry aborted: %w", ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
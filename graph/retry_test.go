@@ -0,0 +1,276 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/takako/openai-go-demo/tools"
+)
+
+// timeoutError is a net.Error reporting whether it timed out
+type timeoutError struct{ timeout bool }
+
+func (e timeoutError) Error() string   { return "i/o failure" }
+func (e timeoutError) Timeout() bool   { return e.timeout }
+func (e timeoutError) Temporary() bool { return false }
+
+var _ net.Error = timeoutError{}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"status error 429", &tools.StatusError{StatusCode: 429}, RetryOnRateLimit},
+		{"status error 503", fmt.Errorf("search: %w", &tools.StatusError{StatusCode: 503}), RetryOnServerError},
+		{"status error 404", &tools.StatusError{StatusCode: 404}, ""},
+		{"status code in text", errors.New("API returned unexpected status code: 429"), RetryOnRateLimit},
+		{"status in text", errors.New("request failed, status: 502"), RetryOnServerError},
+		{"client error in text", errors.New("status code: 400"), ""},
+		{"deadline exceeded", fmt.Errorf("node search_query: %w", context.DeadlineExceeded), RetryOnTimeout},
+		{"network timeout", &net.OpError{Op: "dial", Err: timeoutError{timeout: true}}, RetryOnTimeout},
+		{"network failure", &net.OpError{Op: "dial", Err: timeoutError{}}, RetryOnNetwork},
+		{"cancelled", context.Canceled, ""},
+		{"plain error", errors.New("invalid JSON"), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorClass(tt.err); got != tt.want {
+				t.Errorf("ErrorClass(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+			if got := IsTransient(tt.err); got != (tt.want != "") {
+				t.Errorf("IsTransient(%v) = %v, want %v", tt.err, got, tt.want != "")
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		retry  int
+		want   time.Duration
+	}{
+		{"first retry waits the backoff", RetryPolicy{Backoff: 100 * time.Millisecond}, 1, 100 * time.Millisecond},
+		{"multiplier defaults to 2", RetryPolicy{Backoff: 100 * time.Millisecond}, 3, 400 * time.Millisecond},
+		{"custom multiplier", RetryPolicy{Backoff: 100 * time.Millisecond, Multiplier: 3}, 3, 900 * time.Millisecond},
+		{"multiplier 1 is constant", RetryPolicy{Backoff: 100 * time.Millisecond, Multiplier: 1}, 5, 100 * time.Millisecond},
+		{"capped by max backoff", RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 250 * time.Millisecond}, 3, 250 * time.Millisecond},
+		{"below max backoff", RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 250 * time.Millisecond}, 2, 200 * time.Millisecond},
+		{"no backoff", RetryPolicy{}, 4, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.delay(tt.retry); got != tt.want {
+				t.Errorf("delay(%d) = %v, want %v", tt.retry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelayJitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		retry    int
+		min, max time.Duration
+	}{
+		{"±20% of the backoff", RetryPolicy{Backoff: time.Second, Jitter: 0.2}, 1, 800 * time.Millisecond, 1200 * time.Millisecond},
+		{"±50% of the grown delay", RetryPolicy{Backoff: time.Second, Jitter: 0.5}, 2, time.Second, 3 * time.Second},
+		// Jitter applies after capping, so delays may exceed MaxBackoff by the jitter fraction
+		{"jitter around the cap", RetryPolicy{Backoff: time.Second, MaxBackoff: 2 * time.Second, Jitter: 0.1}, 5, 1800 * time.Millisecond, 2200 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			varied := false
+			first := tt.policy.delay(tt.retry)
+			for i := 0; i < 100; i++ {
+				got := tt.policy.delay(tt.retry)
+				if got < tt.min || got > tt.max {
+					t.Fatalf("delay(%d) = %v, want within [%v, %v]", tt.retry, got, tt.min, tt.max)
+				}
+				varied = varied || got != first
+			}
+			if !varied {
+				t.Errorf("delay(%d) = %v on every call, want jittered delays", tt.retry, first)
+			}
+		})
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	transient := &tools.StatusError{StatusCode: 503}
+	fatal := errors.New("invalid request")
+
+	tests := []struct {
+		name         string
+		policy       RetryPolicy
+		errs         []error // returned by successive attempts; nil afterwards
+		wantAttempts int
+		wantErr      error
+		wantPrefix   bool // the error reports the number of attempts
+	}{
+		{"success needs no retry", RetryPolicy{MaxAttempts: 3}, nil, 1, nil, false},
+		{"recovers from transient errors", RetryPolicy{MaxAttempts: 3}, []error{transient, transient}, 3, nil, false},
+		{"exhausts the attempts", RetryPolicy{MaxAttempts: 3}, []error{transient, transient, transient, transient}, 3, transient, true},
+		{"non-retryable short-circuits", RetryPolicy{MaxAttempts: 3}, []error{fatal}, 1, fatal, false},
+		{"non-retryable after a retry", RetryPolicy{MaxAttempts: 5}, []error{transient, fatal}, 2, fatal, true},
+		{"zero attempts runs once", RetryPolicy{}, []error{transient}, 1, transient, false},
+		{
+			"custom retryable",
+			RetryPolicy{MaxAttempts: 3, Retryable: func(err error) bool { return errors.Is(err, fatal) }},
+			[]error{fatal, transient},
+			2, transient, true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			var retries []int
+			err := withRetry(context.Background(), tt.policy, func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+				return nil
+			}, func(attempt int, err error) {
+				retries = append(retries, attempt)
+			})
+
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if len(retries) != tt.wantAttempts-1 {
+				t.Errorf("onRetry called %d times, want %d", len(retries), tt.wantAttempts-1)
+			}
+			for i, attempt := range retries {
+				if attempt != i+2 {
+					t.Errorf("onRetry attempt = %d, want %d", attempt, i+2)
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if want := fmt.Sprintf("after %d attempts: ", attempts); tt.wantPrefix && err.Error() != want+tt.wantErr.Error() {
+				t.Errorf("error = %q, want %q", err, want+tt.wantErr.Error())
+			}
+		})
+	}
+}
+
+func TestWithRetryStopsWhenRunIsDone(t *testing.T) {
+	t.Run("timed out attempt", func(t *testing.T) {
+		// A node timeout is retryable, but not once the run's own deadline has passed
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		<-ctx.Done()
+
+		attempts := 0
+		err := withRetry(ctx, RetryPolicy{MaxAttempts: 3}, func() error {
+			attempts++
+			return ctx.Err()
+		}, func(int, error) {})
+
+		if attempts != 1 {
+			t.Errorf("attempts = %d, want 1", attempts)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("cancelled during backoff", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		attempts := 0
+		err := withRetry(ctx, RetryPolicy{MaxAttempts: 3, Backoff: time.Hour}, func() error {
+			attempts++
+			return &tools.StatusError{StatusCode: 429}
+		}, func(int, error) { cancel() })
+
+		if attempts != 1 {
+			t.Errorf("attempts = %d, want 1", attempts)
+		}
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("error = %v, want %v", err, context.Canceled)
+		}
+	})
+
+	t.Run("node timeout is retried", func(t *testing.T) {
+		attempts := 0
+		err := withRetry(context.Background(), RetryPolicy{MaxAttempts: 3}, func() error {
+			attempts++
+			if attempts == 1 {
+				// What runNode returns when the node's own timeout expires
+				nodeCtx, cancel := context.WithTimeout(context.Background(), 0)
+				defer cancel()
+				return nodeCtx.Err()
+			}
+			return nil
+		}, func(int, error) {})
+
+		if err != nil || attempts != 2 {
+			t.Errorf("withRetry = %v after %d attempts, want success after 2", err, attempts)
+		}
+	})
+}
+
+func TestRetryDefinitionPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		def       RetryDefinition
+		wantErr   string
+		retryable map[error]bool
+	}{
+		{
+			name:      "defaults retry every transient class",
+			def:       RetryDefinition{MaxAttempts: 2},
+			retryable: map[error]bool{context.DeadlineExceeded: true, &tools.StatusError{StatusCode: 429}: true, errors.New("bad input"): false},
+		},
+		{
+			name:      "retry_on limits the classes",
+			def:       RetryDefinition{MaxAttempts: 2, RetryOn: []string{RetryOnRateLimit}},
+			retryable: map[error]bool{&tools.StatusError{StatusCode: 429}: true, &tools.StatusError{StatusCode: 500}: false, context.DeadlineExceeded: false},
+		},
+		{
+			name:      "timeouts only",
+			def:       RetryDefinition{MaxAttempts: 2, RetryOn: []string{RetryOnTimeout}},
+			retryable: map[error]bool{context.DeadlineExceeded: true, &net.OpError{Op: "read", Err: timeoutError{}}: false},
+		},
+		{name: "no attempts", def: RetryDefinition{}, wantErr: "max_attempts must be at least 1"},
+		{name: "invalid backoff", def: RetryDefinition{MaxAttempts: 2, Backoff: "soon"}, wantErr: `invalid backoff "soon"`},
+		{name: "negative backoff", def: RetryDefinition{MaxAttempts: 2, MaxBackoff: "-1s"}, wantErr: "backoff must not be negative"},
+		{name: "jitter above 1", def: RetryDefinition{MaxAttempts: 2, Jitter: 1.5}, wantErr: "jitter must be between 0 and 1"},
+		{name: "unknown class", def: RetryDefinition{MaxAttempts: 2, RetryOn: []string{"always"}}, wantErr: `unknown retry_on class "always"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := tt.def.Policy()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Policy() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Policy() error = %v", err)
+			}
+			for err, want := range tt.retryable {
+				if got := policy.retryable(err); got != want {
+					t.Errorf("retryable(%v) = %v, want %v", err, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,561 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// runner holds the bookkeeping of a single graph run.
+// Execute and StreamExecute share it; emit is nil when nobody observes the run.
+type runner struct {
+	engine    *Engine
+	runID     string
+	state     *AppState
+	emit      func(GraphUpdate)
+	chunks    func(GraphUpdate) // sends streaming chunks; nil when nobody observes them
+	startTime time.Time
+	// scope is the node path of the parent node when running as a subgraph
+	scope []string
+	// deadline of the run, zero without a run timeout
+	deadline time.Time
+
+	mu     sync.Mutex
+	path   []string
+	steps  int
+	visits map[string]int
+
+	// last successful checkpoint, reused to record failures
+	last *Checkpoint
+	// interrupt the run continues from, if any
+	resumed *Interrupt
+	// stops the run, set while it is active
+	cancel context.CancelFunc
+	// run and step a forked run branched off from
+	parentRunID string
+	parentStep  int
+	// tokens and cost of the run, shared with its subgraphs
+	meter *usageMeter
+
+	// state as of the latest update and its version, the base of the next patch
+	emitted interface{}
+	version int
+}
+
+// newRunner prepares a fresh run over the given state
+func (e *Engine) newRunner(state *AppState, emit func(GraphUpdate)) *runner {
+	return &runner{
+		engine:    e,
+		runID:     uuid.NewString(),
+		state:     state,
+		emit:      emit,
+		startTime: time.Now(),
+		meter:     newUsageMeter(e, nil),
+	}
+}
+
+// restore continues the bookkeeping of a checkpointed run
+func (r *runner) restore(checkpoint *Checkpoint) {
+	r.runID = checkpoint.RunID
+	r.path = append([]string(nil), checkpoint.Path...)
+	r.steps = checkpoint.Step
+	r.visits = checkpoint.Visits
+	r.last = checkpoint
+	r.resumed = checkpoint.Interrupt
+	r.parentRunID = checkpoint.ParentRunID
+	r.parentStep = checkpoint.ParentStep
+	r.meter = newUsageMeter(r.engine, checkpoint.Usage)
+}
+
+// run executes the graph from currentNode until a terminal node is reached
+func (e *Engine) run(ctx context.Context, r *runner, currentNode string) (result *ExecutionResult, err error) {
+	metrics.runStarted(ctx)
+	ctx, span := e.startRunSpan(ctx, r, currentNode)
+	defer func() {
+		endRunSpan(span, result, err)
+		metrics.runFinished(ctx, result, err)
+	}()
+
+	ctx, recorder := e.startTrace(ctx, r)
+	if recorder != nil {
+		defer func() { e.finishTrace(recorder, err) }()
+	}
+
+	ctx = r.withMeter(ctx)
+	ctx = withLogRun(ctx, r.runID)
+	ctx, cancel := r.withRunDeadline(ctx)
+	defer cancel()
+	defer e.track(r, cancel)()
+	r.observeChunks(ctx)
+
+	r.notify("start", currentNode, nil)
+	if r.last == nil {
+		r.saveCheckpoint(ctx, "", currentNode)
+	}
+
+	// A run interrupted after a node still has to leave that node
+	if r.resumed != nil && r.resumed.When == InterruptAfter {
+		nextNode, err := r.advance(ctx, r.resumed.Node)
+		if err != nil {
+			return r.fail(ctx, r.resumed.Node, err)
+		}
+		r.saveCheckpoint(ctx, r.resumed.Node, nextNode)
+		currentNode = nextNode
+	}
+
+	// Execute the graph
+	for currentNode != "" && r.steps < e.maxSteps {
+		// Stop between nodes once the run is cancelled or out of time
+		if err := ctx.Err(); err != nil {
+			if r.deadlineExceeded(ctx) {
+				err = &TimeoutError{Scope: TimeoutRun, Node: currentNode, Timeout: e.runTimeout}
+			}
+			return r.fail(ctx, currentNode, err)
+		}
+
+		if r.interruptsBefore(currentNode) {
+			return r.interrupt(ctx, Interrupt{Node: currentNode, When: InterruptBefore})
+		}
+
+		// An aborting budget stops the run before it spends more
+		if err := r.meter.check(); err != nil {
+			return r.fail(ctx, currentNode, err)
+		}
+
+		// Loops are bounded by each node's visit limit
+		if err := r.visit(currentNode); err != nil {
+			return r.fail(ctx, currentNode, err)
+		}
+
+		if err := r.executeNode(ctx, currentNode); err != nil {
+			return r.fail(ctx, currentNode, err)
+		}
+		r.steps++
+
+		if e.interruptAfter[currentNode] {
+			return r.interrupt(ctx, Interrupt{Node: currentNode, When: InterruptAfter})
+		}
+
+		nextNode, err := r.advance(ctx, currentNode)
+		if err != nil {
+			return r.fail(ctx, currentNode, err)
+		}
+
+		r.saveCheckpoint(ctx, currentNode, nextNode)
+		currentNode = nextNode
+	}
+
+	// Check if we hit the step limit
+	if currentNode != "" {
+		return r.fail(ctx, currentNode, fmt.Errorf("%w (%d)", ErrMaxSteps, e.maxSteps))
+	}
+
+	// Send completion update
+	r.notify("complete", "", nil)
+	return r.result(), nil
+}
+
+// executeNode runs a single node against the run's state
+func (r *runner) executeNode(ctx context.Context, name string) error {
+	slog.InfoContext(ctx, "Executing node", "node", name)
+	r.appendPath(name)
+
+	// Update current node in state
+	r.state.setCurrentNode(name)
+	r.notify("node_start", name, nil)
+
+	// Get and execute the node
+	node, exists := r.engine.nodeRegistry.GetNode(name)
+	if !exists {
+		return fmt.Errorf("node %s not found", name)
+	}
+	// Retries start over from the state the node was entered with
+	policy, snapshot := r.retryPolicy(name, r.state)
+	err := withRetry(ctx, policy, func() error {
+		return r.runNode(r.withRunScope(ctx, name), name, name, node, r.state)
+	}, func(attempt int, err error) {
+		r.state.reset(snapshot)
+		r.notifyRetry(name, r.state, attempt, err)
+	})
+	if err != nil {
+		return fmt.Errorf("node %s failed: %w", name, err)
+	}
+
+	r.notify("node_complete", name, nil)
+	return nil
+}
+
+// retryPolicy returns the retry policy of key and, when it allows retries, a snapshot
+// of state for them to start over from; nodes without retries skip the clone
+func (r *runner) retryPolicy(key string, state *AppState) (RetryPolicy, *AppState) {
+	policy, exists := r.engine.retryPolicies[key]
+	if !exists || policy.MaxAttempts <= 1 {
+		return policy, nil
+	}
+	return policy, state.Clone()
+}
+
+// advance resolves the successor of a completed node, running any branch point on the way
+func (r *runner) advance(ctx context.Context, name string) (string, error) {
+	// Determine next node
+	nextNode, err := r.engine.flow.GetNextNode(ctx, name, r.state, r.engine.edgeRegistry)
+	if err != nil {
+		return "", fmt.Errorf("edge decision failed after %s: %w", name, err)
+	}
+	r.edge(ctx, name, nextNode)
+
+	// Check for dynamic branching signal
+	if !strings.HasPrefix(nextNode, BranchPrefix) {
+		return nextNode, nil
+	}
+
+	branchName := strings.TrimPrefix(nextNode, BranchPrefix)
+	branch, exists := r.engine.flow.Branches[branchName]
+	if !exists {
+		return "", fmt.Errorf("unknown branch type: %s", branchName)
+	}
+	if err := r.executeBranches(ctx, branch); err != nil {
+		return "", err
+	}
+
+	// After branching, go to the join node
+	r.edge(ctx, nextNode, branch.Join)
+	return branch.Join, nil
+}
+
+// interruptsBefore reports whether the run must pause before executing node.
+// A run resumed from that very interrupt proceeds.
+func (r *runner) interruptsBefore(node string) bool {
+	if !r.engine.interruptBefore[node] {
+		return false
+	}
+	if r.resumed != nil && r.resumed.When == InterruptBefore && r.resumed.Node == node {
+		r.resumed = nil
+		return false
+	}
+	return true
+}
+
+// interrupt suspends the run, persisting its pending state for Resume
+func (r *runner) interrupt(ctx context.Context, interrupt Interrupt) (*ExecutionResult, error) {
+	slog.InfoContext(ctx, "⏸️  Run interrupted", "when", interrupt.When, "node", interrupt.Node)
+
+	r.mu.Lock()
+	path := append([]string(nil), r.path...)
+	visits := r.copyVisits()
+	r.mu.Unlock()
+
+	r.last = &Checkpoint{
+		RunID:     r.runID,
+		Step:      r.steps,
+		Node:      interrupt.Node,
+		NextNode:  interrupt.Node,
+		State:     r.state.Clone(),
+		Path:      path,
+		Visits:    visits,
+		Status:    CheckpointInterrupted,
+		Interrupt: &interrupt,
+		UpdatedAt: time.Now(),
+	}
+	r.store(ctx, r.last)
+	if r.emit != nil {
+		// Reviewers always get the full pending state
+		r.send(GraphUpdate{
+			Type:      "interrupt",
+			RunID:     r.runID,
+			Node:      interrupt.Node,
+			Path:      r.nodePath(interrupt.Node),
+			State:     r.state.Clone(),
+			Interrupt: &interrupt,
+			Timestamp: time.Now(),
+		}, r.state)
+	}
+
+	result := r.result()
+	result.Interrupt = &interrupt
+	return result, &InterruptedError{RunID: r.runID, Interrupt: interrupt, State: r.state.Clone()}
+}
+
+// executeBranches runs a branch point's node once per payload in parallel
+// and merges the branch states back with the fan-out's reducer
+func (r *runner) executeBranches(ctx context.Context, branch BranchDefinition) error {
+	fanOut, exists := r.engine.branchRegistry.GetFanOut(branch.FanOut)
+	if !exists {
+		return fmt.Errorf("fan-out %s not found", branch.FanOut)
+	}
+	node, exists := r.engine.nodeRegistry.GetNode(branch.Node)
+	if !exists {
+		return fmt.Errorf("node %s not found", branch.Node)
+	}
+
+	payloads, err := fanOut.Split(r.state)
+	if err != nil {
+		return fmt.Errorf("branch %s failed to split: %w", branch.Name, err)
+	}
+	slog.InfoContext(ctx, "Starting dynamic branching", "branch", branch.Name, "branches", len(payloads))
+
+	// Limit concurrency when requested
+	limit := branch.MaxConcurrency
+	if limit <= 0 || limit > len(payloads) {
+		limit = len(payloads)
+	}
+	semaphore := make(chan struct{}, limit)
+
+	ctx, span := startBranchSpan(ctx, branch, len(payloads), limit)
+	defer span.End()
+	metrics.branchStarted(ctx, branch.Name, len(payloads))
+
+	var wg sync.WaitGroup
+	results := make([]BranchResult, len(payloads))
+
+	for i, payload := range payloads {
+		wg.Add(1)
+
+		go func(index int, payload interface{}) {
+			defer wg.Done()
+
+			b := Branch{
+				Name:    branch.Name,
+				ID:      fmt.Sprintf("%s_%d", branch.Name, index+1),
+				Index:   index,
+				Payload: payload,
+			}
+
+			// Branches still waiting for a slot are skipped once the run stops
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+			case <-ctx.Done():
+				results[index] = BranchResult{Branch: b, Err: ctx.Err()}
+				return
+			}
+
+			branchState := r.state.fork()
+			branchState.CurrentNode = b.ID
+			r.appendPath(b.ID)
+
+			// Send node start for the individual branch
+			r.notifyState("node_start", b.ID, branchState, nil)
+
+			policy, snapshot := r.retryPolicy(branch.Node, branchState)
+			err := withRetry(ctx, policy, func() error {
+				return r.runNode(withBranch(r.withRunScope(ctx, b.ID), b), b.ID, branch.Node, node, branchState)
+			}, func(attempt int, err error) {
+				branchState.reset(snapshot)
+				r.notifyRetry(b.ID, branchState, attempt, err)
+			})
+			results[index] = BranchResult{Branch: b, State: branchState, Err: err}
+
+			// Send node complete for the individual branch
+			if err != nil {
+				r.notifyState("error", b.ID, branchState, err)
+			} else {
+				r.notifyState("node_complete", b.ID, branchState, nil)
+			}
+		}(i, payload)
+	}
+
+	// Wait for all branches to complete
+	wg.Wait()
+
+	// A stopped run does not merge partial results
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := fanOut.Reduce(r.state, results); err != nil {
+		return fmt.Errorf("branch %s failed to reduce: %w", branch.Name, err)
+	}
+	return nil
+}
+
+// fail records the error on the state, reports it and returns the partial result.
+// The last checkpoint keeps the state of the last successful node so the run can resume.
+func (r *runner) fail(ctx context.Context, node string, err error) (*ExecutionResult, error) {
+	updateType, status := "error", CheckpointFailed
+	if errors.Is(ctx.Err(), context.Canceled) {
+		slog.InfoContext(ctx, "⏹️  Run cancelled", "node", node)
+		err = cancelledError(node)
+		updateType, status = "cancelled", CheckpointCancelled
+	}
+
+	r.state.SetError(err)
+	r.notify(updateType, node, err)
+	if len(r.engine.hooks) > 0 {
+		r.engine.hooks.OnError(ctx, r.nodeEvent(ctx, node, r.state), err)
+	}
+
+	if r.last != nil {
+		failed := *r.last
+		failed.Status = status
+		failed.Error = err.Error()
+		failed.UpdatedAt = time.Now()
+		r.store(ctx, &failed)
+	}
+
+	return r.result(), err
+}
+
+// saveCheckpoint persists the state after node completed, ready to continue at next
+func (r *runner) saveCheckpoint(ctx context.Context, node, next string) {
+	if r.engine.checkpoints == nil {
+		return
+	}
+
+	status := CheckpointRunning
+	if next == "" {
+		status = CheckpointCompleted
+	}
+
+	r.mu.Lock()
+	path := append([]string(nil), r.path...)
+	visits := r.copyVisits()
+	r.mu.Unlock()
+
+	r.last = &Checkpoint{
+		RunID:     r.runID,
+		Step:      r.steps,
+		Node:      node,
+		NextNode:  next,
+		State:     r.state.Clone(),
+		Path:      path,
+		Visits:    visits,
+		Status:    status,
+		UpdatedAt: time.Now(),
+	}
+	r.store(ctx, r.last)
+}
+
+func (r *runner) store(ctx context.Context, checkpoint *Checkpoint) {
+	if r.engine.checkpoints == nil {
+		return
+	}
+	checkpoint.ParentRunID, checkpoint.ParentStep = r.parentRunID, r.parentStep
+	usage := r.meter.snapshot()
+	checkpoint.Usage = &usage
+	// A failing store must not abort the research itself
+	// Record the checkpoint even when the run was stopped by its deadline
+	if err := r.engine.checkpoints.Save(context.WithoutCancel(ctx), checkpoint); err != nil {
+		slog.WarnContext(ctx, "⚠️  Failed to save checkpoint", "error", err)
+	}
+}
+
+// notify reports an update about the run's main state
+func (r *runner) notify(updateType, node string, err error) {
+	r.notifyState(updateType, node, r.state, err)
+}
+
+// notifyState reports an update carrying a snapshot of the given state
+func (r *runner) notifyState(updateType, node string, state *AppState, err error) {
+	if r.emit == nil {
+		return
+	}
+
+	update := GraphUpdate{
+		Type:      updateType,
+		RunID:     r.runID,
+		Node:      node,
+		Path:      r.nodePath(node),
+		Error:     err,
+		Timestamp: time.Now(),
+	}
+	// Finished nodes and runs report the run's spending so far
+	switch updateType {
+	case "node_complete", "complete", "error", "cancelled":
+		usage := r.meter.snapshot()
+		update.Usage = &usage
+	}
+	r.send(update, state)
+}
+
+// send emits an update with the patch of the run's state since the previous one.
+// Branch-local states only reach the run's state when the branches are merged,
+// so their updates carry the current version without a patch.
+func (r *runner) send(update GraphUpdate, state *AppState) {
+	if state == r.state {
+		update.Patch, update.Version = r.statePatch()
+	} else {
+		r.mu.Lock()
+		update.Version = r.version
+		r.mu.Unlock()
+	}
+
+	// The start update is the baseline of every later patch
+	if update.State == nil && (update.Type == "start" || r.engine.stateSnapshots) {
+		update.State = state.Clone()
+	}
+	r.emit(update)
+}
+
+// streamChunks reports the chunks nodes stream from the run's state (and its branches) through chunks
+func (r *runner) streamChunks(chunks func(GraphUpdate)) {
+	r.chunks = chunks
+	r.state.SetStreamingCallback(func(node string, chunk string) {
+		update := GraphUpdate{
+			Type:      "streaming_chunk",
+			RunID:     r.runID,
+			Node:      node,
+			Path:      r.nodePath(node),
+			Chunk:     chunk,
+			Timestamp: time.Now(),
+		}
+		// Chunks do not change the state, so only clone it when asked to
+		if r.engine.stateSnapshots {
+			update.State = r.state.Clone()
+		}
+		chunks(update)
+	})
+}
+
+// nodePath returns the nested path of a node, prefixed by the enclosing subgraph nodes
+func (r *runner) nodePath(node string) []string {
+	path := append([]string(nil), r.scope...)
+	if node != "" {
+		path = append(path, node)
+	}
+	return path
+}
+
+// notifyRetry reports that a node failed and is about to run again
+func (r *runner) notifyRetry(node string, state *AppState, attempt int, err error) {
+	if r.emit == nil {
+		return
+	}
+
+	r.send(GraphUpdate{
+		Type:      "retry",
+		RunID:     r.runID,
+		Node:      node,
+		Path:      r.nodePath(node),
+		Error:     err,
+		Attempt:   attempt,
+		Timestamp: time.Now(),
+	}, state)
+}
+
+func (r *runner) appendPath(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.path = append(r.path, node)
+}
+
+// result builds the execution result for the run so far
+func (r *runner) result() *ExecutionResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return &ExecutionResult{
+		RunID:         r.runID,
+		FinalState:    r.state,
+		ExecutionTime: time.Since(r.startTime),
+		StepsExecuted: r.steps,
+		Path:          append([]string(nil), r.path...),
+		Usage:         r.meter.snapshot(),
+	}
+}
@@ -0,0 +1,92 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// SearchSubgraph packages query fan-out, search and merge as a reusable graph.
+// It expects SearchQueries on its input state and collects RawContents.
+func (r *NodeRegistry) SearchSubgraph(opts ...EngineOption) (*Engine, error) {
+	// Retry transient SerpAPI/LLM failures of individual searches
+	opts = append([]EngineOption{WithRetryPolicy("search_query", RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     500 * time.Millisecond,
+		MaxBackoff:  5 * time.Second,
+		Jitter:      0.2,
+	})}, opts...)
+
+	return NewBuilder().
+		SetName("search_and_merge").
+		AddNode("dispatch_searches", dispatchSearches).
+		AddConditionalEdge("dispatch_searches", afterDispatchSearches, BranchPrefix+"search_query").
+		AddBranch("search_query", r.SearchQuery, FanOut{
+			Split:  SplitSearchQueries,
+			Reduce: MergeSearchBranches,
+		}, "merge_search_results").
+		AddNode("merge_search_results", r.MergeSearchResults).
+		SetEntry("dispatch_searches").
+		Compile(opts...)
+}
+
+// useSearchLimits bounds the searches of execute_parallel_search and of the
+// built-in search subgraph with the timeout and retry policy e applies to search_query
+func (r *NodeRegistry) useSearchLimits(e *Engine) error {
+	r.searchTimeout = e.nodeTimeout("search_query")
+	if r.search == nil || r.subgraphs["search_and_merge"] != r.search {
+		return nil
+	}
+
+	var opts []EngineOption
+	if r.searchTimeout > 0 {
+		opts = append(opts, WithNodeTimeout("search_query", r.searchTimeout))
+	}
+	if policy, exists := e.retryPolicies["search_query"]; exists {
+		opts = append(opts, WithRetryPolicy("search_query", policy))
+	}
+	search, err := r.SearchSubgraph(opts...)
+	if err != nil {
+		return fmt.Errorf("failed to build search subgraph: %w", err)
+	}
+	r.RegisterSubgraph("search_and_merge", search, SearchMapping)
+	r.search = search
+	return nil
+}
+
+// SearchMapping passes the research topic and queries into the search subgraph
+// and merges its results back into the parent state
+var SearchMapping = StateMapping{
+	In: func(parent *AppState) (*AppState, error) {
+		child := NewAppState(parent.UserInput)
+		child.SetIntent(parent.GetIntent())
+		child.SetTopic(parent.Topic)
+		for _, query := range parent.GetSearchQueries() {
+			child.AddSearchQuery(query)
+		}
+		Update(child, SearchedQueriesKey, Get(parent, SearchedQueriesKey))
+		return child, nil
+	},
+	Out: func(parent, child *AppState) error {
+		for source, content := range child.GetRawContents() {
+			parent.SetRawContent(source, content)
+		}
+		Update(parent, SearchedQueriesKey, Get(child, SearchedQueriesKey))
+		return nil
+	},
+}
+
+// dispatchSearches is the entry of the search subgraph
+func dispatchSearches(ctx context.Context, state *AppState) error {
+	slog.InfoContext(ctx, "Dispatching search queries", "queries", len(state.GetSearchQueries()))
+	return nil
+}
+
+// afterDispatchSearches fans out one branch per query
+func afterDispatchSearches(ctx context.Context, state *AppState) (string, error) {
+	if len(state.GetSearchQueries()) == 0 {
+		return "", fmt.Errorf("no search queries to dispatch")
+	}
+	return BranchPrefix + "search_query", nil
+}
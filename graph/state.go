@@ -1,6 +1,10 @@
 package graph
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
 )
 
@@ -15,6 +19,7 @@ type AppState struct {
 	Topic       string            `json:"topic"`
 	SearchQueries []string        `json:"search_queries"`
 	RawContents map[string]string `json:"raw_contents"`
+	Outline     []string          `json:"outline"` // section headings the report follows
 	Report      string            `json:"report"`
 	History     []Message         `json:"history"`
 	Error       error             `json:"error,omitempty"`
@@ -25,6 +30,17 @@ type AppState struct {
 	
 	// Streaming support
 	streamingCallback StreamingCallback
+
+	// values of custom keys declared with NewKey
+	channels map[string]interface{}
+	// writes made on a branch state, replayed on the parent by MergeBranches
+	recording bool
+	writes    []func(target *AppState)
+	// keys already copied by the merge in progress; nil outside merges
+	merging map[string]bool
+	// members of the serialized state written since the last patch, e.g. report
+	// or channels/<key>; nil when the state may have changed as a whole
+	dirty map[string]bool
 }
 
 // Message represents a conversation message
@@ -51,6 +67,20 @@ func (s *AppState) SetStreamingCallback(callback StreamingCallback) {
 	s.streamingCallback = callback
 }
 
+// observeStreaming calls observe for every chunk before the current callback, if any
+func (s *AppState) observeStreaming(observe StreamingCallback) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := s.streamingCallback
+	s.streamingCallback = func(nodeId string, chunk string) {
+		observe(nodeId, chunk)
+		if next != nil {
+			next(nodeId, chunk)
+		}
+	}
+}
+
 // OnStreamingChunk notifies the callback about streaming chunks
 func (s *AppState) OnStreamingChunk(nodeId string, chunk string) {
 	s.mu.RLock()
@@ -64,37 +94,32 @@ func (s *AppState) OnStreamingChunk(nodeId string, chunk string) {
 
 // SetIntent safely sets the intent
 func (s *AppState) SetIntent(intent string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.Intent = intent
+	Update(s, IntentKey, intent)
 }
 
 // SetTopic safely sets the topic
 func (s *AppState) SetTopic(topic string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.Topic = topic
+	Update(s, TopicKey, topic)
 }
 
 // AddSearchQuery safely adds a search query
 func (s *AppState) AddSearchQuery(query string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.SearchQueries = append(s.SearchQueries, query)
+	Update(s, SearchQueriesKey, []string{query})
 }
 
 // SetRawContent safely sets raw content for a source
 func (s *AppState) SetRawContent(source, content string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.RawContents[source] = content
+	Update(s, RawContentsKey, map[string]string{source: content})
+}
+
+// SetOutline safely replaces the report outline
+func (s *AppState) SetOutline(outline []string) {
+	Update(s, OutlineKey, outline)
 }
 
 // SetReport safely sets the report
 func (s *AppState) SetReport(report string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.Report = report
+	Update(s, ReportKey, report)
 }
 
 // SetError safely sets an error
@@ -102,6 +127,15 @@ func (s *AppState) SetError(err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.Error = err
+	s.touch("error")
+}
+
+// setCurrentNode records the node executing against the state
+func (s *AppState) setCurrentNode(node string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.CurrentNode = node
+	s.touch("current_node")
 }
 
 // GetIntent safely gets the intent
@@ -120,6 +154,13 @@ func (s *AppState) GetSearchQueries() []string {
 	return queries
 }
 
+// GetOutline safely gets the report outline
+func (s *AppState) GetOutline() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string(nil), s.Outline...)
+}
+
 // GetRawContents safely gets the raw contents
 func (s *AppState) GetRawContents() map[string]string {
 	s.mu.RLock()
@@ -150,6 +191,7 @@ func (s *AppState) Clone() *AppState {
 	// Deep copy slices and maps
 	clone.SearchQueries = make([]string, len(s.SearchQueries))
 	copy(clone.SearchQueries, s.SearchQueries)
+	clone.Outline = append([]string(nil), s.Outline...)
 	
 	for k, v := range s.RawContents {
 		clone.RawContents[k] = v
@@ -162,5 +204,249 @@ func (s *AppState) Clone() *AppState {
 	clone.History = make([]Message, len(s.History))
 	copy(clone.History, s.History)
 	
+	// Writes copy stored values before reducing them, so channels can share them
+	if len(s.channels) > 0 {
+		clone.channels = make(map[string]interface{}, len(s.channels))
+		for k, v := range s.channels {
+			clone.channels[k] = v
+		}
+	}
+	
+	return clone
+}
+
+// fork creates a branch-local copy of the state that streams through the same callback
+// and records its writes for MergeBranches
+func (s *AppState) fork() *AppState {
+	clone := s.Clone()
+	clone.recording = true
+	
+	s.mu.RLock()
+	clone.streamingCallback = s.streamingCallback
+	s.mu.RUnlock()
+	
 	return clone
+}
+
+// assign replaces the contents of the state with a copy of other's
+func (s *AppState) assign(other *AppState) {
+	clone := other.Clone()
+	
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replaceRecorded(clone)
+}
+
+// replaceRecorded replaces the contents with clone and records the write; the caller holds s.mu
+func (s *AppState) replaceRecorded(clone *AppState) {
+	// Record a copy, since later writes to s go into the channels of clone
+	if s.recording {
+		recorded := clone.Clone()
+		s.record(func(target *AppState) {
+			target.replaceRecorded(recorded.Clone())
+		})
+	}
+	s.replace(clone)
+}
+
+// reset returns the state to a snapshot, discarding the writes recorded since then
+func (s *AppState) reset(snapshot *AppState) {
+	clone := snapshot.Clone()
+	
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replace(clone)
+	s.writes = nil
+}
+
+// replace copies the contents of clone; the caller holds s.mu
+func (s *AppState) replace(clone *AppState) {
+	s.UserInput = clone.UserInput
+	s.Intent = clone.Intent
+	s.Topic = clone.Topic
+	s.SearchQueries = clone.SearchQueries
+	s.RawContents = clone.RawContents
+	s.Outline = clone.Outline
+	s.Report = clone.Report
+	s.History = clone.History
+	s.Metadata = clone.Metadata
+	s.channels = clone.channels
+	s.dirty = nil
+	// The new values may be shared with clone
+	if s.merging != nil {
+		s.merging = make(map[string]bool)
+	}
+}
+
+// touch marks a member of the serialized state as written; the caller holds s.mu
+func (s *AppState) touch(member string) {
+	if s.dirty != nil {
+		s.dirty[member] = true
+	}
+}
+
+// touchAll marks the whole state as written
+func (s *AppState) touchAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dirty = nil
+}
+
+// changedMembers returns the serialized members written since the previous call,
+// keyed like touch, with nil for members the serialization now leaves out.
+// all is true when the state may have changed as a whole, e.g. after it was replaced.
+func (s *AppState) changedMembers() (members map[string]json.RawMessage, all bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dirty := s.dirty
+	s.dirty = make(map[string]bool)
+	if dirty == nil {
+		return nil, true, nil
+	}
+
+	members = make(map[string]json.RawMessage, len(dirty))
+	for member := range dirty {
+		value, present := s.member(member)
+		if !present {
+			members[member] = nil
+			continue
+		}
+		if members[member], err = json.Marshal(value); err != nil {
+			return nil, false, fmt.Errorf("failed to serialize state member %s: %w", member, err)
+		}
+	}
+	return members, false, nil
+}
+
+// member returns a member of the serialized state and whether MarshalJSON includes it;
+// the caller holds s.mu
+func (s *AppState) member(name string) (interface{}, bool) {
+	switch name {
+	case "user_input":
+		return s.UserInput, true
+	case "intent":
+		return s.Intent, true
+	case "topic":
+		return s.Topic, true
+	case "search_queries":
+		return s.SearchQueries, true
+	case "raw_contents":
+		return s.RawContents, true
+	case "outline":
+		return s.Outline, len(s.Outline) > 0
+	case "report":
+		return s.Report, true
+	case "history":
+		return s.History, true
+	case "error":
+		if s.Error == nil {
+			return nil, false
+		}
+		return s.Error.Error(), true
+	case "current_node":
+		return s.CurrentNode, true
+	case "metadata":
+		return s.Metadata, true
+	}
+	if key, ok := strings.CutPrefix(name, "channels/"); ok {
+		value, exists := s.channels[key]
+		return value, exists
+	}
+	return nil, false
+}
+
+// appStateJSON is the serialized form of AppState (errors are stored as text)
+type appStateJSON struct {
+	UserInput     string                     `json:"user_input"`
+	Intent        string                     `json:"intent"`
+	Topic         string                     `json:"topic"`
+	SearchQueries []string                   `json:"search_queries"`
+	RawContents   map[string]string          `json:"raw_contents"`
+	Outline       []string                   `json:"outline,omitempty"`
+	Report        string                     `json:"report"`
+	History       []Message                  `json:"history"`
+	Error         string                     `json:"error,omitempty"`
+	CurrentNode   string                     `json:"current_node"`
+	Metadata      map[string]interface{}     `json:"metadata"`
+	Channels      map[string]json.RawMessage `json:"channels,omitempty"`
+}
+
+// MarshalJSON safely serializes the state
+func (s *AppState) MarshalJSON() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	
+	data := appStateJSON{
+		UserInput:     s.UserInput,
+		Intent:        s.Intent,
+		Topic:         s.Topic,
+		SearchQueries: s.SearchQueries,
+		RawContents:   s.RawContents,
+		Outline:       s.Outline,
+		Report:        s.Report,
+		History:       s.History,
+		CurrentNode:   s.CurrentNode,
+		Metadata:      s.Metadata,
+	}
+	if s.Error != nil {
+		data.Error = s.Error.Error()
+	}
+	for k, v := range s.channels {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize state key %s: %w", k, err)
+		}
+		if data.Channels == nil {
+			data.Channels = make(map[string]json.RawMessage, len(s.channels))
+		}
+		data.Channels[k] = raw
+	}
+	
+	return json.Marshal(data)
+}
+
+// UnmarshalJSON safely restores the state
+func (s *AppState) UnmarshalJSON(b []byte) error {
+	var data appStateJSON
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	s.UserInput = data.UserInput
+	s.Intent = data.Intent
+	s.Topic = data.Topic
+	s.SearchQueries = data.SearchQueries
+	s.RawContents = data.RawContents
+	s.Outline = data.Outline
+	s.Report = data.Report
+	s.History = data.History
+	s.CurrentNode = data.CurrentNode
+	s.Metadata = data.Metadata
+	// Custom keys are decoded into their declared type on first use
+	s.channels = nil
+	for k, v := range data.Channels {
+		if s.channels == nil {
+			s.channels = make(map[string]interface{}, len(data.Channels))
+		}
+		s.channels[k] = v
+	}
+	s.Error = nil
+	if data.Error != "" {
+		s.Error = errors.New(data.Error)
+	}
+	
+	// Keep maps usable after restoring an empty state
+	if s.RawContents == nil {
+		s.RawContents = make(map[string]string)
+	}
+	if s.Metadata == nil {
+		s.Metadata = make(map[string]interface{})
+	}
+	s.dirty = nil
+	
+	return nil
 }
\ No newline at end of file
@@ -0,0 +1,109 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// StateMapping moves state between a parent graph and a subgraph
+type StateMapping struct {
+	// In builds the subgraph's initial state from the parent state.
+	// When nil the subgraph starts from a copy of the parent state.
+	In func(parent *AppState) (*AppState, error)
+	// Out merges the subgraph's final state into the parent state.
+	// When nil every field of the subgraph state replaces the parent's.
+	Out func(parent, child *AppState) error
+}
+
+func (m StateMapping) in(parent *AppState) (*AppState, error) {
+	if m.In == nil {
+		return parent.Clone(), nil
+	}
+	return m.In(parent)
+}
+
+func (m StateMapping) out(parent, child *AppState) error {
+	if m.Out == nil {
+		parent.assign(child)
+		return nil
+	}
+	return m.Out(parent, child)
+}
+
+// runScope describes the run a node executes in, so subgraphs can report through it
+type runScope struct {
+	runID  string
+	path   []string // nested node path of the executing node
+	emit   func(GraphUpdate)
+	chunks func(GraphUpdate)
+	intent string // intent the run was classified with, for log records
+}
+
+type runScopeContextKey struct{}
+
+// withRunScope attaches the executing run and node to the context
+func (r *runner) withRunScope(ctx context.Context, node string) context.Context {
+	intent := r.state.GetIntent()
+	if parent, ok := ctx.Value(runScopeContextKey{}).(runScope); ok && intent == "" {
+		// Subgraph states may not carry the intent of their parent
+		intent = parent.intent
+	}
+	return context.WithValue(ctx, runScopeContextKey{}, runScope{
+		runID:  r.runID,
+		path:   r.nodePath(node),
+		emit:   r.emit,
+		chunks: r.chunks,
+		intent: intent,
+	})
+}
+
+// AsNode wraps the engine's graph as a node of another graph.
+// The subgraph runs to completion inside the node; its updates are reported
+// through the parent run with a nested node path.
+func (e *Engine) AsNode(name string, mapping StateMapping) Node {
+	return func(ctx context.Context, parent *AppState) error {
+		child, err := mapping.in(parent)
+		if err != nil {
+			return fmt.Errorf("subgraph %s failed to map state in: %w", name, err)
+		}
+
+		r := e.newRunner(child, nil)
+		if scope, ok := ctx.Value(runScopeContextKey{}).(runScope); ok {
+			r.runID = scope.runID + "-" + name
+			r.scope = scope.path
+			if scope.emit != nil {
+				r.emit = nestedEmitter(scope, scope.emit)
+			}
+			// Chunks take the same path as the parent's own, dropped rather than blocking
+			if scope.chunks != nil {
+				r.streamChunks(nestedEmitter(scope, scope.chunks))
+			}
+		}
+
+		slog.InfoContext(ctx, "Entering subgraph", "subgraph", name, "graph", e.definition.Name)
+		if _, err := e.run(ctx, r, e.flow.Entry); err != nil {
+			return fmt.Errorf("subgraph %s failed: %w", name, err)
+		}
+
+		if err := mapping.out(parent, child); err != nil {
+			return fmt.Errorf("subgraph %s failed to map state out: %w", name, err)
+		}
+		return nil
+	}
+}
+
+// nestedEmitter forwards a subgraph's updates to send of the parent run.
+// The parent already reports the start, end and cancellation of the subgraph node itself.
+func nestedEmitter(scope runScope, send func(GraphUpdate)) func(GraphUpdate) {
+	return func(update GraphUpdate) {
+		switch update.Type {
+		case "start", "complete", "cancelled":
+			return
+		}
+		// Patches describe the subgraph's own state, not the parent run's
+		update.RunID = scope.runID
+		update.Patch, update.Version = nil, 0
+		send(update)
+	}
+}
@@ -0,0 +1,123 @@
+package graph
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// streamAll runs engine with StreamExecute and collects every update
+func streamAll(t *testing.T, engine *Engine, input string) (*ExecutionResult, []GraphUpdate) {
+	t.Helper()
+
+	updates := make(chan GraphUpdate, 10)
+	var received []GraphUpdate
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for update := range updates {
+			received = append(received, update)
+		}
+	}()
+	result, err := engine.StreamExecute(context.Background(), input, updates)
+	<-done
+	if err != nil {
+		t.Fatalf("StreamExecute() error = %v", err)
+	}
+	return result, received
+}
+
+func TestStreamExecuteSubgraph(t *testing.T) {
+	def, err := LoadDefinition("definitions/research-subgraph.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := NewEngine("", "", WithFakeLLM(), WithDefinition(def))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, received := streamAll(t, engine, "LLMの最新動向を調べて")
+
+	want := []string{"classify_intent_and_topic", "generate_search_queries", "search_and_merge", "draft_outline", "synthesize_and_report"}
+	if !reflect.DeepEqual(result.Path, want) {
+		t.Errorf("path = %v, want %v", result.Path, want)
+	}
+
+	// Updates and chunks of the subgraph are reported with nested paths under the parent run
+	var nested, chunked []string
+	for _, update := range received {
+		if update.RunID != result.RunID {
+			t.Errorf("%s update of %v from run %q, want %q", update.Type, update.Path, update.RunID, result.RunID)
+		}
+		if len(update.Path) < 2 {
+			continue
+		}
+		path := strings.Join(update.Path, "/")
+		switch update.Type {
+		case "node_complete":
+			nested = append(nested, path)
+		case "streaming_chunk":
+			if !contains(chunked, path) {
+				chunked = append(chunked, path)
+			}
+		}
+	}
+	sort.Strings(nested)
+	sort.Strings(chunked)
+
+	wantNested := []string{
+		"search_and_merge/dispatch_searches", "search_and_merge/merge_search_results",
+		"search_and_merge/search_query_1", "search_and_merge/search_query_2", "search_and_merge/search_query_3",
+		"search_and_merge/search_query_4", "search_and_merge/search_query_5",
+	}
+	if !reflect.DeepEqual(nested, wantNested) {
+		t.Errorf("nested completed nodes = %v, want %v", nested, wantNested)
+	}
+	if !reflect.DeepEqual(chunked, wantNested[2:]) {
+		t.Errorf("nested nodes streaming chunks = %v, want %v", chunked, wantNested[2:])
+	}
+}
+
+func TestSubgraphChunksAreTruncated(t *testing.T) {
+	inner, err := NewBuilder().
+		AddNode("write", func(ctx context.Context, state *AppState) error {
+			state.OnStreamingChunk("write", "")
+			state.OnStreamingChunk("write", strings.Repeat("a", 3000))
+			return nil
+		}).
+		SetEntry("write").
+		Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer, err := NewBuilder().
+		AddSubgraph("inner", inner, StateMapping{}).
+		SetEntry("inner").
+		Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, received := streamAll(t, outer, "input")
+
+	var chunks []GraphUpdate
+	for _, update := range received {
+		if update.Type == "streaming_chunk" {
+			chunks = append(chunks, update)
+		}
+	}
+	// Like the parent's own chunks, empty ones are skipped and long ones truncated
+	if len(chunks) != 1 {
+		t.Fatalf("%d chunks, want 1", len(chunks))
+	}
+	chunk := chunks[0]
+	if chunk.RunID != result.RunID || !reflect.DeepEqual(chunk.Path, []string{"inner", "write"}) {
+		t.Errorf("chunk of %v from run %q, want inner/write from %q", chunk.Path, chunk.RunID, result.RunID)
+	}
+	if want := strings.Repeat("a", 2000) + "..."; chunk.Chunk != want {
+		t.Errorf("chunk has %d bytes, want %d", len(chunk.Chunk), len(want))
+	}
+}
@@ -0,0 +1,151 @@
+package graph
+
+import (
+	"context"
+
+	"github.com/tmc/langchaingo/llms"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the spans of the graph package
+const instrumentationName = "github.com/takako/openai-go-demo/graph"
+
+// WithTracerProvider creates the OpenTelemetry spans of runs with provider
+// instead of the global one (see otel.SetTracerProvider)
+func WithTracerProvider(provider trace.TracerProvider) EngineOption {
+	return func(e *Engine) {
+		e.tracer = provider.Tracer(instrumentationName)
+	}
+}
+
+// runTracer returns the tracer of a run; subgraph runs keep using their parent's provider
+func (e *Engine) runTracer(ctx context.Context) trace.Tracer {
+	if nestedRun(ctx) {
+		return spanTracer(ctx)
+	}
+	if e.tracer != nil {
+		return e.tracer
+	}
+	return otel.Tracer(instrumentationName)
+}
+
+// spanTracer returns a tracer of the provider that created the span in ctx.
+// Outside of a traced run it creates no spans.
+func spanTracer(ctx context.Context) trace.Tracer {
+	return trace.SpanFromContext(ctx).TracerProvider().Tracer(instrumentationName)
+}
+
+// startRunSpan starts the span covering a whole run
+func (e *Engine) startRunSpan(ctx context.Context, r *runner, entry string) (context.Context, trace.Span) {
+	return e.runTracer(ctx).Start(ctx, "graph.run "+e.definition.Name, trace.WithAttributes(
+		attribute.String("graph.name", e.definition.Name),
+		attribute.String("graph.run_id", r.runID),
+		attribute.String("graph.entry", entry),
+		attribute.Bool("graph.resumed", r.last != nil),
+	))
+}
+
+// endRunSpan records the outcome and spending of a run on its span
+func endRunSpan(span trace.Span, result *ExecutionResult, err error) {
+	status := runStatus(err)
+	span.SetAttributes(attribute.String("graph.status", status))
+	if status == "interrupted" {
+		// A paused run did not fail
+		err = nil
+	}
+
+	if result != nil {
+		span.SetAttributes(
+			attribute.Int("graph.steps", result.StepsExecuted),
+			attribute.Int("gen_ai.usage.input_tokens", result.Usage.PromptTokens),
+			attribute.Int("gen_ai.usage.output_tokens", result.Usage.CompletionTokens),
+			attribute.Float64("graph.cost_usd", result.Usage.Cost),
+		)
+		if result.FinalState != nil && result.FinalState.GetIntent() != "" {
+			span.SetAttributes(attribute.String("graph.intent", result.FinalState.GetIntent()))
+		}
+	}
+	endSpan(span, err)
+}
+
+// startNodeSpan starts the span of one node attempt; name is the branch ID for branches
+func startNodeSpan(ctx context.Context, name, key string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("graph.node", key),
+		attribute.String("graph.node.path", tracedNode(ctx)),
+	}
+	if branch, ok := BranchFromContext(ctx); ok {
+		attrs = append(attrs,
+			attribute.String("graph.branch.id", branch.ID),
+			attribute.Int("graph.branch.index", branch.Index),
+		)
+	}
+	return spanTracer(ctx).Start(ctx, "graph.node "+name, trace.WithAttributes(attrs...))
+}
+
+// startBranchSpan starts the span around all branches of a branch point
+func startBranchSpan(ctx context.Context, branch BranchDefinition, fanOut, concurrency int) (context.Context, trace.Span) {
+	return spanTracer(ctx).Start(ctx, "graph.branch "+branch.Name, trace.WithAttributes(
+		attribute.String("graph.branch", branch.Name),
+		attribute.String("graph.node", branch.Node),
+		attribute.Int("graph.branch.fanout", fanOut),
+		attribute.Int("graph.branch.concurrency", concurrency),
+	))
+}
+
+// startSearchSpan starts the span of a web search made by a node
+func startSearchSpan(ctx context.Context, provider, query string) (context.Context, trace.Span) {
+	return spanTracer(ctx).Start(ctx, "search "+provider, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("search.provider", provider),
+		attribute.String("search.query", query),
+		attribute.String("graph.node.path", tracedNode(ctx)),
+	))
+}
+
+// endSpan marks failed spans as errors and ends them
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TelemetryModel wraps a model so each call creates an OpenTelemetry span with
+// the model name, calling node and token counts, and counts the tokens in the
+// llm.tokens metric. name is reported as the requested model.
+func TelemetryModel(model llms.Model, name string) llms.Model {
+	return telemetryModel{Model: model, name: name}
+}
+
+type telemetryModel struct {
+	llms.Model
+	name string
+}
+
+func (m telemetryModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	ctx, span := spanTracer(ctx).Start(ctx, "chat "+m.name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("gen_ai.operation.name", "chat"),
+		attribute.String("gen_ai.request.model", m.name),
+		attribute.String("graph.node.path", tracedNode(ctx)),
+	))
+
+	resp, err := m.Model.GenerateContent(ctx, messages, options...)
+	if err == nil {
+		promptTokens, completionTokens := responseTokens(resp)
+		metrics.tokensUsed(ctx, m.name, promptTokens, completionTokens)
+		span.SetAttributes(
+			attribute.Int("gen_ai.usage.input_tokens", promptTokens),
+			attribute.Int("gen_ai.usage.output_tokens", completionTokens),
+		)
+	}
+	endSpan(span, err)
+	return resp, err
+}
+
+func (m telemetryModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
@@ -0,0 +1,140 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// tracedEngine runs the research graph on rules with its spans recorded in memory
+func tracedEngine(t *testing.T, rules ...FakeRule) (*Engine, *FakeModel, *tracetest.InMemoryExporter) {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	fake := NewFakeModel(rules...)
+	engine, err := NewEngine("", "", WithLLM(fake, ProviderFake), WithTracerProvider(provider))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return engine, fake, exporter
+}
+
+// spanAttribute returns the value of an attribute of span
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestSpansWithFakeLLM(t *testing.T) {
+	engine, fake, exporter := tracedEngine(t)
+	result, err := engine.Execute(context.Background(), "LLMの最新動向を調べて")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	names := make(map[string]string, len(spans))
+	for _, span := range spans {
+		names[span.SpanContext.SpanID().String()] = span.Name
+	}
+
+	var nodes, chats, inputTokens int
+	for _, span := range spans {
+		parent := names[span.Parent.SpanID().String()]
+		if span.Status.Code == codes.Error {
+			t.Errorf("span %s failed: %s", span.Name, span.Status.Description)
+		}
+
+		switch {
+		case span.Name == "graph.run research":
+			if span.Parent.IsValid() {
+				t.Errorf("run span has the parent %s, want none", parent)
+			}
+			for key, want := range map[attribute.Key]attribute.Value{
+				"graph.run_id":               attribute.StringValue(result.RunID),
+				"graph.status":               attribute.StringValue("completed"),
+				"graph.intent":               attribute.StringValue("research"),
+				"graph.steps":                attribute.IntValue(result.StepsExecuted),
+				"gen_ai.usage.input_tokens":  attribute.IntValue(result.Usage.PromptTokens),
+				"gen_ai.usage.output_tokens": attribute.IntValue(result.Usage.CompletionTokens),
+			} {
+				if got := spanAttribute(span, key); got != want {
+					t.Errorf("run span %s = %v, want %v", key, got.Emit(), want.Emit())
+				}
+			}
+
+		case span.Name == "graph.branch search_query":
+			if parent != "graph.run research" || spanAttribute(span, "graph.branch.fanout").AsInt64() != 5 {
+				t.Errorf("branch span under %q fans out to %v, want 5 under the run", parent, spanAttribute(span, "graph.branch.fanout").Emit())
+			}
+
+		case span.Name == "chat fake":
+			chats++
+			inputTokens += int(spanAttribute(span, "gen_ai.usage.input_tokens").AsInt64())
+			if want := "graph.node " + spanAttribute(span, "graph.node.path").AsString(); parent != want {
+				t.Errorf("chat span under %q, want it under %q", parent, want)
+			}
+
+		case regexp.MustCompile(`^graph\.node search_query_\d$`).MatchString(span.Name):
+			nodes++
+			if parent != "graph.branch search_query" {
+				t.Errorf("span %s under %q, want it under the branch span", span.Name, parent)
+			}
+
+		default:
+			nodes++
+			if parent != "graph.run research" {
+				t.Errorf("span %s under %q, want it under the run span", span.Name, parent)
+			}
+		}
+	}
+
+	if nodes != len(result.Path) {
+		t.Errorf("%d node spans, want one per node of %v", nodes, result.Path)
+	}
+	if chats != len(fake.Prompts()) || inputTokens != result.Usage.PromptTokens {
+		t.Errorf("%d chat spans with %d input tokens, want %d with %d", chats, inputTokens, len(fake.Prompts()), result.Usage.PromptTokens)
+	}
+}
+
+func TestSpansOfFailedRun(t *testing.T) {
+	failing := append([]FakeRule{{
+		Pattern: regexp.MustCompile(`レポートを作成してください`),
+		Err:     errors.New("service unavailable"),
+	}}, DemoScript()...)
+	engine, _, exporter := tracedEngine(t, failing...)
+	if _, err := engine.Execute(context.Background(), "LLMの最新動向を調べて"); err == nil {
+		t.Fatal("Execute() succeeded, want the report to fail")
+	}
+
+	// The failure is recorded on the model call, its node and the run
+	failed := map[string]bool{}
+	for _, span := range exporter.GetSpans() {
+		if span.Status.Code == codes.Error {
+			failed[span.Name] = true
+		}
+		if span.Name == "graph.run research" && spanAttribute(span, "graph.status").AsString() != "failed" {
+			t.Errorf("run span status = %v, want failed", spanAttribute(span, "graph.status").Emit())
+		}
+	}
+	for _, name := range []string{"chat fake", "graph.node synthesize_and_report", "graph.run research"} {
+		if !failed[name] {
+			t.Errorf("span %s is not marked as failed (failed spans: %v)", name, failed)
+		}
+	}
+	if len(failed) != 3 {
+		t.Errorf("failed spans = %v, want only the report's", failed)
+	}
+}
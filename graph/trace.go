@@ -0,0 +1,399 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Trace event kinds
+const (
+	TraceNode   = "node"   // one attempt of a node with its input and output state
+	TraceEdge   = "edge"   // a transition between nodes
+	TraceLLM    = "llm"    // a model call with its prompt and response
+	TraceSearch = "search" // a search with its query and result
+)
+
+// ErrNotRecorded is returned during a replay when a call has no recorded response
+var ErrNotRecorded = errors.New("call not recorded in trace")
+
+// Trace is the recording of a run
+type Trace struct {
+	RunID      string       `json:"run_id"`
+	Graph      string       `json:"graph"`
+	Input      string       `json:"input"`
+	Status     string       `json:"status"` // checkpoint status the run ended with
+	Error      string       `json:"error,omitempty"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt time.Time    `json:"finished_at"`
+	Events     []TraceEvent `json:"events"`
+}
+
+// TraceEvent is one recorded step of a run
+type TraceEvent struct {
+	Seq      int           `json:"seq"`
+	Kind     string        `json:"kind"`
+	Node     string        `json:"node,omitempty"`   // nested node path, e.g. search_and_merge/search_query_1
+	Input    *AppState     `json:"input,omitempty"`  // node: state before the attempt
+	Output   *AppState     `json:"output,omitempty"` // node: state after the attempt
+	From     string        `json:"from,omitempty"`   // edge
+	To       string        `json:"to,omitempty"`     // edge
+	Prompt   string        `json:"prompt,omitempty"` // llm
+	Query    string        `json:"query,omitempty"`  // search
+	Response string        `json:"response,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Time     time.Time     `json:"time"`
+}
+
+// WithTraceDir records every run as <dir>/<run-id>.json.
+// Resumed runs continue the trace of their run.
+func WithTraceDir(dir string) EngineOption {
+	return func(e *Engine) {
+		e.traceDir = dir
+	}
+}
+
+// LoadTrace reads a trace file
+func LoadTrace(path string) (*Trace, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trace: %w", err)
+	}
+
+	var trace Trace
+	if err := json.Unmarshal(data, &trace); err != nil {
+		return nil, fmt.Errorf("failed to decode trace %s: %w", path, err)
+	}
+	return &trace, nil
+}
+
+// SaveTrace writes a trace file
+func SaveTrace(path string, trace *Trace) error {
+	data, err := json.MarshalIndent(trace, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode trace: %w", err)
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Replay runs the input of a trace again, feeding the recorded LLM and search
+// responses back instead of calling the services. A call whose prompt changed
+// gets the next recorded response of the same node, so prompt changes can be
+// bisected offline; a call without any recorded response fails with ErrNotRecorded.
+func (e *Engine) Replay(ctx context.Context, trace *Trace) (*ExecutionResult, error) {
+	slog.InfoContext(ctx, "⏪ Replaying run", "run_id", trace.RunID, "events", len(trace.Events))
+	ctx = context.WithValue(ctx, replayContextKey{}, newReplaySource(trace))
+
+	r := e.newRunner(NewAppState(trace.Input), nil)
+	return e.run(ctx, r, e.flow.Entry)
+}
+
+type traceContextKey struct{}
+type replayContextKey struct{}
+
+// traceRecorder collects the events of a run
+type traceRecorder struct {
+	mu    sync.Mutex
+	trace *Trace
+	// inputs of the node attempts in progress keyed by node path
+	inputs map[string]*AppState
+}
+
+// startTrace attaches a recorder to a top-level run; subgraphs record into their parent's trace
+func (e *Engine) startTrace(ctx context.Context, r *runner) (context.Context, *traceRecorder) {
+	if e.traceDir == "" || ctx.Value(traceContextKey{}) != nil {
+		return ctx, nil
+	}
+
+	trace := &Trace{RunID: r.runID, Graph: e.definition.Name, Input: r.state.UserInput, StartedAt: time.Now()}
+	if previous, err := LoadTrace(e.tracePath(r.runID)); err == nil {
+		trace = previous
+	}
+	recorder := &traceRecorder{trace: trace, inputs: make(map[string]*AppState)}
+	return context.WithValue(ctx, traceContextKey{}, recorder), recorder
+}
+
+// finishTrace writes the trace of a run that ended with err
+func (e *Engine) finishTrace(recorder *traceRecorder, err error) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	trace := recorder.trace
+	trace.FinishedAt = time.Now()
+	trace.Status, trace.Error = CheckpointCompleted, ""
+	var interrupted *InterruptedError
+	switch {
+	case err == nil:
+	case errors.As(err, &interrupted):
+		trace.Status = CheckpointInterrupted
+	case errors.Is(err, ErrCancelled):
+		trace.Status, trace.Error = CheckpointCancelled, err.Error()
+	default:
+		trace.Status, trace.Error = CheckpointFailed, err.Error()
+	}
+
+	if err := os.MkdirAll(e.traceDir, 0o755); err != nil {
+		slog.Warn("⚠️  Failed to create trace directory", "error", err)
+		return
+	}
+	if err := SaveTrace(e.tracePath(trace.RunID), trace); err != nil {
+		slog.Warn("⚠️  Failed to save trace", "run_id", trace.RunID, "error", err)
+	}
+}
+
+func (e *Engine) tracePath(runID string) string {
+	return filepath.Join(e.traceDir, runID+".json")
+}
+
+// recordTrace appends an event to the trace of the run in ctx, if any
+func recordTrace(ctx context.Context, event TraceEvent) {
+	recorder, ok := ctx.Value(traceContextKey{}).(*traceRecorder)
+	if !ok {
+		return
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	event.Seq = len(recorder.trace.Events) + 1
+	event.Time = time.Now()
+	recorder.trace.Events = append(recorder.trace.Events, event)
+}
+
+// traceNodeStart records the input of a node attempt for the trace of the run, if any.
+// The engine calls the trace functions itself, so tracing observes subgraph runs
+// too, whatever hooks their engines have.
+func traceNodeStart(ctx context.Context, event NodeEvent) {
+	recorder, ok := ctx.Value(traceContextKey{}).(*traceRecorder)
+	if !ok {
+		return
+	}
+
+	input := event.State.Clone()
+	recorder.mu.Lock()
+	recorder.inputs[strings.Join(event.Path, "/")] = input
+	recorder.mu.Unlock()
+}
+
+// traceNodeEnd records a node attempt with its input and output into the trace of the run
+func traceNodeEnd(ctx context.Context, event NodeEvent, err error) {
+	recorder, ok := ctx.Value(traceContextKey{}).(*traceRecorder)
+	if !ok {
+		return
+	}
+
+	node := strings.Join(event.Path, "/")
+	recorder.mu.Lock()
+	input := recorder.inputs[node]
+	delete(recorder.inputs, node)
+	recorder.mu.Unlock()
+
+	recordTrace(ctx, TraceEvent{
+		Kind:     TraceNode,
+		Node:     node,
+		Input:    input,
+		Output:   event.State.Clone(),
+		Error:    errorText(err),
+		Duration: event.Duration,
+	})
+}
+
+// traceEdge records a transition into the trace of the run
+func traceEdge(ctx context.Context, from, to string) {
+	recordTrace(ctx, TraceEvent{Kind: TraceEdge, From: from, To: to})
+}
+
+// replaySource serves the recorded calls of a trace in order
+type replaySource struct {
+	mu sync.Mutex
+	// recorded llm and search calls keyed by kind and node path
+	calls map[string][]TraceEvent
+}
+
+func newReplaySource(trace *Trace) *replaySource {
+	source := &replaySource{calls: make(map[string][]TraceEvent)}
+	for _, event := range trace.Events {
+		if event.Kind == TraceLLM || event.Kind == TraceSearch {
+			key := event.Kind + " " + event.Node
+			source.calls[key] = append(source.calls[key], event)
+		}
+	}
+	return source
+}
+
+// next returns the recorded call of node matching request (a prompt or a query)
+func (s *replaySource) next(kind, node, request string) (TraceEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := kind + " " + node
+	calls := s.calls[key]
+	if len(calls) == 0 {
+		return TraceEvent{}, fmt.Errorf("%w: %s call of %s", ErrNotRecorded, kind, node)
+	}
+
+	match := 0
+	for i, call := range calls {
+		if call.Prompt+call.Query == request {
+			match = i
+			break
+		}
+		if i == len(calls)-1 {
+			slog.Warn("⚠️  Replayed request changed, using the next recorded response", "kind", kind, "node", node)
+		}
+	}
+
+	call := calls[match]
+	s.calls[key] = append(calls[:match:match], calls[match+1:]...)
+	return call, nil
+}
+
+// recorded reports whether node has recorded calls of kind left
+func (s *replaySource) recorded(kind, node string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.calls[kind+" "+node]) > 0
+}
+
+// replaysSearch reports whether ctx replays a trace and, if so, whether the
+// calling node recorded searches rather than LLM-simulated ones
+func replaysSearch(ctx context.Context) (searched, replaying bool) {
+	source, ok := ctx.Value(replayContextKey{}).(*replaySource)
+	if !ok {
+		return false, false
+	}
+	return source.recorded(TraceSearch, tracedNode(ctx)), true
+}
+
+// replayed returns the recorded response of a call when ctx replays a trace
+func replayed(ctx context.Context, kind, request string) (TraceEvent, bool, error) {
+	source, ok := ctx.Value(replayContextKey{}).(*replaySource)
+	if !ok {
+		return TraceEvent{}, false, nil
+	}
+	call, err := source.next(kind, tracedNode(ctx), request)
+	return call, true, err
+}
+
+// tracedNode returns the nested path of the node making a call
+func tracedNode(ctx context.Context) string {
+	if scope, ok := ctx.Value(runScopeContextKey{}).(runScope); ok {
+		return strings.Join(scope.path, "/")
+	}
+	return ""
+}
+
+// TracedSearch runs a search made by a node, recording it in the run's trace
+// or answering it from the trace being replayed
+func TracedSearch(ctx context.Context, query string, search func(ctx context.Context) (string, error)) (string, error) {
+	if call, ok, err := replayed(ctx, TraceSearch, query); ok {
+		if err != nil {
+			return "", err
+		}
+		recordTrace(ctx, call)
+		return call.Response, recordedError(call)
+	}
+
+	start := time.Now()
+	result, err := search(ctx)
+	recordTrace(ctx, TraceEvent{
+		Kind:     TraceSearch,
+		Node:     tracedNode(ctx),
+		Query:    query,
+		Response: result,
+		Error:    errorText(err),
+		Duration: time.Since(start),
+	})
+	return result, err
+}
+
+// TracedModel wraps a model so its calls are recorded in the run's trace
+// or answered from the trace being replayed
+func TracedModel(model llms.Model) llms.Model {
+	return tracedModel{Model: model}
+}
+
+type tracedModel struct {
+	llms.Model
+}
+
+func (m tracedModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	prompt := promptText(messages)
+	if call, ok, err := replayed(ctx, TraceLLM, prompt); ok {
+		if err != nil {
+			return nil, err
+		}
+		recordTrace(ctx, call)
+		if err := recordedError(call); err != nil {
+			return nil, err
+		}
+
+		// Recorded responses stream as a single chunk
+		var opts llms.CallOptions
+		for _, opt := range options {
+			opt(&opts)
+		}
+		if opts.StreamingFunc != nil {
+			if err := opts.StreamingFunc(ctx, []byte(call.Response)); err != nil {
+				return nil, err
+			}
+		}
+		return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: call.Response}}}, nil
+	}
+
+	start := time.Now()
+	resp, err := m.Model.GenerateContent(ctx, messages, options...)
+	event := TraceEvent{
+		Kind:     TraceLLM,
+		Node:     tracedNode(ctx),
+		Prompt:   prompt,
+		Error:    errorText(err),
+		Duration: time.Since(start),
+	}
+	if err == nil && len(resp.Choices) > 0 {
+		event.Response = resp.Choices[0].Content
+	}
+	recordTrace(ctx, event)
+	return resp, err
+}
+
+func (m tracedModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+// promptText joins the text parts of the messages sent to a model
+func promptText(messages []llms.MessageContent) string {
+	var parts []string
+	for _, message := range messages {
+		for _, part := range message.Parts {
+			if text, ok := part.(llms.TextContent); ok {
+				parts = append(parts, text.Text)
+			}
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// recordedError recreates the error of a recorded call
+func recordedError(call TraceEvent) error {
+	if call.Error == "" {
+		return nil
+	}
+	return errors.New(call.Error)
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
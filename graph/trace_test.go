@@ -0,0 +1,64 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestReplayServesRecordedSearchesWithoutSerpAPI(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewEngine("", "", WithFakeLLM(), WithTraceDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := recorder.Execute(context.Background(), "LLMの最新動向を調べて")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace, err := LoadTrace(filepath.Join(dir, result.RunID+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Turn the simulated searches into what a run with SerpAPI records
+	query := regexp.MustCompile(`要約を日本語で2-3段落で提供してください: "([^"]*)"`)
+	searches := 0
+	for i, event := range trace.Events {
+		if event.Kind != TraceLLM || !strings.HasPrefix(event.Node, "search_query_") {
+			continue
+		}
+		trace.Events[i] = TraceEvent{Seq: event.Seq, Kind: TraceSearch, Node: event.Node,
+			Query: query.FindStringSubmatch(event.Prompt)[1], Response: "SerpAPI: " + event.Response}
+		searches++
+	}
+	if searches == 0 {
+		t.Fatal("trace has no searches")
+	}
+
+	// Neither SerpAPI nor the model may be called during the replay
+	replayer, err := NewEngine("", "", WithFakeLLM(FakeRule{Err: errors.New("model called during replay")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay, err := replayer.Replay(context.Background(), trace)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+
+	contents := replay.FinalState.GetRawContents()
+	if len(contents) != searches {
+		t.Fatalf("replay collected %d results, want %d", len(contents), searches)
+	}
+	for source, content := range contents {
+		if !strings.HasPrefix(content, "SerpAPI: ") {
+			t.Errorf("%s = %q, want the recorded search result", source, content)
+		}
+	}
+	if replay.FinalState.Report != result.FinalState.Report {
+		t.Errorf("replayed report differs from the recorded one")
+	}
+}
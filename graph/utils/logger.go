@@ -1,67 +1,27 @@
 package utils
 
 import (
-	"log"
-	"os"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
 )
 
-// LogLevel represents different log levels
-type LogLevel int
-
-const (
-	DEBUG LogLevel = iota
-	INFO
-	WARN
-	ERROR
-)
-
-// Logger provides structured logging with levels
-type Logger struct {
-	level  LogLevel
-	debug  *log.Logger
-	info   *log.Logger
-	warn   *log.Logger
-	error  *log.Logger
-}
-
-// NewLogger creates a new logger with the specified level
-func NewLogger(level LogLevel) *Logger {
-	return &Logger{
-		level: level,
-		debug: log.New(os.Stdout, "[DEBUG] ", log.LstdFlags|log.Lshortfile),
-		info:  log.New(os.Stdout, "[INFO] ", log.LstdFlags),
-		warn:  log.New(os.Stdout, "[WARN] ", log.LstdFlags),
-		error: log.New(os.Stderr, "[ERROR] ", log.LstdFlags|log.Lshortfile),
+// NewLogHandler creates a slog handler writing "text" or "json" records of at
+// least level ("debug", "info", "warn" or "error") to w
+func NewLogHandler(w io.Writer, format, level string) (slog.Handler, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
 	}
-}
-
-// Debug logs debug messages
-func (l *Logger) Debug(format string, args ...interface{}) {
-	if l.level <= DEBUG {
-		l.debug.Printf(format, args...)
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, want text or json", format)
 	}
 }
-
-// Info logs info messages
-func (l *Logger) Info(format string, args ...interface{}) {
-	if l.level <= INFO {
-		l.info.Printf(format, args...)
-	}
-}
-
-// Warn logs warning messages
-func (l *Logger) Warn(format string, args ...interface{}) {
-	if l.level <= WARN {
-		l.warn.Printf(format, args...)
-	}
-}
-
-// Error logs error messages
-func (l *Logger) Error(format string, args ...interface{}) {
-	if l.level <= ERROR {
-		l.error.Printf(format, args...)
-	}
-}
-
-// Default logger instance
-var DefaultLogger = NewLogger(INFO)
\ No newline at end of file
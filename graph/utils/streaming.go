@@ -2,6 +2,7 @@ package utils
 
 import (
 	"context"
+	"log/slog"
 	"strings"
 
 	"github.com/tmc/langchaingo/llms"
@@ -11,7 +12,7 @@ import (
 type StreamingOptions struct {
 	NodeID   string
 	Callback func(nodeId string, chunk string)
-	Logger   *Logger
+	Logger   *slog.Logger // optional
 }
 
 // StreamingHelper provides common streaming functionality
@@ -34,7 +35,7 @@ func (h *StreamingHelper) GenerateWithStreaming(
 	
 	// Log the operation start
 	if opts.Logger != nil {
-		opts.Logger.Debug("Starting streaming generation for node: %s", opts.NodeID)
+		opts.Logger.DebugContext(ctx, "Starting streaming generation", "node", opts.NodeID)
 	}
 	
 	_, err := llms.GenerateFromSinglePrompt(ctx, h.llm, prompt, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
@@ -48,7 +49,7 @@ func (h *StreamingHelper) GenerateWithStreaming(
 		
 		// Debug log chunks
 		if opts.Logger != nil && len(chunkStr) > 0 {
-			opts.Logger.Debug("Streaming chunk for %s: %q (length: %d)", opts.NodeID, chunkStr, len(chunkStr))
+			opts.Logger.DebugContext(ctx, "Streaming chunk", "node", opts.NodeID, "chunk", chunkStr, "length", len(chunkStr))
 		}
 		
 		return nil
@@ -56,14 +57,14 @@ func (h *StreamingHelper) GenerateWithStreaming(
 	
 	if err != nil {
 		if opts.Logger != nil {
-			opts.Logger.Error("Streaming generation failed for %s: %v", opts.NodeID, err)
+			opts.Logger.ErrorContext(ctx, "Streaming generation failed", "node", opts.NodeID, "error", err)
 		}
 		return "", err
 	}
 	
 	result := response.String()
 	if opts.Logger != nil {
-		opts.Logger.Info("Streaming generation completed for %s: %d characters", opts.NodeID, len(result))
+		opts.Logger.InfoContext(ctx, "Streaming generation completed", "node", opts.NodeID, "characters", len(result))
 	}
 	
 	return result, nil
@@ -76,19 +77,19 @@ func (h *StreamingHelper) GenerateWithoutStreaming(
 	opts StreamingOptions,
 ) (string, error) {
 	if opts.Logger != nil {
-		opts.Logger.Debug("Starting non-streaming generation for node: %s", opts.NodeID)
+		opts.Logger.DebugContext(ctx, "Starting non-streaming generation", "node", opts.NodeID)
 	}
 	
 	response, err := llms.GenerateFromSinglePrompt(ctx, h.llm, prompt)
 	if err != nil {
 		if opts.Logger != nil {
-			opts.Logger.Error("Non-streaming generation failed for %s: %v", opts.NodeID, err)
+			opts.Logger.ErrorContext(ctx, "Non-streaming generation failed", "node", opts.NodeID, "error", err)
 		}
 		return "", err
 	}
 	
 	if opts.Logger != nil {
-		opts.Logger.Info("Non-streaming generation completed for %s: %d characters", opts.NodeID, len(response))
+		opts.Logger.InfoContext(ctx, "Non-streaming generation completed", "node", opts.NodeID, "characters", len(response))
 	}
 	
 	return response, nil
@@ -0,0 +1,428 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Validation issue severities
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// ValidationIssue describes a single problem found in a graph definition
+type ValidationIssue struct {
+	Severity string `json:"severity"`
+	Kind     string `json:"kind"` // e.g. "unknown_node", "unknown_edge", "unreachable_node"
+	Node     string `json:"node,omitempty"`
+	Edge     string `json:"edge,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (i ValidationIssue) String() string {
+	return fmt.Sprintf("[%s] %s: %s", i.Severity, i.Kind, i.Message)
+}
+
+// ValidationError lists the errors that prevent a graph from running
+type ValidationError struct {
+	Graph  string
+	Issues []ValidationIssue
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		messages[i] = issue.Message
+	}
+	return fmt.Sprintf("graph %q has %d problem(s): %s", e.Graph, len(e.Issues), strings.Join(messages, "; "))
+}
+
+// Validate checks the definition against the registered nodes, edges and fan-outs.
+// It returns a *ValidationError when any error-level issue is found.
+func (d *GraphDefinition) Validate(nodes *NodeRegistry, edges *EdgeRegistry, branches *BranchRegistry) error {
+	var errs []ValidationIssue
+	for _, issue := range ValidateDefinition(d, nodes, edges, branches) {
+		if issue.Severity == SeverityError {
+			errs = append(errs, issue)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Graph: d.Name, Issues: errs}
+}
+
+// ValidateDefinition statically checks a graph and returns every problem found
+func ValidateDefinition(d *GraphDefinition, nodes *NodeRegistry, edges *EdgeRegistry, branches *BranchRegistry) []ValidationIssue {
+	v := &validator{
+		def:      d,
+		declared: make(map[string]NodeDefinition),
+		routes:   make(map[string][]string),
+		branches: make(map[string]BranchDefinition),
+	}
+
+	v.checkNodes(nodes, edges)
+	v.checkEdges(edges)
+	v.checkBranches(nodes, branches)
+	v.checkReachability()
+	v.checkLoops()
+
+	return v.issues
+}
+
+// validator accumulates issues while walking a definition
+type validator struct {
+	def      *GraphDefinition
+	declared map[string]NodeDefinition
+	routes   map[string][]string
+	branches map[string]BranchDefinition
+	issues   []ValidationIssue
+}
+
+func (v *validator) add(severity, kind, node, edge, format string, args ...interface{}) {
+	v.issues = append(v.issues, ValidationIssue{
+		Severity: severity,
+		Kind:     kind,
+		Node:     node,
+		Edge:     edge,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// checkNodes verifies node declarations and that every node's edge resolves
+func (v *validator) checkNodes(nodes *NodeRegistry, edges *EdgeRegistry) {
+	for _, node := range v.def.Nodes {
+		if node.Name == "" {
+			v.add(SeverityError, "unnamed_node", "", "", "node without a name")
+			continue
+		}
+		if _, exists := v.declared[node.Name]; exists {
+			v.add(SeverityError, "duplicate_node", node.Name, "", "node %s declared twice", node.Name)
+		}
+		v.declared[node.Name] = node
+
+		if _, exists := nodes.GetNode(node.Name); !exists {
+			v.add(SeverityError, "unknown_node", node.Name, "", "node %s is not registered", node.Name)
+		}
+		if node.Retry != nil {
+			if _, err := node.Retry.Policy(); err != nil {
+				v.add(SeverityError, "invalid_retry", node.Name, "", "node %s has an invalid retry policy: %v", node.Name, err)
+			}
+		}
+		if err := checkTimeout(node.Timeout); err != nil {
+			v.add(SeverityError, "invalid_timeout", node.Name, "", "node %s has an invalid timeout: %v", node.Name, err)
+		}
+		if node.MaxVisits < 0 {
+			v.add(SeverityError, "invalid_max_visits", node.Name, "", "node %s has a negative max_visits", node.Name)
+		}
+		if node.Edge != "" && node.Next != "" {
+			v.add(SeverityError, "ambiguous_transition", node.Name, node.Edge, "node %s sets both edge and next", node.Name)
+		}
+		if node.Edge != "" {
+			if _, exists := edges.GetEdge(node.Edge); !exists {
+				v.add(SeverityError, "unknown_edge", node.Name, node.Edge, "node %s uses unregistered edge %s", node.Name, node.Edge)
+			}
+		}
+	}
+
+	for _, edge := range v.def.Edges {
+		v.routes[edge.Name] = edge.Routes
+	}
+	for _, branch := range v.def.Branches {
+		v.branches[branch.Name] = branch
+	}
+
+	for _, node := range v.def.Nodes {
+		if node.Next != "" {
+			if _, exists := v.declared[node.Next]; !exists {
+				v.add(SeverityError, "unknown_target", node.Name, "", "node %s continues to undeclared node %s", node.Name, node.Next)
+			}
+		}
+		if node.Edge != "" {
+			if _, exists := v.routes[node.Edge]; !exists {
+				v.add(SeverityError, "undeclared_edge", node.Name, node.Edge, "edge %s used by node %s does not declare its routes", node.Edge, node.Name)
+			}
+		}
+	}
+
+	if v.def.Entry == "" {
+		v.add(SeverityError, "missing_entry", "", "", "entry node is not set")
+	} else if _, exists := v.declared[v.def.Entry]; !exists {
+		v.add(SeverityError, "unknown_entry", v.def.Entry, "", "entry node %s is not declared", v.def.Entry)
+	}
+}
+
+// checkTimeout verifies an optional timeout declaration
+func checkTimeout(timeout string) error {
+	if timeout == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return err
+	}
+	if d <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	return nil
+}
+
+// checkEdges verifies that every route an edge can return exists
+func (v *validator) checkEdges(edges *EdgeRegistry) {
+	used := make(map[string]bool)
+	for _, node := range v.def.Nodes {
+		used[node.Edge] = true
+	}
+
+	for _, edge := range v.def.Edges {
+		if _, exists := edges.GetEdge(edge.Name); !exists {
+			v.add(SeverityError, "unknown_edge", "", edge.Name, "edge %s is not registered", edge.Name)
+		}
+		if !used[edge.Name] {
+			v.add(SeverityWarning, "unused_edge", "", edge.Name, "edge %s is not used by any node", edge.Name)
+		}
+
+		for _, route := range edge.Routes {
+			switch {
+			case route == EndRoute:
+			case strings.HasPrefix(route, BranchPrefix):
+				name := strings.TrimPrefix(route, BranchPrefix)
+				if _, exists := v.branches[name]; !exists {
+					v.add(SeverityError, "unknown_branch", "", edge.Name, "edge %s routes to undeclared branch %s", edge.Name, name)
+				}
+			default:
+				if _, exists := v.declared[route]; !exists {
+					v.add(SeverityError, "unknown_target", "", edge.Name, "edge %s routes to undeclared node %s", edge.Name, route)
+				}
+			}
+		}
+	}
+}
+
+// checkBranches verifies that branch points fan out through registered
+// implementations and join an existing node
+func (v *validator) checkBranches(nodes *NodeRegistry, branches *BranchRegistry) {
+	for _, branch := range v.def.Branches {
+		if _, exists := branches.GetFanOut(branch.FanOut); !exists {
+			v.add(SeverityError, "unknown_fanout", "", "", "branch %s uses unregistered fan-out %q", branch.Name, branch.FanOut)
+		}
+		if _, exists := nodes.GetNode(branch.Node); !exists {
+			v.add(SeverityError, "unknown_node", branch.Node, "", "branch %s runs unregistered node %q", branch.Name, branch.Node)
+		}
+		if branch.MaxConcurrency < 0 {
+			v.add(SeverityError, "invalid_branch", "", "", "branch %s has a negative max_concurrency", branch.Name)
+		}
+		if err := checkTimeout(branch.Timeout); err != nil {
+			v.add(SeverityError, "invalid_timeout", branch.Node, "", "branch %s has an invalid timeout: %v", branch.Name, err)
+		}
+		if branch.Retry != nil {
+			if _, err := branch.Retry.Policy(); err != nil {
+				v.add(SeverityError, "invalid_retry", branch.Node, "", "branch %s has an invalid retry policy: %v", branch.Name, err)
+			}
+		}
+		if _, exists := v.declared[branch.Join]; !exists {
+			v.add(SeverityError, "unknown_target", branch.Join, "", "branch %s joins undeclared node %s", branch.Name, branch.Join)
+		}
+	}
+}
+
+// successors returns the nodes that may follow a node and whether it may finish the run
+func (v *validator) successors(name string) ([]string, bool) {
+	node := v.declared[name]
+	switch {
+	case node.Edge != "":
+		routes := v.routes[node.Edge]
+		if len(routes) == 0 {
+			return nil, true
+		}
+
+		var next []string
+		terminal := false
+		for _, route := range routes {
+			switch {
+			case route == EndRoute:
+				terminal = true
+			case strings.HasPrefix(route, BranchPrefix):
+				if branch, exists := v.branches[strings.TrimPrefix(route, BranchPrefix)]; exists {
+					next = append(next, branch.Join)
+				}
+			default:
+				next = append(next, route)
+			}
+		}
+		return next, terminal
+	case node.Next != "":
+		return []string{node.Next}, false
+	default:
+		return nil, true
+	}
+}
+
+// checkReachability verifies that every node is reachable from the entry
+// and that a terminal node is reachable from every node
+func (v *validator) checkReachability() {
+	if _, exists := v.declared[v.def.Entry]; !exists {
+		return
+	}
+
+	reached := v.walk(v.def.Entry)
+	for _, branch := range v.def.Branches {
+		// Branch nodes run on the way to the join node
+		if reached[branch.Join] {
+			reached[branch.Node] = true
+		}
+	}
+	for _, node := range v.def.Nodes {
+		if node.Name != "" && !reached[node.Name] {
+			v.add(SeverityWarning, "unreachable_node", node.Name, "", "node %s is not reachable from entry %s", node.Name, v.def.Entry)
+		}
+	}
+
+	// A node can finish if it is terminal or leads to a node that can finish
+	canFinish := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for name := range v.declared {
+			if canFinish[name] {
+				continue
+			}
+			next, terminal := v.successors(name)
+			finishes := terminal
+			for _, n := range next {
+				finishes = finishes || canFinish[n]
+			}
+			if finishes {
+				canFinish[name] = true
+				changed = true
+			}
+		}
+	}
+
+	if !canFinish[v.def.Entry] {
+		v.add(SeverityError, "no_terminal", v.def.Entry, "", "no terminal node is reachable from entry %s", v.def.Entry)
+		return
+	}
+	for _, node := range v.def.Nodes {
+		if reached[node.Name] && !canFinish[node.Name] {
+			v.add(SeverityError, "no_terminal", node.Name, "", "node %s can never reach a terminal node", node.Name)
+		}
+	}
+}
+
+// walk returns the set of declared nodes reachable from start
+func (v *validator) walk(start string) map[string]bool {
+	reached := map[string]bool{start: true}
+	queue := []string{start}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		next, _ := v.successors(current)
+		for _, n := range next {
+			if _, exists := v.declared[n]; exists && !reached[n] {
+				reached[n] = true
+				queue = append(queue, n)
+			}
+		}
+	}
+	return reached
+}
+
+// checkLoops warns about cycles in which no node limits its visits.
+// Such loops are only stopped by the engine's step limit.
+func (v *validator) checkLoops() {
+	for _, loop := range v.loops() {
+		bounded := false
+		for _, name := range loop {
+			bounded = bounded || v.declared[name].MaxVisits > 0
+		}
+		if !bounded {
+			v.add(SeverityWarning, "unbounded_loop", loop[0], "", "loop %s has no node with max_visits", strings.Join(append(loop, loop[0]), " → "))
+		}
+	}
+}
+
+// loops returns the strongly connected components of the graph that form a cycle,
+// each in declaration order
+func (v *validator) loops() [][]string {
+	index := make(map[string]int)
+	low := make(map[string]int)
+	onStack := make(map[string]bool)
+	component := make(map[string]int)
+	var stack []string
+	var count, components int
+
+	// Tarjan's algorithm
+	var connect func(name string)
+	connect = func(name string) {
+		index[name] = count
+		low[name] = count
+		count++
+		stack = append(stack, name)
+		onStack[name] = true
+
+		next, _ := v.successors(name)
+		for _, n := range next {
+			if _, exists := v.declared[n]; !exists {
+				continue
+			}
+			if _, visited := index[n]; !visited {
+				connect(n)
+				low[name] = min(low[name], low[n])
+			} else if onStack[n] {
+				low[name] = min(low[name], index[n])
+			}
+		}
+
+		if low[name] == index[name] {
+			for {
+				n := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[n] = false
+				component[n] = components
+				if n == name {
+					break
+				}
+			}
+			components++
+		}
+	}
+
+	for _, node := range v.def.Nodes {
+		if _, visited := index[node.Name]; node.Name != "" && !visited {
+			connect(node.Name)
+		}
+	}
+
+	members := make([][]string, components)
+	for _, node := range v.def.Nodes {
+		if c, exists := component[node.Name]; exists && !contains(members[c], node.Name) {
+			members[c] = append(members[c], node.Name)
+		}
+	}
+
+	var loops [][]string
+	for _, names := range members {
+		if len(names) > 1 || (len(names) == 1 && v.selfLoop(names[0])) {
+			loops = append(loops, names)
+		}
+	}
+	return loops
+}
+
+func (v *validator) selfLoop(name string) bool {
+	next, _ := v.successors(name)
+	return contains(next, name)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
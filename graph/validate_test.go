@@ -0,0 +1,333 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRegistries registers nodes a to e and worker, an edge per name in edges
+// returning the route it is named after, and the fan-out "split"
+func testRegistries(edges ...string) (*NodeRegistry, *EdgeRegistry, *BranchRegistry) {
+	nodes := &NodeRegistry{nodes: make(map[string]Node)}
+	for _, name := range []string{"a", "b", "c", "d", "e", "worker"} {
+		nodes.RegisterNode(name, func(ctx context.Context, state *AppState) error { return nil })
+	}
+
+	edgeRegistry := &EdgeRegistry{edges: make(map[string]Edge)}
+	for _, name := range edges {
+		route := strings.TrimPrefix(name, "to_")
+		edgeRegistry.RegisterEdge(name, func(ctx context.Context, state *AppState) (string, error) { return route, nil })
+	}
+
+	branches := &BranchRegistry{fanOuts: make(map[string]FanOut)}
+	branches.RegisterFanOut("split", FanOut{
+		Split:  func(state *AppState) ([]interface{}, error) { return []interface{}{1, 2}, nil },
+		Reduce: MergeBranches,
+	})
+	return nodes, edgeRegistry, branches
+}
+
+func parseTestDefinition(t *testing.T, definition string) *GraphDefinition {
+	t.Helper()
+	def, err := ParseDefinition([]byte(definition), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return def
+}
+
+func TestValidateDefinition(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		want       []string // severity, kind, node and edge of every issue, in order
+	}{
+		{
+			name: "valid graph with a bounded loop and a branch point",
+			definition: `
+entry: a
+nodes:
+  - {name: a, edge: to_branch:fan}
+  - {name: b, edge: to_a, max_visits: 3}
+  - {name: c}
+edges:
+  - {name: to_branch:fan, routes: ["branch:fan"]}
+  - {name: to_a, routes: [a, c]}
+branches:
+  - {name: fan, fanout: split, node: worker, join: b}`,
+		},
+		{
+			name:       "unnamed node",
+			definition: "entry: a\nnodes: [{name: a}, {next: a}]",
+			want:       []string{"error unnamed_node"},
+		},
+		{
+			name:       "duplicate node",
+			definition: "entry: a\nnodes: [{name: a}, {name: a}]",
+			want:       []string{"error duplicate_node a"},
+		},
+		{
+			name:       "unregistered node",
+			definition: "entry: a\nnodes: [{name: a, next: z}, {name: z}]",
+			want:       []string{"error unknown_node z"},
+		},
+		{
+			name:       "invalid retry policy",
+			definition: "entry: a\nnodes: [{name: a, retry: {max_attempts: 0}}]",
+			want:       []string{"error invalid_retry a"},
+		},
+		{
+			name:       "invalid timeout",
+			definition: "entry: a\nnodes: [{name: a, timeout: -1s}]",
+			want:       []string{"error invalid_timeout a"},
+		},
+		{
+			name:       "negative max visits",
+			definition: "entry: a\nnodes: [{name: a, max_visits: -1}]",
+			want:       []string{"error invalid_max_visits a"},
+		},
+		{
+			name:       "edge and next",
+			definition: "entry: a\nnodes: [{name: a, edge: to_b, next: b}, {name: b}]\nedges: [{name: to_b, routes: [b]}]",
+			want:       []string{"error ambiguous_transition a to_b"},
+		},
+		{
+			name:       "unregistered edge of a node",
+			definition: "entry: a\nnodes: [{name: a, edge: to_z}]\nedges: [{name: to_z, routes: [END]}]",
+			want:       []string{"error unknown_edge a to_z", "error unknown_edge  to_z"},
+		},
+		{
+			name:       "next to an undeclared node",
+			definition: "entry: a\nnodes: [{name: a, next: b}]",
+			want:       []string{"error unknown_target a", "error no_terminal a"},
+		},
+		{
+			name:       "edge without declared routes",
+			definition: "entry: a\nnodes: [{name: a, edge: to_b}, {name: b}]",
+			want:       []string{"error undeclared_edge a to_b", "warning unreachable_node b"},
+		},
+		{
+			name:       "missing entry",
+			definition: "nodes: [{name: a}]",
+			want:       []string{"error missing_entry"},
+		},
+		{
+			name:       "undeclared entry",
+			definition: "entry: b\nnodes: [{name: a}]",
+			want:       []string{"error unknown_entry b"},
+		},
+		{
+			name:       "unused edge",
+			definition: "entry: a\nnodes: [{name: a}]\nedges: [{name: to_b, routes: [a]}]",
+			want:       []string{"warning unused_edge  to_b"},
+		},
+		{
+			name:       "route to an undeclared branch",
+			definition: "entry: a\nnodes: [{name: a, edge: to_b}, {name: b}]\nedges: [{name: to_b, routes: [b, branch:fan]}]",
+			want:       []string{"error unknown_branch  to_b"},
+		},
+		{
+			name:       "route to an undeclared node",
+			definition: "entry: a\nnodes: [{name: a, edge: to_b}]\nedges: [{name: to_b, routes: [b, END]}]",
+			want:       []string{"error unknown_target  to_b"},
+		},
+		{
+			name: "branch with an unregistered fan-out and node",
+			definition: `
+entry: a
+nodes: [{name: a, edge: to_branch:fan}, {name: b}]
+edges: [{name: to_branch:fan, routes: ["branch:fan"]}]
+branches: [{name: fan, fanout: fetch, node: fetcher, join: b}]`,
+			want: []string{"error unknown_fanout", "error unknown_node fetcher"},
+		},
+		{
+			name: "invalid branch settings",
+			definition: `
+entry: a
+nodes: [{name: a, edge: to_branch:fan}, {name: b}]
+edges: [{name: to_branch:fan, routes: ["branch:fan"]}]
+branches: [{name: fan, fanout: split, node: worker, join: b, max_concurrency: -1, timeout: soon, retry: {max_attempts: 2, backoff: later}}]`,
+			want: []string{"error invalid_branch", "error invalid_timeout worker", "error invalid_retry worker"},
+		},
+		{
+			name: "branch joining an undeclared node",
+			definition: `
+entry: a
+nodes: [{name: a, edge: to_branch:fan}]
+edges: [{name: to_branch:fan, routes: ["branch:fan"]}]
+branches: [{name: fan, fanout: split, node: worker, join: z}]`,
+			want: []string{"error unknown_target z", "error no_terminal a"},
+		},
+		{
+			name:       "unreachable node",
+			definition: "entry: a\nnodes: [{name: a}, {name: b, next: a}]",
+			want:       []string{"warning unreachable_node b"},
+		},
+		{
+			name:       "no terminal node reachable from the entry",
+			definition: "entry: a\nnodes: [{name: a, next: b, max_visits: 2}, {name: b, next: a}]",
+			want:       []string{"error no_terminal a"},
+		},
+		{
+			name:       "node that cannot reach a terminal node",
+			definition: "entry: a\nnodes: [{name: a, edge: to_b}, {name: b, next: c}, {name: c, next: b, max_visits: 2}]\nedges: [{name: to_b, routes: [b, END]}]",
+			want:       []string{"error no_terminal b", "error no_terminal c"},
+		},
+		{
+			name:       "unbounded loop",
+			definition: "entry: a\nnodes: [{name: a, edge: to_b}, {name: b, next: a}]\nedges: [{name: to_b, routes: [b, END]}]",
+			want:       []string{"warning unbounded_loop a"},
+		},
+		{
+			name:       "unbounded self loop",
+			definition: "entry: a\nnodes: [{name: a, edge: to_a}]\nedges: [{name: to_a, routes: [a, END]}]",
+			want:       []string{"warning unbounded_loop a"},
+		},
+		{
+			name: "only the unbounded one of two loops",
+			definition: `
+entry: a
+nodes:
+  - {name: a, edge: to_a, max_visits: 2}
+  - {name: b, edge: to_c}
+  - {name: c, edge: to_d}
+  - {name: d, next: b}
+edges:
+  - {name: to_a, routes: [a, b]}
+  - {name: to_c, routes: [c, END]}
+  - {name: to_d, routes: [d, END]}`,
+			want: []string{"warning unbounded_loop b"},
+		},
+		{
+			name: "loop through a branch point",
+			definition: `
+entry: a
+nodes: [{name: a, edge: to_branch:fan}, {name: b, edge: to_a}]
+edges:
+  - {name: to_branch:fan, routes: ["branch:fan"]}
+  - {name: to_a, routes: [a, END]}
+branches: [{name: fan, fanout: split, node: worker, join: b}]`,
+			want: []string{"warning unbounded_loop a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes, edges, branches := testRegistries("to_a", "to_b", "to_c", "to_d", "to_branch:fan")
+			issues := ValidateDefinition(parseTestDefinition(t, tt.definition), nodes, edges, branches)
+
+			var got []string
+			for _, issue := range issues {
+				got = append(got, strings.TrimRight(fmt.Sprintf("%s %s %s %s", issue.Severity, issue.Kind, issue.Node, issue.Edge), " "))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("issues = %q, want %q\n%v", got, tt.want, issues)
+			}
+		})
+	}
+}
+
+func TestValidateNamesLoops(t *testing.T) {
+	nodes, edges, branches := testRegistries("to_a", "to_b")
+	def := parseTestDefinition(t, `
+entry: a
+nodes: [{name: a, next: b}, {name: b, next: c}, {name: c, edge: to_a}]
+edges: [{name: to_a, routes: [a, END]}]`)
+
+	issues := ValidateDefinition(def, nodes, edges, branches)
+	if len(issues) != 1 || issues[0].Message != "loop a → b → c → a has no node with max_visits" {
+		t.Errorf("issues = %v, want the unbounded loop a → b → c → a", issues)
+	}
+}
+
+func TestDefinitionValidateReportsErrorsOnly(t *testing.T) {
+	nodes, edges, branches := testRegistries("to_b")
+	def := parseTestDefinition(t, "name: broken\nentry: a\nnodes: [{name: a, max_visits: -1}, {name: b}]")
+
+	err := def.Validate(nodes, edges, branches)
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Validate() error = %v, want a validation error", err)
+	}
+	if invalid.Graph != "broken" || len(invalid.Issues) != 1 || invalid.Issues[0].Kind != "invalid_max_visits" {
+		t.Errorf("Validate() error = %v, want only the invalid max_visits", err)
+	}
+
+	// Warnings alone do not stop a graph
+	def = parseTestDefinition(t, "entry: a\nnodes: [{name: a}, {name: b}]")
+	if err := def.Validate(nodes, edges, branches); err != nil {
+		t.Errorf("Validate() error = %v, want none for an unreachable node", err)
+	}
+}
+
+func TestBuiltInDefinitionsAreValid(t *testing.T) {
+	for _, path := range []string{"definitions/research.yaml", "definitions/iterative-research.yaml", "definitions/research-subgraph.yaml"} {
+		t.Run(path, func(t *testing.T) {
+			def, err := LoadDefinition(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			engine, err := NewEngine("", "", WithFakeLLM(), WithDefinition(def))
+			if err != nil {
+				t.Fatalf("NewEngine() error = %v", err)
+			}
+			if issues := engine.Validate(); len(issues) > 0 {
+				t.Errorf("issues = %v", issues)
+			}
+		})
+	}
+}
+
+func TestUndeclaredRouteFailsTheRun(t *testing.T) {
+	node := func(ctx context.Context, state *AppState) error { return nil }
+	engine, err := NewBuilder().
+		AddNode("a", node).
+		AddNode("b", node).
+		AddNode("c", node).
+		AddConditionalEdge("a", func(ctx context.Context, state *AppState) (string, error) { return "c", nil }, "b", EndRoute).
+		AddEdge("b", "c").
+		SetEntry("a").
+		Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := engine.Execute(context.Background(), "input")
+	if err == nil || !strings.Contains(err.Error(), `edge after_a returned "c", which is not one of its declared routes [b END]`) {
+		t.Fatalf("Execute() error = %v, want the undeclared route", err)
+	}
+	if !reflect.DeepEqual(result.Path, []string{"a"}) {
+		t.Errorf("path = %v, want [a]", result.Path)
+	}
+}
+
+func TestDeclaresRoute(t *testing.T) {
+	flow := &GraphFlow{EdgeRoutes: map[string][]string{
+		"route":    {"a", "branch:fan"},
+		"finish":   {"a", EndRoute},
+		"terminal": nil,
+	}}
+
+	tests := []struct {
+		edge, next string
+		want       bool
+	}{
+		{"route", "a", true},
+		{"route", "branch:fan", true},
+		{"route", "b", false},
+		{"route", "", false},
+		{"finish", "", true},
+		{"finish", "a", true},
+		{"terminal", "", true},
+		{"terminal", "a", false},
+	}
+	for _, tt := range tests {
+		if got := flow.declaresRoute(tt.edge, tt.next); got != tt.want {
+			t.Errorf("declaresRoute(%q, %q) = %v, want %v", tt.edge, tt.next, got, tt.want)
+		}
+	}
+}
@@ -1,26 +1,40 @@
 package config
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/spf13/viper"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/takako/openai-go-demo/graph"
+	"github.com/takako/openai-go-demo/graph/utils"
 )
 
 // Config holds all configuration for the application
 type Config struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	OpenAI   OpenAIConfig   `mapstructure:"openai"`
-	SerpAPI  SerpAPIConfig  `mapstructure:"serpapi"`
-	Graph    GraphConfig    `mapstructure:"graph"`
-	Logging  LoggingConfig  `mapstructure:"logging"`
+	Server    ServerConfig    `mapstructure:"server"`
+	OpenAI    OpenAIConfig    `mapstructure:"openai"`
+	LLM       LLMConfig       `mapstructure:"llm"`
+	SerpAPI   SerpAPIConfig   `mapstructure:"serpapi"`
+	Graph     GraphConfig     `mapstructure:"graph"`
+	Logging   LoggingConfig   `mapstructure:"logging"`
+	Telemetry TelemetryConfig `mapstructure:"telemetry"`
+
+	// runs replay traces, so the LLM provider is never called
+	replay bool
 }
 
 type ServerConfig struct {
-	Port     string `mapstructure:"port"`
-	Host     string `mapstructure:"host"`
-	BasePath string `mapstructure:"base_path"`
+	Port        string `mapstructure:"port"`
+	Host        string `mapstructure:"host"`
+	BasePath    string `mapstructure:"base_path"`
+	MetricsPath string `mapstructure:"metrics_path"` // Prometheus endpoint ("" = off)
 }
 
 type OpenAIConfig struct {
@@ -28,23 +42,88 @@ type OpenAIConfig struct {
 	Model  string `mapstructure:"model"`
 }
 
+type LLMConfig struct {
+	Provider string `mapstructure:"provider"` // "openai", "anthropic", "ollama", "openai_compatible" or "fake"
+	Model    string `mapstructure:"model"`    // "" = openai.model for OpenAI, else the provider's default
+	BaseURL  string `mapstructure:"base_url"` // endpoint of the provider (required for openai_compatible)
+	APIKey   string `mapstructure:"api_key"`  // key of the provider (openai.api_key for OpenAI)
+	// Nodes overrides the model and sampling of single nodes, keyed by node name
+	Nodes map[string]NodeLLMConfig `mapstructure:"nodes"`
+}
+
+type NodeLLMConfig struct {
+	Model       string   `mapstructure:"model"`       // "" = llm.model
+	Temperature *float64 `mapstructure:"temperature"` // unset = the model's default
+	MaxTokens   int      `mapstructure:"max_tokens"`  // 0 = the model's default
+}
+
 type SerpAPIConfig struct {
 	APIKey  string `mapstructure:"api_key"`
 	Enabled bool   `mapstructure:"enabled"`
 }
 
 type GraphConfig struct {
-	MaxSteps int `mapstructure:"max_steps"`
-	Timeout  int `mapstructure:"timeout_seconds"`
+	MaxSteps        int              `mapstructure:"max_steps"`
+	Timeout         int              `mapstructure:"timeout_seconds"`      // deadline of a whole run
+	NodeTimeout     int              `mapstructure:"node_timeout_seconds"` // default per-node timeout (0 = none)
+	NodeTimeouts    map[string]int   `mapstructure:"node_timeouts"`        // per-node timeouts in seconds
+	DefinitionFile  string           `mapstructure:"definition_file"`
+	Checkpoint      CheckpointConfig `mapstructure:"checkpoint"`
+	InterruptBefore []string         `mapstructure:"interrupt_before"` // nodes to pause before for review
+	InterruptAfter  []string         `mapstructure:"interrupt_after"`  // nodes to pause after for review
+	TraceDir        string           `mapstructure:"trace_dir"`        // directory runs are traced into ("" = off)
+	Budget          BudgetConfig     `mapstructure:"budget"`
+	// Prices adds to or overrides graph.DefaultPrices, in USD per million tokens
+	Prices map[string]graph.ModelPrice `mapstructure:"prices"`
+}
+
+type BudgetConfig struct {
+	MaxTokens  int     `mapstructure:"max_tokens"`  // tokens a run may use (0 = unlimited)
+	MaxCost    float64 `mapstructure:"max_cost"`    // USD a run may spend (0 = unlimited)
+	OnExceeded string  `mapstructure:"on_exceeded"` // "abort" or "degrade"
+}
+
+type CheckpointConfig struct {
+	Store      string `mapstructure:"store"`       // "none", "memory", "file" or "sqlite"
+	Path       string `mapstructure:"path"`        // directory (file) or database file (sqlite)
+	MaxRuns    int    `mapstructure:"max_runs"`    // finished runs the memory store keeps (0 = unlimited)
+	TTLSeconds int    `mapstructure:"ttl_seconds"` // lifetime of finished runs in the memory store (0 = forever)
 }
 
 type LoggingConfig struct {
-	Level  string `mapstructure:"level"`
-	Format string `mapstructure:"format"`
+	Level  string `mapstructure:"level"`  // "debug", "info", "warn" or "error"
+	Format string `mapstructure:"format"` // "text" or "json"
+}
+
+type TelemetryConfig struct {
+	Enabled     bool    `mapstructure:"enabled"`      // export OpenTelemetry spans of runs
+	Endpoint    string  `mapstructure:"endpoint"`     // OTLP/HTTP collector, host:port
+	Insecure    bool    `mapstructure:"insecure"`     // plain HTTP instead of TLS
+	ServiceName string  `mapstructure:"service_name"` // service.name of the exported spans
+	SampleRatio float64 `mapstructure:"sample_ratio"` // share of runs traced, 0 to 1
+}
+
+// LoadOption adjusts the loaded configuration before it is validated
+type LoadOption func(*Config)
+
+// ForReplay loads the configuration for replaying traces. Replays answer every
+// LLM and search call from the trace, so the provider needs no credentials.
+func ForReplay() LoadOption {
+	return func(c *Config) {
+		c.replay = true
+	}
+}
+
+// WithLLMProvider selects the LLM provider over the config file and LLM_PROVIDER,
+// e.g. graph.ProviderFake for a command line flag
+func WithLLMProvider(provider string) LoadOption {
+	return func(c *Config) {
+		c.LLM.Provider = provider
+	}
 }
 
 // Load loads configuration from various sources (env vars, config files, defaults)
-func Load() (*Config, error) {
+func Load(opts ...LoadOption) (*Config, error) {
 	v := viper.New()
 	
 	// Set defaults
@@ -63,6 +142,10 @@ func Load() (*Config, error) {
 	
 	// Map environment variables to config keys
 	v.BindEnv("openai.api_key", "OPENAI_API_KEY")
+	v.BindEnv("llm.provider", "LLM_PROVIDER")
+	v.BindEnv("llm.model", "LLM_MODEL")
+	v.BindEnv("llm.base_url", "LLM_BASE_URL")
+	v.BindEnv("llm.api_key", "LLM_API_KEY")
 	v.BindEnv("serpapi.api_key", "SERPAPI_KEY")
 	v.BindEnv("server.port", "PORT")
 	
@@ -82,6 +165,9 @@ func Load() (*Config, error) {
 	if err := v.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
+	for _, opt := range opts {
+		opt(&config)
+	}
 	
 	// Validate required fields
 	if err := validateConfig(&config); err != nil {
@@ -91,25 +177,81 @@ func Load() (*Config, error) {
 	return &config, nil
 }
 
+// Defaults returns the default configuration without reading files or the environment.
+// Front ends without a config file (e.g. WebAssembly) start from it.
+func Defaults() *Config {
+	v := viper.New()
+	setDefaults(v)
+
+	var config Config
+	if err := v.Unmarshal(&config); err != nil {
+		panic(fmt.Sprintf("default configuration is invalid: %v", err))
+	}
+	return &config
+}
+
 func setDefaults(v *viper.Viper) {
 	// Server defaults
 	v.SetDefault("server.port", "8080")
 	v.SetDefault("server.host", "localhost")
 	v.SetDefault("server.base_path", "")
+	v.SetDefault("server.metrics_path", "/metrics")
 	
 	// OpenAI defaults
 	v.SetDefault("openai.model", "gpt-4o-2024-08-06")
 	
+	// LLM defaults
+	v.SetDefault("llm.provider", graph.ProviderOpenAI)
+	v.SetDefault("llm.model", "")
+	v.SetDefault("llm.base_url", "")
+	
 	// SerpAPI defaults
 	v.SetDefault("serpapi.enabled", true)
 	
 	// Graph defaults
 	v.SetDefault("graph.max_steps", 25)
 	v.SetDefault("graph.timeout_seconds", 300)
+	v.SetDefault("graph.node_timeout_seconds", 0)
+	v.SetDefault("graph.node_timeouts.search_query", 30) // each search, also inside search_and_merge
+	v.SetDefault("graph.definition_file", "")
+	v.SetDefault("graph.checkpoint.store", "memory")
+	v.SetDefault("graph.checkpoint.path", "")
+	v.SetDefault("graph.checkpoint.max_runs", graph.DefaultMemoryMaxRuns)
+	v.SetDefault("graph.checkpoint.ttl_seconds", int(graph.DefaultMemoryTTL/time.Second))
+	v.SetDefault("graph.interrupt_before", []string{})
+	v.SetDefault("graph.interrupt_after", []string{})
+	v.SetDefault("graph.trace_dir", "")
+	v.SetDefault("graph.budget.max_tokens", 0)
+	v.SetDefault("graph.budget.max_cost", 0.0)
+	v.SetDefault("graph.budget.on_exceeded", graph.BudgetAbort)
 	
 	// Logging defaults
 	v.SetDefault("logging.level", "info")
 	v.SetDefault("logging.format", "text")
+	
+	// Telemetry defaults (a local OpenTelemetry collector)
+	v.SetDefault("telemetry.enabled", false)
+	v.SetDefault("telemetry.endpoint", "localhost:4318")
+	v.SetDefault("telemetry.insecure", true)
+	v.SetDefault("telemetry.service_name", "research-assistant")
+	v.SetDefault("telemetry.sample_ratio", 1.0)
+}
+
+// validateProvider checks that the LLM provider can be called; replays never call it
+func (c *Config) validateProvider() error {
+	if c.replay {
+		return nil
+	}
+	if c.LLM.Provider == graph.ProviderOpenAI && (c.OpenAI.APIKey == "" || c.OpenAI.APIKey == "your-api-key-here") {
+		return fmt.Errorf("OPENAI_API_KEY is required")
+	}
+	if err := c.Provider().Validate(); err != nil {
+		return fmt.Errorf("llm: %w", err)
+	}
+	if _, err := c.Provider().Model(c.LLMModel()); err != nil {
+		return fmt.Errorf("llm: %w", err)
+	}
+	return nil
 }
 
 func loadDotEnv() {
@@ -135,9 +277,17 @@ func loadDotEnv() {
 }
 
 func validateConfig(config *Config) error {
-	// Validate required OpenAI API key
-	if config.OpenAI.APIKey == "" || config.OpenAI.APIKey == "your-api-key-here" {
-		return fmt.Errorf("OPENAI_API_KEY is required")
+	// Validate the LLM provider, which requires OPENAI_API_KEY by default
+	if err := config.validateProvider(); err != nil {
+		return err
+	}
+	for node, settings := range config.LLM.Nodes {
+		if settings.Temperature != nil && *settings.Temperature < 0 {
+			return fmt.Errorf("llm temperature of node %s must not be negative", node)
+		}
+		if settings.MaxTokens < 0 {
+			return fmt.Errorf("llm max_tokens of node %s must not be negative", node)
+		}
 	}
 	
 	// Validate server port
@@ -154,14 +304,193 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("graph timeout must be positive")
 	}
 	
+	if config.Graph.NodeTimeout < 0 {
+		return fmt.Errorf("graph node_timeout_seconds must not be negative")
+	}
+	
+	if config.Graph.Checkpoint.MaxRuns < 0 || config.Graph.Checkpoint.TTLSeconds < 0 {
+		return fmt.Errorf("graph checkpoint max_runs and ttl_seconds must not be negative")
+	}
+	
+	for node, seconds := range config.Graph.NodeTimeouts {
+		if seconds <= 0 {
+			return fmt.Errorf("graph node timeout for %s must be positive", node)
+		}
+	}
+	
+	budget := config.Graph.Budget
+	if budget.MaxTokens < 0 || budget.MaxCost < 0 {
+		return fmt.Errorf("graph budget limits must not be negative")
+	}
+	if budget.OnExceeded != graph.BudgetAbort && budget.OnExceeded != graph.BudgetDegrade {
+		return fmt.Errorf("graph budget on_exceeded must be %q or %q", graph.BudgetAbort, graph.BudgetDegrade)
+	}
+	
+	if _, err := utils.NewLogHandler(io.Discard, config.Logging.Format, config.Logging.Level); err != nil {
+		return fmt.Errorf("logging: %w", err)
+	}
+	
+	if config.Telemetry.SampleRatio < 0 || config.Telemetry.SampleRatio > 1 {
+		return fmt.Errorf("telemetry sample_ratio must be between 0 and 1")
+	}
+	
 	return nil
 }
 
+// NewLogger creates the logger configured by logging.format and logging.level.
+// Records logged within graph runs carry the run ID, node, branch ID and intent.
+func (c LoggingConfig) NewLogger(w io.Writer) (*slog.Logger, error) {
+	handler, err := utils.NewLogHandler(w, c.Format, c.Level)
+	if err != nil {
+		return nil, err
+	}
+	return slog.New(graph.LogHandler(handler)), nil
+}
+
 // IsSerpAPIEnabled returns true if SerpAPI is configured and enabled
 func (c *Config) IsSerpAPIEnabled() bool {
 	return c.SerpAPI.Enabled && c.SerpAPI.APIKey != ""
 }
 
+// Provider returns the LLM backend selected by llm.provider
+func (c *Config) Provider() graph.Provider {
+	provider := graph.Provider{Name: c.LLM.Provider, APIKey: c.LLM.APIKey, BaseURL: c.LLM.BaseURL}
+	if provider.Name == graph.ProviderOpenAI && provider.APIKey == "" {
+		provider.APIKey = c.OpenAI.APIKey
+	}
+	return provider
+}
+
+// LLMModel returns the model the nodes use ("" for the provider's default)
+func (c *Config) LLMModel() string {
+	if c.LLM.Model == "" && c.LLM.Provider == graph.ProviderOpenAI {
+		return c.OpenAI.Model
+	}
+	return c.LLM.Model
+}
+
+// EngineOptions returns graph engine options derived from the configuration
+func (c *Config) EngineOptions() ([]graph.EngineOption, error) {
+	provider := c.Provider()
+	newModel := provider.NewModel
+	if c.replay {
+		// Calls are answered from the trace before they reach the model
+		newModel = func(string) (llms.Model, error) { return graph.NewFakeModel(), nil }
+	}
+	model, err := provider.Model(c.LLMModel())
+	if err != nil && !c.replay {
+		return nil, err
+	}
+	llm, err := newModel(model)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []graph.EngineOption{
+		graph.WithLLM(llm, model),
+		graph.WithMaxSteps(c.Graph.MaxSteps),
+		graph.WithRunTimeout(time.Duration(c.Graph.Timeout) * time.Second),
+	}
+
+	// Nodes with a model of their own share the provider of the others
+	for node, settings := range c.LLM.Nodes {
+		nodeModel := graph.NodeModel{Temperature: settings.Temperature, MaxTokens: settings.MaxTokens}
+		if settings.Model != "" && settings.Model != model {
+			llm, err := newModel(settings.Model)
+			if err != nil {
+				return nil, err
+			}
+			nodeModel.LLM, nodeModel.Model = llm, settings.Model
+		}
+		opts = append(opts, graph.WithNodeModel(node, nodeModel))
+	}
+
+	if c.Graph.NodeTimeout > 0 {
+		opts = append(opts, graph.WithDefaultNodeTimeout(time.Duration(c.Graph.NodeTimeout)*time.Second))
+	}
+	for node, seconds := range c.Graph.NodeTimeouts {
+		opts = append(opts, graph.WithNodeTimeout(node, time.Duration(seconds)*time.Second))
+	}
+
+	if c.Graph.DefinitionFile != "" {
+		def, err := graph.LoadDefinition(c.Graph.DefinitionFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, graph.WithDefinition(def))
+	}
+
+	store, err := NewCheckpointStore(c.Graph.Checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	if store != nil {
+		opts = append(opts, graph.WithCheckpointStore(store))
+	}
+
+	if len(c.Graph.InterruptBefore) > 0 {
+		opts = append(opts, graph.WithInterruptBefore(c.Graph.InterruptBefore...))
+	}
+	if len(c.Graph.InterruptAfter) > 0 {
+		opts = append(opts, graph.WithInterruptAfter(c.Graph.InterruptAfter...))
+	}
+
+	if c.Graph.TraceDir != "" {
+		opts = append(opts, graph.WithTraceDir(c.Graph.TraceDir))
+	}
+
+	if budget := c.Graph.Budget; budget.MaxTokens > 0 || budget.MaxCost > 0 {
+		opts = append(opts, graph.WithBudget(graph.Budget{
+			MaxTokens:  budget.MaxTokens,
+			MaxCost:    budget.MaxCost,
+			OnExceeded: budget.OnExceeded,
+		}))
+	}
+	if len(c.Graph.Prices) > 0 {
+		prices := make(map[string]graph.ModelPrice, len(graph.DefaultPrices)+len(c.Graph.Prices))
+		for model, price := range graph.DefaultPrices {
+			prices[model] = price
+		}
+		for model, price := range c.Graph.Prices {
+			prices[model] = price
+		}
+		opts = append(opts, graph.WithPrices(prices))
+	}
+
+	return opts, nil
+}
+
+// NewCheckpointStore creates the configured checkpoint store (nil for "none")
+func NewCheckpointStore(cfg CheckpointConfig) (graph.CheckpointStore, error) {
+	kind, path := cfg.Store, cfg.Path
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "memory":
+		// Bound the memory of long-running servers
+		store := graph.NewMemoryCheckpointStore()
+		store.MaxRuns = cfg.MaxRuns
+		store.TTL = time.Duration(cfg.TTLSeconds) * time.Second
+		return store, nil
+	case "file":
+		if path == "" {
+			path = "checkpoints"
+		}
+		return graph.NewFileCheckpointStore(path)
+	case "sqlite":
+		if path == "" {
+			path = "checkpoints.db"
+		}
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open checkpoint database: %w", err)
+		}
+		return graph.NewSQLiteCheckpointStore(context.Background(), db)
+	default:
+		return nil, fmt.Errorf("unknown checkpoint store %q", kind)
+	}
+}
+
 // GetServerAddr returns the full server address
 func (c *Config) GetServerAddr() string {
 	return fmt.Sprintf("%s:%s", c.Server.Host, c.Server.Port)
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/takako/openai-go-demo/graph"
+)
+
+// fakeDefaults is the default configuration calling the fake LLM
+func fakeDefaults() *Config {
+	config := Defaults()
+	config.LLM.Provider = graph.ProviderFake
+	return config
+}
+
+func TestValidateConfigLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   string // part of the error, empty when valid
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"no steps", func(c *Config) { c.Graph.MaxSteps = 0 }, "max_steps must be positive"},
+		{"no run timeout", func(c *Config) { c.Graph.Timeout = 0 }, "timeout must be positive"},
+		{"negative default node timeout", func(c *Config) { c.Graph.NodeTimeout = -1 }, "node_timeout_seconds must not be negative"},
+		{"zero node timeout", func(c *Config) { c.Graph.NodeTimeouts = map[string]int{"search_query": 0} }, "node timeout for search_query must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := fakeDefaults()
+			tt.modify(config)
+
+			err := validateConfig(config)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("validateConfig() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("validateConfig() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngineOptionsApplyLimits(t *testing.T) {
+	config := fakeDefaults()
+	config.Graph.MaxSteps = 2
+
+	opts, err := config.EngineOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := graph.NewEngine("", "", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = engine.Execute(context.Background(), "LLMの最新動向を調べて")
+	if !errors.Is(err, graph.ErrMaxSteps) {
+		t.Errorf("Execute() error = %v, want the step limit of the configuration", err)
+	}
+}
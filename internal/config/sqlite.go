@@ -0,0 +1,7 @@
+//go:build !js
+
+package config
+
+import (
+	_ "modernc.org/sqlite" // SQLite driver for the checkpoint store
+)
@@ -34,6 +34,15 @@ type SearchResult struct {
 	Snippet string `json:"snippet"`
 }
 
+// StatusError is returned when SerpAPI answers with a non-200 status
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("SerpAPI returned status %d", e.StatusCode)
+}
+
 // SerpAPIResponse represents the response from SerpAPI
 type SerpAPIResponse struct {
 	OrganicResults []SearchResult `json:"organic_results"`
@@ -72,7 +81,7 @@ func (c *SerpAPIClient) Search(ctx context.Context, query string) ([]SearchResul
 	
 	// Check status code
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("SerpAPI returned status %d", resp.StatusCode)
+		return nil, &StatusError{StatusCode: resp.StatusCode}
 	}
 	
 	// Read response body
@@ -8,6 +8,9 @@ class ResearchApp {
         this.executionTimer = null;
         this.reportBuffer = '';
         this.currentReportNode = null;
+        this.currentRunId = null;
+        this.runState = null;
+        this.stateVersion = 0;
         
         this.init();
     }
@@ -16,6 +19,9 @@ class ResearchApp {
         // Setup WebSocket event handling
         this.wsManager.onGraphUpdate((update) => this.handleGraphUpdate(update));
         
+        // Draw the engine's graph
+        this.graphManager.loadTopology();
+
         // Connect WebSocket
         this.wsManager.connect();
         
@@ -32,6 +38,11 @@ class ResearchApp {
             this.startResearch();
         });
 
+        // Cancel the running research
+        document.getElementById('cancelBtn').addEventListener('click', () => {
+            this.cancelResearch();
+        });
+
         // Enter key support for input
         document.getElementById('queryInput').addEventListener('keypress', (e) => {
             if (e.key === 'Enter') {
@@ -52,13 +63,15 @@ class ResearchApp {
 
     handleGraphUpdate(update) {
         const { type, node, chunk, error } = update;
+        this.trackState(update);
         
         switch(type) {
             case 'start':
+                this.currentRunId = update.run_id;
                 this.startExecution();
                 break;
             case 'node_start':
-                this.handleNodeStart(node);
+                this.handleNodeStart(node, update.path);
                 break;
             case 'node_complete':
                 this.handleNodeComplete(node, update);
@@ -70,20 +83,152 @@ class ResearchApp {
                 this.completeExecution();
                 break;
             case 'error':
-                this.handleError(node, error);
+                this.handleError(node, error, update.timeout);
+                break;
+            case 'interrupt':
+                this.handleInterrupt(update);
                 break;
+            case 'retry':
+                this.handleRetry(node, update);
+                break;
+            case 'cancelled':
+                this.handleCancelled(node);
+                break;
+        }
+
+        // Finished runs report what they spent
+        if (['complete', 'error', 'cancelled'].includes(type) && update.usage) {
+            this.logUsage(update.usage);
+        }
+    }
+
+    logUsage(usage) {
+        const total = usage.prompt_tokens + usage.completion_tokens;
+        if (total === 0) {
+            return;
+        }
+        this.addLog(`🪙 トークン: ${total} (プロンプト ${usage.prompt_tokens} / 補完 ${usage.completion_tokens}) - $${usage.cost.toFixed(4)}`, 'info');
+        if (usage.budget_exceeded) {
+            this.addLog('💸 予算の上限に達しました', 'warning');
+        }
+    }
+
+    // Keep the run's state in sync from the start snapshot and the patches that follow
+    trackState(update) {
+        if (update.state && (update.type === 'start' || update.type === 'snapshot')) {
+            this.runState = update.state;
+            this.stateVersion = update.version || 0;
+            return;
+        }
+        if (!update.patch || !this.runState || update.version <= this.stateVersion) {
+            return;
+        }
+        if (update.version !== this.stateVersion + 1) {
+            // A patch was missed; ask for the latest state
+            this.wsManager.sendSnapshotRequest(update.run_id);
+            return;
+        }
+        this.runState = this.applyStatePatch(this.runState, update.patch);
+        this.stateVersion = update.version;
+    }
+
+    applyStatePatch(state, patch) {
+        for (const op of patch) {
+            if (op.path === '') {
+                state = op.value;
+                continue;
+            }
+            const keys = op.path.split('/').slice(1).map(key => key.replace(/~1/g, '/').replace(/~0/g, '~'));
+            const last = keys.pop();
+            const parent = keys.reduce((target, key) => target[key], state);
+            if (op.op === 'remove') {
+                delete parent[last];
+            } else if (Array.isArray(parent) && last === '-') {
+                parent.push(op.value);
+            } else {
+                parent[last] = op.value;
+            }
+        }
+        return state;
+    }
+
+    cancelResearch() {
+        if (this.currentRunId && this.wsManager.sendCancelRequest(this.currentRunId)) {
+            document.getElementById('cancelBtn').disabled = true;
+            this.addLog('⏹️ 中止を要求しました', 'warning');
         }
     }
 
-    handleNodeStart(node) {
+    handleCancelled(node) {
+        clearInterval(this.executionTimer);
+        this.graphManager.updateNodeStatus(node, 'error', '中止');
+        document.getElementById('startBtn').disabled = false;
+        document.getElementById('cancelBtn').disabled = true;
+        this.addLog(`⏹️ 調査を中止しました (run ${this.currentRunId})`, 'warning');
+        this.wsManager.setResearchState(false, true);
+    }
+
+    handleRetry(node, update) {
+        this.graphManager.updateNodeStatus(node, 'in-progress', `再試行 ${update.attempt}`);
+        this.addLog(`🔁 ${this.graphManager.getNodeDisplayName(node, update.path)}: 再試行 (${update.attempt}回目) - ${update.error}`, 'warning');
+
+        // The report streams again from the start
+        if (node === 'synthesize_and_report') {
+            this.reportBuffer = '';
+        }
+    }
+
+    handleInterrupt(update) {
+        const { run_id: runId, node, interrupt, state } = update;
+        clearInterval(this.executionTimer);
+        document.getElementById('cancelBtn').disabled = true;
+        this.wsManager.setResearchState(false);
+        this.addLog(`⏸️ ${this.graphManager.getNodeDisplayName(node)}: レビュー待ち`, 'warning');
+
+        // Let the user review pending search queries before any search fires
+        if (node === 'generate_search_queries' && interrupt?.when === 'after') {
+            const current = (state.search_queries || []).join('; ');
+            const edited = prompt('検索クエリを確認・編集してください（; 区切り）', current);
+            if (edited === null) {
+                this.addLog(`⏹️ 実行を保留しました (run ${runId})`, 'info');
+                return;
+            }
+            state.search_queries = edited.split(';').map(q => q.trim()).filter(q => q);
+            this.addLog(`✏️ ${state.search_queries.length}個のクエリで再開します`, 'info');
+            this.wsManager.sendResumeRequest(runId, state);
+            return;
+        }
+
+        // Let the user review the outline before the report is written along it
+        if (node === 'synthesize_and_report' && state.outline?.length) {
+            const edited = prompt('レポートのアウトラインを確認・編集してください（; 区切り）', state.outline.join('; '));
+            if (edited === null) {
+                this.addLog(`⏹️ 実行を保留しました (run ${runId})`, 'info');
+                return;
+            }
+            state.outline = edited.split(';').map(h => h.trim()).filter(h => h);
+            this.addLog(`✏️ ${state.outline.length}個の見出しでレポートを作成します`, 'info');
+            this.wsManager.sendResumeRequest(runId, state);
+            return;
+        }
+
+        // Other interrupts only ask for approval
+        if (confirm(`${this.graphManager.getNodeDisplayName(node)} を続行しますか？\nトピック: ${state.topic || ''}`)) {
+            this.wsManager.sendResumeRequest(runId, state);
+        } else {
+            this.addLog(`⏹️ 実行を保留しました (run ${runId})`, 'info');
+        }
+    }
+
+    handleNodeStart(node, path = null) {
         // Check if this is a dynamic search query node
-        if (node.startsWith('search_query_')) {
+        if (this.graphManager.isDynamicNode(node)) {
             this.graphManager.createDynamicSearchNode(node);
             this.graphManager.updateNodeStatus(node, 'in-progress', '実行中...');
-            this.addLog(`🔍 ${this.graphManager.getNodeDisplayName(node)}: 並行検索開始`, 'info');
+            this.addLog(`🔍 ${this.graphManager.getNodeDisplayName(node, path)}: 並行検索開始`, 'info');
         } else {
             this.graphManager.updateNodeStatus(node, 'in-progress', '実行中...');
-            this.addLog(`📍 ${this.graphManager.getNodeDisplayName(node)}: 開始`, 'info');
+            this.addLog(`📍 ${this.graphManager.getNodeDisplayName(node, path)}: 開始`, 'info');
         }
         
         // Start report section for report generation
@@ -99,15 +244,15 @@ class ResearchApp {
         
         // Special handling for different node types
         if (node === 'generate_search_queries') {
-            const queryCount = update.state?.search_queries?.length || 0;
+            const queryCount = this.runState?.search_queries?.length || 0;
             this.addLog(`✅ ${this.graphManager.getNodeDisplayName(node)}: ${queryCount}個のクエリを生成`, 'success');
         } else if (node === 'merge_search_results') {
             const dynamicNodeCount = this.graphManager.getDynamicNodeCount();
             this.addLog(`✅ ${this.graphManager.getNodeDisplayName(node)}: ${dynamicNodeCount}個の検索結果を統合`, 'success');
-        } else if (node.startsWith('search_query_')) {
+        } else if (this.graphManager.isDynamicNode(node)) {
             this.addLog(`✅ ${this.graphManager.getNodeDisplayName(node)}: 検索完了`, 'success');
         } else {
-            this.addLog(`✅ ${this.graphManager.getNodeDisplayName(node)}: 完了`, 'success');
+            this.addLog(`✅ ${this.graphManager.getNodeDisplayName(node, update.path)}: 完了`, 'success');
         }
         
         this.graphManager.updateProgress();
@@ -137,9 +282,14 @@ class ResearchApp {
         }
     }
 
-    handleError(node, error) {
-        this.graphManager.updateNodeStatus(node, 'error', 'エラー');
-        this.addLog(`❌ ${this.graphManager.getNodeDisplayName(node)}: ${error}`, 'error');
+    handleError(node, error, timeout = false) {
+        if (timeout) {
+            this.graphManager.updateNodeStatus(node, 'error', 'タイムアウト');
+            this.addLog(`⏰ ${this.graphManager.getNodeDisplayName(node)}: ${error}`, 'error');
+        } else {
+            this.graphManager.updateNodeStatus(node, 'error', 'エラー');
+            this.addLog(`❌ ${this.graphManager.getNodeDisplayName(node)}: ${error}`, 'error');
+        }
         this.wsManager.setResearchState(false);
     }
 
@@ -147,6 +297,7 @@ class ResearchApp {
         this.startTime = Date.now();
         this.executionTimer = setInterval(() => this.updateExecutionTime(), 1000);
         document.getElementById('startBtn').disabled = true;
+        document.getElementById('cancelBtn').disabled = false;
         this.graphManager.resetNodes();
         this.resetReportSection();
         this.addLog('🚀 調査を開始しました', 'info');
@@ -156,6 +307,7 @@ class ResearchApp {
     completeExecution() {
         clearInterval(this.executionTimer);
         document.getElementById('startBtn').disabled = false;
+        document.getElementById('cancelBtn').disabled = true;
         this.addLog('🎉 調査が完了しました', 'success');
         this.wsManager.setResearchState(false, true);
     }
@@ -6,19 +6,108 @@ class GraphManager {
             'classify_intent_and_topic': 'node-classify',
             'generate_search_queries': 'node-queries', 
             'execute_parallel_search': 'node-search',
+            'search_and_merge': 'node-search',
             'merge_search_results': 'node-merge',
             'synthesize_and_report': 'node-report'
         };
         
         this.dynamicNodes = [];
         this.originalFlow = null;
+        this.topology = null;
+    }
+
+    // Lay out the nodes of the engine's actual graph instead of the static fallback
+    async loadTopology() {
+        try {
+            const response = await fetch('/api/graph');
+            if (!response.ok) {
+                throw new Error(`HTTP ${response.status}`);
+            }
+            this.topology = await response.json();
+            this.renderTopology();
+        } catch (error) {
+            console.log(`Graph topology unavailable, keeping static layout: ${error}`);
+        }
+    }
+
+    renderTopology() {
+        const graphFlow = document.querySelector('.graph-flow');
+        const levels = this.topologyLevels();
+
+        this.nodeMapping = {};
+        graphFlow.innerHTML = levels.map(level => `
+            <div class="graph-level">
+                ${level.map(node => this.renderTopologyNode(node)).join('')}
+            </div>
+        `).join('<div class="arrow">→</div>');
+    }
+
+    // Group nodes by their distance from the entry node, so each column runs after the previous one
+    topologyLevels() {
+        const nodes = new Map(this.topology.nodes.map(node => [node.id, node]));
+        const depth = new Map([[this.topology.entry, 0]]);
+        const queue = [this.topology.entry];
+
+        while (queue.length > 0) {
+            const id = queue.shift();
+            this.topology.edges
+                .filter(edge => edge.from === id && !depth.has(edge.to))
+                .forEach(edge => {
+                    depth.set(edge.to, depth.get(id) + 1);
+                    queue.push(edge.to);
+                });
+        }
+
+        const levels = [];
+        depth.forEach((level, id) => {
+            const node = nodes.get(id);
+            if (!node || node.kind === 'start' || node.kind === 'end') {
+                return;
+            }
+            (levels[level] = levels[level] || []).push(node);
+        });
+        return levels.filter(level => level);
+    }
+
+    renderTopologyNode(node) {
+        if (node.kind === 'branch') {
+            const name = node.id.replace(/^branch:/, '');
+            return `
+                <div class="dynamic-search-area" id="branch-${name}">
+                    <div class="branch-info">${this.getNodeDisplayName(node.branch_node)} ×N</div>
+                </div>
+            `;
+        }
+
+        const elementId = `node-${node.id}`;
+        this.nodeMapping[node.id] = elementId;
+        return `
+            <div class="node pending" id="${elementId}">
+                <div class="node-title">${this.getNodeDisplayName(node.id)}</div>
+                <div class="node-status">待機中</div>
+            </div>
+        `;
+    }
+
+    isDynamicNode(nodeName) {
+        return this.branchArea(nodeName) !== null || nodeName.startsWith('search_query_');
+    }
+
+    // Find the branch point whose branches are named <branch>_<n>
+    branchArea(nodeName) {
+        if (!this.topology) {
+            return null;
+        }
+        const branch = this.topology.nodes.find(node =>
+            node.kind === 'branch' && nodeName.startsWith(node.id.replace(/^branch:/, '') + '_'));
+        return branch ? document.getElementById(`branch-${branch.id.replace(/^branch:/, '')}`) : null;
     }
 
     updateNodeStatus(nodeName, status, statusText) {
         let nodeId = this.nodeMapping[nodeName];
         
         // If not in static mapping, check if it's a dynamic node
-        if (!nodeId && nodeName.startsWith('search_query_')) {
+        if (!nodeId && this.isDynamicNode(nodeName)) {
             nodeId = 'dynamic-' + nodeName;
         }
         
@@ -40,13 +129,24 @@ class GraphManager {
         }
     }
 
-    getNodeDisplayName(nodeName) {
+    getNodeDisplayName(nodeName, path = null) {
+        // Nodes inside a subgraph are prefixed with the enclosing subgraph nodes
+        if (path && path.length > 1) {
+            return path.map(name => this.getNodeDisplayName(name)).join(' › ');
+        }
+
         const names = {
             'classify_intent_and_topic': '意図判定',
             'generate_search_queries': 'クエリ生成',
             'execute_parallel_search': '並行検索',
+            'search_and_merge': '検索・合流',
+            'dispatch_searches': '検索振り分け',
             'merge_search_results': '結果合流',
-            'synthesize_and_report': 'レポート生成'
+            'draft_outline': 'アウトライン作成',
+            'synthesize_and_report': 'レポート生成',
+            'answer_directly': '直接回答',
+            'handle_chat': 'チャット',
+            'search_query': '検索'
         };
         
         // Handle dynamic search query nodes
@@ -65,6 +165,18 @@ class GraphManager {
             return; // Node already exists
         }
         
+        // Branches of the rendered topology go to their branch point
+        const topologyArea = this.branchArea(nodeName);
+        if (topologyArea) {
+            this.dynamicNodes.push(nodeName);
+            topologyArea.appendChild(this.dynamicNodeElement(nodeName));
+            const branchInfo = topologyArea.querySelector('.branch-info');
+            if (branchInfo) {
+                branchInfo.textContent = `${topologyArea.querySelectorAll('.node').length}個の並行実行`;
+            }
+            return;
+        }
+
         // Get the graph flow container
         const graphFlow = document.querySelector('.graph-flow');
         
@@ -79,13 +191,7 @@ class GraphManager {
         }
         
         // Create the dynamic node
-        const node = document.createElement('div');
-        node.className = 'node pending';
-        node.id = nodeId;
-        node.innerHTML = `
-            <div class="node-title">🔍 ${this.getNodeDisplayName(nodeName)}</div>
-            <div class="node-status">待機中</div>
-        `;
+        const node = this.dynamicNodeElement(nodeName);
         
         // Add to dynamic nodes list
         this.dynamicNodes.push(nodeName);
@@ -105,6 +211,17 @@ class GraphManager {
         console.log(`Created dynamic node: ${nodeName} (total: ${this.dynamicNodes.length})`);
     }
 
+    dynamicNodeElement(nodeName) {
+        const node = document.createElement('div');
+        node.className = 'node pending';
+        node.id = 'dynamic-' + nodeName;
+        node.innerHTML = `
+            <div class="node-title">🔍 ${this.getNodeDisplayName(nodeName)}</div>
+            <div class="node-status">待機中</div>
+        `;
+        return node;
+    }
+
     createDynamicBranchingLayout() {
         const graphFlow = document.querySelector('.graph-flow');
         
@@ -141,6 +258,13 @@ class GraphManager {
     resetNodes() {
         // Reset dynamic nodes and layout
         this.dynamicNodes = [];
+
+        // The topology layout is simply drawn again
+        if (this.topology) {
+            this.renderTopology();
+            setTimeout(() => this.updateProgress(), 100);
+            return;
+        }
         
         // Restore original layout if it was changed
         if (this.originalFlow) {
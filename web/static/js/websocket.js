@@ -79,6 +79,42 @@ class WebSocketManager {
         }
     }
 
+    sendResumeRequest(runId, state = null) {
+        if (this.ws && this.ws.readyState === WebSocket.OPEN) {
+            const message = { type: 'resume', run_id: runId };
+            if (state) {
+                message.state = state;
+            }
+            this.ws.send(JSON.stringify(message));
+            return true;
+        } else {
+            this.addLog('WebSocket接続がありません', 'error');
+            return false;
+        }
+    }
+
+    sendCancelRequest(runId) {
+        if (this.ws && this.ws.readyState === WebSocket.OPEN) {
+            this.ws.send(JSON.stringify({
+                type: 'cancel',
+                run_id: runId
+            }));
+            return true;
+        }
+        return false;
+    }
+
+    sendSnapshotRequest(runId) {
+        if (this.ws && this.ws.readyState === WebSocket.OPEN) {
+            this.ws.send(JSON.stringify({
+                type: 'snapshot',
+                run_id: runId
+            }));
+            return true;
+        }
+        return false;
+    }
+
     setResearchState(researching, completed = false) {
         this.isResearching = researching;
         this.researchCompleted = completed;